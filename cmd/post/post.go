@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"time"
 
 	"github.com/VTGare/softserve-homework/internal/config"
@@ -33,18 +34,68 @@ func main() {
 		os.Exit(1)
 	}
 
-	//Connect to a Redis database
-	db, err := database.New(cfg.Redis.Host, cfg.Redis.Port)
-	if err != nil {
-		fmt.Println("Failed to connect to Redis. Error: ", err)
+	//Pick a post storage backend
+	var repo post.Repository
+	switch cfg.Backend {
+	case "", "redis":
+		db, err := database.New(cfg.Redis.Host, cfg.Redis.Port)
+		if err != nil {
+			fmt.Println("Failed to connect to Redis. Error: ", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		repo = post.NewRedisRepository(db)
+	case "sql":
+		db, err := database.NewSQL(cfg.SQL.Driver, cfg.SQL.DSN)
+		if err != nil {
+			fmt.Println("Failed to connect to SQL database. Error: ", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		if err := post.MigrateSQL(context.Background(), db, cfg.SQL.Driver); err != nil {
+			fmt.Println("Failed to migrate SQL database. Error: ", err)
+			os.Exit(1)
+		}
+
+		repo = post.NewSQLRepository(db, cfg.SQL.Driver)
+	case "memory":
+		sugar.Warn("Using in-memory storage, posts will be lost on shutdown")
+		repo = post.NewMemoryRepository()
+	default:
+		fmt.Printf("Unknown backend %q, use redis, sql or memory.\n", cfg.Backend)
 		os.Exit(1)
 	}
-	defer db.Close()
 
-	postService := post.NewService(db, sugar)
+	retention := post.DefaultTrashRetention
+	if cfg.TrashRetention != "" {
+		retention, err = time.ParseDuration(cfg.TrashRetention)
+		if err != nil || retention <= 0 {
+			fmt.Printf("Invalid trash retention %q, use a positive duration like 720h.\n", cfg.TrashRetention)
+			os.Exit(1)
+		}
+	}
+
+	authorPatterns := make([]*regexp.Regexp, 0, len(cfg.AuthorPatterns))
+	for _, pattern := range cfg.AuthorPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			fmt.Printf("Invalid author pattern %q: %v\n", pattern, err)
+			os.Exit(1)
+		}
+		authorPatterns = append(authorPatterns, re)
+	}
+
+	postService := post.NewService(repo, sugar, post.Options{MaxBodySize: cfg.MaxBodySize, AuthorPatterns: authorPatterns})
 	ep := endpoints.NewEndpointSet(postService)
 	srv := createServer(cfg, ep, sugar)
 
+	//Purge the trash in the background until shutdown.
+	purgeCtx, stopPurger := context.WithCancel(context.Background())
+	defer stopPurger()
+	go post.RunPurger(purgeCtx, postService, retention, time.Hour)
+
 	//Run the server in a goroutine to prevent locking.
 	go func() {
 		sugar.Infof("Listening on %v", srv.Addr)
@@ -61,6 +112,7 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	srv.Shutdown(ctx)
+	stopPurger()
 
 	sugar.Info("shutting down")
 	os.Exit(0)
@@ -73,11 +125,25 @@ func createServer(cfg *config.Config, ep *endpoints.Set, logger *zap.SugaredLogg
 	r.Use(middlewares.Logger(logger), middlewares.Recover(logger))
 
 	//Register all endpoints here
+	r.Methods("POST").Path("/api/posts/batch").HandlerFunc(ep.BatchAddEndpoint)
+	r.Methods("DELETE").Path("/api/posts/batch").HandlerFunc(ep.BatchDeleteEndpoint)
+	r.Methods("POST").Path("/api/posts/lookup").HandlerFunc(ep.LookupEndpoint)
 	r.Methods("GET").Path("/api/posts/{id}").HandlerFunc(ep.GetEndpoint)
+	r.Methods("GET").Path("/api/posts/{id}/html").HandlerFunc(ep.HTMLEndpoint)
+	r.Methods("PUT").Path("/api/posts/{id}").HandlerFunc(ep.UpdateEndpoint)
+	r.Methods("PATCH").Path("/api/posts/{id}").HandlerFunc(ep.PatchEndpoint)
 	r.Methods("DELETE").Path("/api/posts/{id}").HandlerFunc(ep.DeleteEndpoint)
+	r.Methods("POST").Path("/api/posts/{id}/restore").HandlerFunc(ep.RestoreEndpoint)
+	r.Methods("GET").Path("/api/posts/{id}/revisions").HandlerFunc(ep.RevisionsEndpoint)
+	r.Methods("GET").Path("/api/posts/{id}/revisions/{n}").HandlerFunc(ep.RevisionEndpoint)
+	r.Methods("POST").Path("/api/posts/{id}/revisions/{n}/revert").HandlerFunc(ep.RevertEndpoint)
 	r.Methods("GET").Path("/api/posts").HandlerFunc(ep.SearchEndpoint)
 	r.Methods("POST").Path("/api/posts").HandlerFunc(ep.AddEndpoint)
 	r.Methods("GET").Path("/api/count").HandlerFunc(ep.CountEndpoint)
+	r.Methods("GET").Path("/api/tags").HandlerFunc(ep.TagsEndpoint)
+	r.Methods("GET").Path("/api/trash").HandlerFunc(ep.TrashEndpoint)
+	r.Methods("GET").Path("/api/export").HandlerFunc(ep.ExportEndpoint)
+	r.Methods("POST").Path("/api/import").HandlerFunc(ep.ImportEndpoint)
 
 	return &http.Server{
 		Addr:         fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/VTGare/softserve-homework/internal/config"
+	"github.com/VTGare/softserve-homework/internal/database"
+	"github.com/VTGare/softserve-homework/pkg/post"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const usage = `Usage: postadmin <command>
+
+Commands:
+  backfill-indexes      add existing posts to time and full-text search indexes
+  rebuild-match-indexes rebuild case-insensitive and prefix name and author indexes
+  check                 report index entries and post counts that don't match stored posts
+  repair [-dry-run]     fix index entries and post counts that don't match stored posts, -dry-run only prints the fixes
+  export [-o file] [-full]
+                        write posts as newline-delimited JSON to a file or standard output,
+                        -full includes trashed and purged posts and revisions
+  import [file]         import posts or full export records from newline-delimited JSON in a file or standard input
+
+backfill-indexes, rebuild-match-indexes, check and repair only apply to the redis backend.`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	//Load app's configuration from config.json file in working directory
+	cfg, err := config.New("config.json")
+	if err != nil {
+		fmt.Println("Failed to load config. Error: ", err)
+		os.Exit(1)
+	}
+
+	repo, db, closeRepo, err := openRepository(cfg)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		os.Exit(1)
+	}
+	defer closeRepo()
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "backfill-indexes", "rebuild-match-indexes", "check", "repair":
+		//Index maintenance works on Redis keys directly, SQL databases are migrated on startup instead.
+		if db == nil {
+			err = fmt.Errorf("%v is only needed with the redis backend", os.Args[1])
+			break
+		}
+
+		switch os.Args[1] {
+		case "backfill-indexes":
+			err = backfillIndexes(ctx, db)
+		case "rebuild-match-indexes":
+			err = rebuildMatchIndexes(ctx, db)
+		case "check":
+			_, err = checkIndexes(ctx, db)
+		case "repair":
+			flags := flag.NewFlagSet("repair", flag.ExitOnError)
+			dryRun := flags.Bool("dry-run", false, "only print the fixes")
+			flags.Parse(os.Args[2:])
+
+			err = repairIndexes(ctx, db, *dryRun)
+		}
+	case "export":
+		flags := flag.NewFlagSet("export", flag.ExitOnError)
+		output := flags.String("o", "", "write to a file instead of standard output")
+		full := flags.Bool("full", false, "include trashed and purged posts and revisions")
+		flags.Parse(os.Args[2:])
+
+		err = exportPosts(ctx, repo, *output, *full)
+	case "import":
+		err = importPosts(ctx, repo, cfg, os.Args[2:])
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Println("Error: ", err)
+		os.Exit(1)
+	}
+}
+
+//openRepository connects to the configured backend like the server does. The Redis client is nil for other backends.
+func openRepository(cfg *config.Config) (post.Repository, *redis.Client, func(), error) {
+	switch cfg.Backend {
+	case "", "redis":
+		db, err := database.New(cfg.Redis.Host, cfg.Redis.Port)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+
+		return post.NewRedisRepository(db), db, func() { db.Close() }, nil
+	case "sql":
+		db, err := database.NewSQL(cfg.SQL.Driver, cfg.SQL.DSN)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to connect to SQL database: %w", err)
+		}
+
+		if err := post.MigrateSQL(context.Background(), db, cfg.SQL.Driver); err != nil {
+			db.Close()
+			return nil, nil, nil, fmt.Errorf("failed to migrate SQL database: %w", err)
+		}
+
+		return post.NewSQLRepository(db, cfg.SQL.Driver), nil, func() { db.Close() }, nil
+	case "memory":
+		return nil, nil, nil, errors.New("the memory backend keeps posts inside the server process, there's nothing to administer")
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown backend %q, use redis, sql or memory", cfg.Backend)
+	}
+}
+
+func backfillIndexes(ctx context.Context, db *redis.Client) error {
+	indexed, err := post.BackfillIndexes(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Indexed %v posts.\n", indexed)
+	return nil
+}
+
+func rebuildMatchIndexes(ctx context.Context, db *redis.Client) error {
+	indexed, err := post.RebuildMatchIndexes(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Indexed %v posts.\n", indexed)
+	return nil
+}
+
+//checkIndexes prints index problems and a summary.
+func checkIndexes(ctx context.Context, db *redis.Client) ([]post.IndexProblem, error) {
+	problems, err := post.CheckIndexes(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	count := make(map[post.ProblemKind]int)
+	for _, p := range problems {
+		fmt.Println(p)
+		count[p.Kind]++
+	}
+
+	fmt.Printf("Found %v dangling, %v missing and %v outdated entries.\n", count[post.Dangling], count[post.Missing], count[post.Outdated])
+	return problems, nil
+}
+
+//repairIndexes checks indexes and fixes found problems unless it's a dry run.
+func repairIndexes(ctx context.Context, db *redis.Client, dryRun bool) error {
+	problems, err := checkIndexes(ctx, db)
+	if err != nil || len(problems) == 0 {
+		return err
+	}
+
+	if dryRun {
+		fmt.Printf("Dry run, %v index entries would be repaired.\n", len(problems))
+		return nil
+	}
+
+	repaired, err := post.RepairIndexes(ctx, db, problems)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Repaired %v index entries, skipped %v of posts written since the check.\n", repaired, len(problems)-repaired)
+	return nil
+}
+
+//exportPosts writes posts to a file or standard output, full exports include trashed and purged posts and revisions.
+//The summary goes to standard error, so it doesn't mix with posts.
+func exportPosts(ctx context.Context, repo post.Repository, output string, full bool) error {
+	w := os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	svc := post.NewService(repo, zap.NewNop().Sugar(), post.Options{})
+	exported, err := post.ExportNDJSON(ctx, svc, w, full)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %v posts.\n", exported)
+	return nil
+}
+
+//importPosts imports posts from the file in args or standard input and prints posts that weren't imported.
+//Posts are validated against the configured author patterns like posts written to the server.
+func importPosts(ctx context.Context, repo post.Repository, cfg *config.Config, args []string) error {
+	r := os.Stdin
+	if len(args) != 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	authorPatterns := make([]*regexp.Regexp, 0, len(cfg.AuthorPatterns))
+	for _, pattern := range cfg.AuthorPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid author pattern %q: %w", pattern, err)
+		}
+		authorPatterns = append(authorPatterns, re)
+	}
+
+	svc := post.NewService(repo, zap.NewNop().Sugar(), post.Options{MaxBodySize: cfg.MaxBodySize, AuthorPatterns: authorPatterns})
+	report, err := post.ImportNDJSON(ctx, svc, r)
+	if report != nil {
+		for _, failed := range report.Failed {
+			fmt.Printf("Line %v: %v\n", failed.Line, failed.Err)
+		}
+
+		fmt.Printf("Imported %v posts, %v failed.\n", report.Imported, len(report.Failed))
+	}
+
+	return err
+}
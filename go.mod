@@ -1,13 +1,31 @@
 module github.com/VTGare/softserve-homework
 
-go 1.15
+go 1.20
 
 require (
 	github.com/go-redis/redis/v8 v8.6.0
-	github.com/go-redis/redismock/v8 v8.0.5 // indirect
+	github.com/go-redis/redismock/v8 v8.0.5
 	github.com/gorilla/mux v1.8.0
-	github.com/stretchr/testify v1.7.0 // indirect
-	go.uber.org/multierr v1.6.0 // indirect
+	github.com/lib/pq v1.10.0
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/stretchr/testify v1.7.0
+	github.com/vmihailenco/msgpack/v5 v5.2.3
+	github.com/yuin/goldmark v1.3.2
 	go.uber.org/zap v1.16.0
-	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	golang.org/x/text v0.3.5
+	google.golang.org/protobuf v1.26.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	go.opentelemetry.io/otel v0.17.0 // indirect
+	go.opentelemetry.io/otel/metric v0.17.0 // indirect
+	go.opentelemetry.io/otel/trace v0.17.0 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
@@ -7,12 +7,25 @@ import (
 
 //Config contains all application configuration.
 type Config struct {
-	Host  string `json:"host"`
-	Port  string `json:"port"`
-	Redis struct {
+	Host string `json:"host"`
+	Port string `json:"port"`
+	//Backend is a post storage, either "redis", "sql" or "memory". Redis is used if it's empty.
+	Backend string `json:"backend"`
+	Redis   struct {
 		Host string `json:"host"`
 		Port string `json:"port"`
 	} `json:"redis"`
+	SQL struct {
+		//Driver is either "sqlite3" or "postgres".
+		Driver string `json:"driver"`
+		DSN    string `json:"dsn"`
+	} `json:"sql"`
+	//MaxBodySize is a post body size limit in bytes. The service default is used if it's zero.
+	MaxBodySize int `json:"max_body_size"`
+	//TrashRetention is how long removed posts are kept in the trash, e.g. "720h". The service default is used if it's empty.
+	TrashRetention string `json:"trash_retention"`
+	//AuthorPatterns are regular expressions, post authors must match at least one of them. Any author is allowed if it's empty.
+	AuthorPatterns []string `json:"author_patterns"`
 }
 
 //New returns a new Config from a file located in path.
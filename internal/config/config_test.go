@@ -20,8 +20,9 @@ func TestNew(t *testing.T) {
 		{
 			name: "Successful config",
 			expected: &Config{
-				Host: "localhost",
-				Port: "8080",
+				Host:    "localhost",
+				Port:    "8080",
+				Backend: "memory",
 				Redis: struct {
 					Host string "json:\"host\""
 					Port string "json:\"port\""
@@ -35,7 +36,7 @@ func TestNew(t *testing.T) {
 				file, _ := os.Create("test.json")
 				defer file.Close()
 
-				io.WriteString(file, `{"host": "localhost","port":"8080","redis":{"host":"127.0.0.1", "port":"6379"}}`)
+				io.WriteString(file, `{"host": "localhost","port":"8080","backend":"memory","redis":{"host":"127.0.0.1", "port":"6379"}}`)
 			},
 			cleanup: func() error {
 				return os.Remove("test.json")
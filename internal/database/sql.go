@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	//SQL drivers supported by post.MigrateSQL.
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//NewSQL connects to a SQL database. Driver is either "sqlite3" or "postgres".
+func NewSQL(driver, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	//SQLite allows a single writer, concurrent connections would fail with "database is locked".
+	if driver == "sqlite3" {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.PingContext(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,199 @@
+package post
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//BackfillIndexes adds every stored post to the time, full-text, tag and match indexes used by FindMany, rebuilds author and tag post counts
+//and returns the number of indexed posts. Trashed posts are only added to the trash. It's meant for data created before these indexes were introduced and is safe to run multiple times.
+//
+//Posts created or removed while it runs may be miscounted, run it again if the service wasn't stopped.
+func BackfillIndexes(ctx context.Context, db *redis.Client) (int, error) {
+	var (
+		cursor  uint64
+		indexed int
+		counts  = make(map[string]int64)
+		tagged  = make(map[string]int64)
+		//SCAN may return a key more than once, a post must be counted once.
+		counted = make(map[int64]bool)
+	)
+
+	for {
+		keys, next, err := db.Scan(ctx, cursor, "post:*", 100).Result()
+		if err != nil {
+			return indexed, err
+		}
+
+		fields := make(map[int64]*redis.SliceCmd, len(keys))
+		_, err = db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				id, err := strconv.ParseInt(strings.TrimPrefix(key, "post:"), 10, 64)
+				if err != nil {
+					return err
+				}
+
+				fields[id] = pipe.HMGet(ctx, key, "name", "author", "created_at", "tags", "deleted_at")
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return indexed, err
+		}
+
+		_, err = db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for id, cmd := range fields {
+				vals := cmd.Val()
+				name, _ := vals[0].(string)
+				author, _ := vals[1].(string)
+				created, _ := vals[2].(string)
+				tags, _ := vals[3].(string)
+
+				if deleted, ok := vals[4].(string); ok {
+					unix, err := strconv.ParseInt(deleted, 10, 64)
+					if err != nil {
+						return fmt.Errorf("post %v has invalid deleted_at: %w", id, err)
+					}
+
+					pipe.ZAdd(ctx, "trash", &redis.Z{Score: float64(unix), Member: id})
+					continue
+				}
+
+				createdAt, err := parseUnix(created)
+				if err != nil {
+					return fmt.Errorf("post %v has invalid created_at: %w", id, err)
+				}
+
+				score := timeScore(createdAt)
+
+				if !counted[id] {
+					counted[id] = true
+					counts[author]++
+					for _, tag := range splitTags(tags) {
+						tagged[tag]++
+					}
+				}
+
+				z := &redis.Z{Score: score, Member: id}
+				pipe.ZAdd(ctx, "timeline", z)
+				pipe.ZAdd(ctx, fmt.Sprintf("timeline:names:%v", name), z)
+				pipe.ZAdd(ctx, fmt.Sprintf("timeline:authors:%v", author), z)
+				for _, t := range tokenize(name) {
+					pipe.ZAdd(ctx, fmt.Sprintf("terms:%v", t.value), &redis.Z{Score: float64(t.count), Member: id})
+				}
+
+				for _, tag := range splitTags(tags) {
+					pipe.SAdd(ctx, fmt.Sprintf("tags:%v", tag), id)
+				}
+				addMatchKeys(ctx, pipe, id, name, author)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return indexed, err
+		}
+		indexed += len(fields)
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	_, err := db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, "author_counts")
+		for author, count := range counts {
+			pipe.ZAdd(ctx, "author_counts", &redis.Z{Score: float64(count), Member: author})
+		}
+
+		pipe.Del(ctx, "tag_counts")
+		for tag, count := range tagged {
+			pipe.ZAdd(ctx, "tag_counts", &redis.Z{Score: float64(count), Member: tag})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return indexed, err
+	}
+
+	return indexed, nil
+}
+
+//RebuildMatchIndexes replaces the case-insensitive name and author sets and the lex sets of their keys used for prefix matching
+//with ones built from stored posts and returns the number of indexed posts. Trashed posts aren't indexed.
+//It's meant for data created before these indexes were introduced or keys were normalized differently.
+//
+//Posts written while it runs may be left out, run it again if the service wasn't stopped.
+func RebuildMatchIndexes(ctx context.Context, db *redis.Client) (int, error) {
+	for _, pattern := range []string{"ci:*", "lex:*"} {
+		err := scanKeys(ctx, db, pattern, func(keys []string) error {
+			return db.Del(ctx, keys...).Err()
+		})
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	//SCAN may return a key more than once, a post must be counted once.
+	indexed := make(map[int64]bool)
+	err := scanKeys(ctx, db, "post:*", func(keys []string) error {
+		ids := make([]int64, 0, len(keys))
+		fields := make([]*redis.SliceCmd, 0, len(keys))
+		_, err := db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				id, err := strconv.ParseInt(strings.TrimPrefix(key, "post:"), 10, 64)
+				if err != nil {
+					return err
+				}
+
+				ids = append(ids, id)
+				fields = append(fields, pipe.HMGet(ctx, key, "name", "author", "deleted_at"))
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, id := range ids {
+				vals := fields[i].Val()
+				if vals[2] != nil {
+					continue
+				}
+
+				name, _ := vals[0].(string)
+				author, _ := vals[1].(string)
+				addMatchKeys(ctx, pipe, id, name, author)
+				indexed[id] = true
+			}
+
+			return nil
+		})
+
+		return err
+	})
+
+	return len(indexed), err
+}
+
+//addMatchKeys adds a post to the case-insensitive sets of its name and author keys and the keys to lex sets.
+func addMatchKeys(ctx context.Context, pipe redis.Pipeliner, id int64, name, author string) {
+	pipe.SAdd(ctx, fmt.Sprintf("ci:names:%v", matchKey(name)), id)
+	pipe.SAdd(ctx, fmt.Sprintf("ci:authors:%v", matchKey(author)), id)
+	pipe.ZAdd(ctx, "lex:names", &redis.Z{Member: matchKey(name)})
+	pipe.ZAdd(ctx, "lex:authors", &redis.Z{Member: matchKey(author)})
+}
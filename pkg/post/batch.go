@@ -0,0 +1,76 @@
+package post
+
+//MaxBatchSize is the largest number of items in a batch write.
+const MaxBatchSize = 1000
+
+//batchChunkSize is the number of batch items written in one pipeline or transaction.
+const batchChunkSize = 100
+
+//ErrBatchAborted is the error of batch items that weren't written because another item of an all-or-nothing batch failed.
+var ErrBatchAborted error = &kindError{kind: ErrConflict, msg: "not written, another item of the batch failed"}
+
+//BatchResult is an outcome of a batch item, either the ID of a written post or an error.
+type BatchResult struct {
+	ID  int64
+	Err error
+}
+
+//checkBatch reports batches that are too large.
+func checkBatch(n int) error {
+	if n > MaxBatchSize {
+		return Errorf(ErrInvalid, "batch can't have more than %v items", MaxBatchSize)
+	}
+
+	return nil
+}
+
+//forChunks calls fn with bounds of consecutive chunks of n batch items. An atomic batch is a single chunk.
+func forChunks(n int, atomic bool, fn func(start, end int)) {
+	size := batchChunkSize
+	if atomic {
+		size = n
+	}
+
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		fn(start, end)
+	}
+}
+
+//splitFound returns found posts in the order of IDs and the IDs missing from found.
+func splitFound(ids []int64, found map[int64]*Post) ([]*Post, []int64) {
+	posts := make([]*Post, 0, len(found))
+	missing := make([]int64, 0)
+	for _, id := range ids {
+		if post, ok := found[id]; ok {
+			posts = append(posts, post)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	return posts, missing
+}
+
+//failBatch sets err as the error of results that don't have one.
+func failBatch(results []BatchResult, err error) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BatchResult{Err: err}
+		}
+	}
+}
+
+//failed reports whether any of results has an error.
+func failed(results []BatchResult) bool {
+	for _, res := range results {
+		if res.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
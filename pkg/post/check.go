@@ -0,0 +1,504 @@
+package post
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//ProblemKind is a kind of an index inconsistency.
+type ProblemKind string
+
+//Index problem kinds.
+const (
+	//Dangling entries point at a removed post or at a post that no longer has the indexed value.
+	Dangling ProblemKind = "dangling"
+	//Missing entries belong to a stored post but aren't indexed.
+	Missing ProblemKind = "missing"
+	//Outdated entries are indexed with a wrong score, e.g. an old creation time.
+	Outdated ProblemKind = "outdated"
+)
+
+//IndexProblem is an index entry that doesn't match stored posts.
+type IndexProblem struct {
+	Kind ProblemKind
+	Key  string
+	ID   int64
+	//Member is the author or tag of an author_counts or tag_counts entry, ID is zero for them.
+	Member string
+	//Score is the expected score of missing and outdated sorted set entries.
+	Score float64
+	//Version is the version of the post when it was checked, zero for posts written before versioning and -1 if it didn't exist.
+	Version int64
+}
+
+//String returns a human-readable description of the problem.
+func (p IndexProblem) String() string {
+	var member interface{} = p.ID
+	if isCounter(p.Key) {
+		member = p.Member
+	}
+
+	switch p.Kind {
+	case Dangling:
+		return fmt.Sprintf("%v: %v is dangling", p.Key, member)
+	case Outdated:
+		return fmt.Sprintf("%v: %v should have score %v", p.Key, member, p.Score)
+	default:
+		return fmt.Sprintf("%v: %v is missing", p.Key, member)
+	}
+}
+
+//indexPatterns are the SCAN patterns of every index kept next to post hashes.
+//Lex sets of name and author keys aren't checked, RebuildMatchIndexes rebuilds them.
+var indexPatterns = []string{"names:*", "authors:*", "ci:*", "timeline*", "terms:*", "tags:*", "trash"}
+
+//counterKeys are sorted sets of post counts of authors and tags.
+var counterKeys = []string{"author_counts", "tag_counts"}
+
+//indexSnapshot is what indexes should look like according to post hashes read by expectedIndexes.
+type indexSnapshot struct {
+	//entries are index entries of posts by index key, scores of set members are zero.
+	entries map[string]map[int64]float64
+	//counts are post counts by counter key and author or tag.
+	counts map[string]map[string]float64
+	//versions are versions of read posts.
+	versions map[int64]int64
+}
+
+//CheckIndexes compares name, author, case-insensitive, time, full-text, tag and trash indexes and author and tag post counts
+//with stored post hashes and returns every inconsistency. It only reads the database, pass the result to RepairIndexes to fix the problems.
+//
+//Post hashes are read before indexes, so entries of posts written in between are read again and left out if the post has changed.
+//Post counts changed by such writes may still be reported, RepairIndexes recounts them anyway.
+func CheckIndexes(ctx context.Context, db *redis.Client) ([]IndexProblem, error) {
+	snapshot, err := expectedIndexes(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	expected := snapshot.entries
+
+	var problems []IndexProblem
+	seen := make(map[string]bool)
+	for _, pattern := range indexPatterns {
+		err := scanKeys(ctx, db, pattern, func(keys []string) error {
+			//SCAN may return a key more than once.
+			unseen := make([]string, 0, len(keys))
+			for _, key := range keys {
+				if !seen[key] {
+					seen[key] = true
+					unseen = append(unseen, key)
+				}
+			}
+
+			found, err := checkKeys(ctx, db, unseen, expected)
+			problems = append(problems, found...)
+			return err
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//Entries left in expected weren't found in any index.
+	for key, members := range expected {
+		for id, score := range members {
+			problems = append(problems, IndexProblem{Kind: Missing, Key: key, ID: id, Score: score})
+		}
+	}
+
+	problems, err = unchangedPosts(ctx, db, problems, snapshot.versions)
+	if err != nil {
+		return nil, err
+	}
+
+	counterProblems, err := checkCounters(ctx, db, snapshot.counts)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, counterProblems...)
+
+	sort.Slice(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+
+		return a.Member < b.Member
+	})
+
+	return problems, nil
+}
+
+//RepairIndexes removes dangling index entries, adds missing or outdated ones and recounts posts of authors and tags with wrong counts.
+//It returns the number of repaired entries.
+//
+//Every entry is fixed atomically and only if its post still has the version it was checked at, entries of posts written since
+//the check are skipped. Counts are set to the number of posts in author and tag sets after the sets are repaired.
+func RepairIndexes(ctx context.Context, db *redis.Client, problems []IndexProblem) (int, error) {
+	for _, script := range []*redis.Script{repairScript, repairCountScript} {
+		if err := loadScript(ctx, db, script); err != nil {
+			return 0, err
+		}
+	}
+
+	entries := make([]*redis.Cmd, 0, len(problems))
+	counts := make([]*redis.Cmd, 0)
+	_, err := db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, p := range problems {
+			if isCounter(p.Key) {
+				continue
+			}
+
+			var command string
+			set := isSetIndex(p.Key)
+			switch {
+			case p.Kind == Dangling && set:
+				command = "SREM"
+			case p.Kind == Dangling:
+				command = "ZREM"
+			case set:
+				command = "SADD"
+			default:
+				command = "ZADD"
+			}
+
+			keys := []string{p.Key, fmt.Sprintf("post:%v", p.ID)}
+			entries = append(entries, repairScript.EvalSha(ctx, pipe, keys, p.ID, p.Version, command, p.Score))
+		}
+
+		//Counts are repaired after the sets they're counted from.
+		for _, p := range problems {
+			if isCounter(p.Key) {
+				counts = append(counts, repairCountScript.EvalSha(ctx, pipe, []string{p.Key, counterSet(p.Key, p.Member)}, p.Member))
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	repaired := len(counts)
+	for _, cmd := range entries {
+		if cmd.Val() == int64(1) {
+			repaired++
+		}
+	}
+
+	return repaired, nil
+}
+
+//expectedIndexes reads every post hash and returns index entries and post counts they should have.
+func expectedIndexes(ctx context.Context, db *redis.Client) (*indexSnapshot, error) {
+	snapshot := &indexSnapshot{
+		entries:  make(map[string]map[int64]float64),
+		counts:   map[string]map[string]float64{"author_counts": {}, "tag_counts": {}},
+		versions: make(map[int64]int64),
+	}
+	expected := snapshot.entries
+	add := func(key string, id int64, score float64) {
+		if expected[key] == nil {
+			expected[key] = make(map[int64]float64)
+		}
+		expected[key][id] = score
+	}
+
+	err := scanKeys(ctx, db, "post:*", func(keys []string) error {
+		ids := make([]int64, 0, len(keys))
+		fields := make([]*redis.SliceCmd, 0, len(keys))
+		_, err := db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				id, err := strconv.ParseInt(strings.TrimPrefix(key, "post:"), 10, 64)
+				if err != nil {
+					return err
+				}
+
+				ids = append(ids, id)
+				fields = append(fields, pipe.HMGet(ctx, key, "name", "author", "created_at", "tags", "deleted_at", "version"))
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for i, id := range ids {
+			vals := fields[i].Val()
+			name, _ := vals[0].(string)
+			author, _ := vals[1].(string)
+			created, _ := vals[2].(string)
+			tags, _ := vals[3].(string)
+
+			//SCAN may return a key more than once, a post must be counted once.
+			if _, ok := snapshot.versions[id]; ok {
+				continue
+			}
+
+			version, err := hashVersion(vals[5])
+			if err != nil {
+				return fmt.Errorf("post %v has invalid version: %w", id, err)
+			}
+			snapshot.versions[id] = version
+
+			//Trashed posts are only indexed in the trash.
+			if deleted, ok := vals[4].(string); ok {
+				unix, err := strconv.ParseInt(deleted, 10, 64)
+				if err != nil {
+					return fmt.Errorf("post %v has invalid deleted_at: %w", id, err)
+				}
+
+				add("trash", id, float64(unix))
+				continue
+			}
+
+			createdAt, err := parseUnix(created)
+			if err != nil {
+				return fmt.Errorf("post %v has invalid created_at: %w", id, err)
+			}
+
+			score := timeScore(createdAt)
+
+			add(fmt.Sprintf("names:%v", name), id, 0)
+			add(fmt.Sprintf("authors:%v", author), id, 0)
+			add(fmt.Sprintf("ci:names:%v", matchKey(name)), id, 0)
+			add(fmt.Sprintf("ci:authors:%v", matchKey(author)), id, 0)
+			add("timeline", id, score)
+			add(fmt.Sprintf("timeline:names:%v", name), id, score)
+			add(fmt.Sprintf("timeline:authors:%v", author), id, score)
+			for _, t := range tokenize(name) {
+				add(fmt.Sprintf("terms:%v", t.value), id, float64(t.count))
+			}
+
+			snapshot.counts["author_counts"][author]++
+			for _, tag := range splitTags(tags) {
+				add(fmt.Sprintf("tags:%v", tag), id, 0)
+				snapshot.counts["tag_counts"][tag]++
+			}
+		}
+
+		return nil
+	})
+
+	return snapshot, err
+}
+
+//unchangedPosts reads versions of posts with index problems again and leaves out problems of posts written since the snapshot
+//was read. Versions of the snapshot are set to the remaining problems.
+func unchangedPosts(ctx context.Context, db *redis.Client, problems []IndexProblem, versions map[int64]int64) ([]IndexProblem, error) {
+	fields := make(map[int64]*redis.SliceCmd)
+	ids := make([]int64, 0, len(problems))
+	for _, p := range problems {
+		if _, ok := fields[p.ID]; !ok {
+			fields[p.ID] = nil
+			ids = append(ids, p.ID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	_, err := db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			fields[id] = pipe.HMGet(ctx, fmt.Sprintf("post:%v", id), "created_at", "version")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	unchanged := make([]IndexProblem, 0, len(problems))
+	for _, p := range problems {
+		checked, ok := versions[p.ID]
+		if !ok {
+			checked = -1
+		}
+
+		//Every post hash has a creation time.
+		current := int64(-1)
+		if vals := fields[p.ID].Val(); vals[0] != nil {
+			current, err = hashVersion(vals[1])
+			if err != nil {
+				return nil, fmt.Errorf("post %v has invalid version: %w", p.ID, err)
+			}
+		}
+
+		if current == checked {
+			p.Version = checked
+			unchanged = append(unchanged, p)
+		}
+	}
+
+	return unchanged, nil
+}
+
+//checkCounters compares author and tag post counts with expected ones.
+func checkCounters(ctx context.Context, db *redis.Client, expected map[string]map[string]float64) ([]IndexProblem, error) {
+	counters := make([]*redis.ZSliceCmd, len(counterKeys))
+	_, err := db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range counterKeys {
+			counters[i] = pipe.ZRangeWithScores(ctx, key, 0, -1)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []IndexProblem
+	for i, key := range counterKeys {
+		counts := expected[key]
+		found := make(map[string]bool)
+		for _, z := range counters[i].Val() {
+			member := fmt.Sprint(z.Member)
+			found[member] = true
+
+			want, ok := counts[member]
+			switch {
+			case !ok:
+				problems = append(problems, IndexProblem{Kind: Dangling, Key: key, Member: member})
+			case want != z.Score:
+				problems = append(problems, IndexProblem{Kind: Outdated, Key: key, Member: member, Score: want})
+			}
+		}
+
+		for member, count := range counts {
+			if !found[member] {
+				problems = append(problems, IndexProblem{Kind: Missing, Key: key, Member: member, Score: count})
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+//checkKeys compares members of index keys with expected entries. Matching entries are removed from expected.
+func checkKeys(ctx context.Context, db *redis.Client, keys []string, expected map[string]map[int64]float64) ([]IndexProblem, error) {
+	sets := make(map[string]*redis.StringSliceCmd)
+	zsets := make(map[string]*redis.ZSliceCmd)
+	_, err := db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			if isSetIndex(key) {
+				sets[key] = pipe.SMembers(ctx, key)
+			} else {
+				zsets[key] = pipe.ZRangeWithScores(ctx, key, 0, -1)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []IndexProblem
+	check := func(key string, member interface{}, score float64) error {
+		id, err := strconv.ParseInt(fmt.Sprint(member), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%v has invalid member %q", key, member)
+		}
+
+		want, ok := expected[key][id]
+		switch {
+		case !ok:
+			problems = append(problems, IndexProblem{Kind: Dangling, Key: key, ID: id})
+			return nil
+		case want != score:
+			problems = append(problems, IndexProblem{Kind: Outdated, Key: key, ID: id, Score: want})
+		}
+
+		delete(expected[key], id)
+		if len(expected[key]) == 0 {
+			delete(expected, key)
+		}
+
+		return nil
+	}
+
+	for _, key := range keys {
+		if cmd, ok := sets[key]; ok {
+			for _, member := range cmd.Val() {
+				if err := check(key, member, 0); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		for _, z := range zsets[key].Val() {
+			if err := check(key, z.Member, z.Score); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+//scanKeys calls fn with every page of keys matching pattern.
+func scanKeys(ctx context.Context, db *redis.Client, pattern string, fn func([]string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := db.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) != 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+//hashVersion parses the version field of a post hash, posts written before versioning don't have one.
+func hashVersion(val interface{}) (int64, error) {
+	version, ok := val.(string)
+	if !ok {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(version, 10, 64)
+}
+
+//isCounter reports whether a key is a sorted set of author or tag post counts.
+func isCounter(key string) bool {
+	return key == "author_counts" || key == "tag_counts"
+}
+
+//counterSet returns the key of the set whose size is the count of an author or a tag in a counter.
+func counterSet(counter, member string) string {
+	if counter == "author_counts" {
+		return "authors:" + member
+	}
+
+	return "tags:" + member
+}
+
+//isSetIndex reports whether an index key is a set, other indexes are sorted sets.
+func isSetIndex(key string) bool {
+	return strings.HasPrefix(key, "names:") || strings.HasPrefix(key, "authors:") || strings.HasPrefix(key, "ci:") || strings.HasPrefix(key, "tags:")
+}
@@ -0,0 +1,105 @@
+package post
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIndexes(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+
+	//Post 3 is in the trash, but the trash doesn't have it.
+	mock.ExpectScan(0, "post:*", 100).SetVal([]string{"post:1", "post:3"}, 0)
+	mock.ExpectHMGet("post:1", "name", "author", "created_at", "tags", "deleted_at", "version").SetVal([]interface{}{"test", "vt", "5", "go", nil, "2"})
+	mock.ExpectHMGet("post:3", "name", "author", "created_at", "tags", "deleted_at", "version").SetVal([]interface{}{"old", "vt", "1", nil, "9", "4"})
+
+	//Post 2 was removed, but it's still in name and author sets.
+	mock.ExpectScan(0, "names:*", 100).SetVal([]string{"names:test", "names:old"}, 7)
+	mock.ExpectSMembers("names:test").SetVal([]string{"1"})
+	mock.ExpectSMembers("names:old").SetVal([]string{"2"})
+	mock.ExpectScan(7, "names:*", 100).SetVal([]string{"names:test"}, 0)
+	mock.ExpectScan(0, "authors:*", 100).SetVal([]string{"authors:vt"}, 0)
+	mock.ExpectSMembers("authors:vt").SetVal([]string{"1", "2"})
+	mock.ExpectScan(0, "ci:*", 100).SetVal([]string{"ci:names:test", "ci:authors:vt"}, 0)
+	mock.ExpectSMembers("ci:names:test").SetVal([]string{"1"})
+	mock.ExpectSMembers("ci:authors:vt").SetVal([]string{"1", "2"})
+
+	//Post 1 is missing from its author timeline and has an old creation time in its name timeline.
+	//Post 4 was created after post hashes were read.
+	mock.ExpectScan(0, "timeline*", 100).SetVal([]string{"timeline", "timeline:names:test"}, 0)
+	mock.ExpectZRangeWithScores("timeline", 0, -1).SetVal([]redis.Z{{Score: 5, Member: "1"}, {Score: 6, Member: "4"}})
+	mock.ExpectZRangeWithScores("timeline:names:test", 0, -1).SetVal([]redis.Z{{Score: 4, Member: "1"}})
+	mock.ExpectScan(0, "terms:*", 100).SetVal([]string{}, 0)
+
+	//Post 1 was untagged rust.
+	mock.ExpectScan(0, "tags:*", 100).SetVal([]string{"tags:go", "tags:rust"}, 0)
+	mock.ExpectSMembers("tags:go").SetVal([]string{"1"})
+	mock.ExpectSMembers("tags:rust").SetVal([]string{"1"})
+	mock.ExpectScan(0, "trash", 100).SetVal([]string{"trash"}, 0)
+	mock.ExpectZRangeWithScores("trash", 0, -1).SetVal([]redis.Z{{Score: 1, Member: "2"}})
+
+	//Posts with problems are read again, only post 4 has changed.
+	mock.ExpectHMGet("post:1", "created_at", "version").SetVal([]interface{}{"5", "2"})
+	mock.ExpectHMGet("post:2", "created_at", "version").SetVal([]interface{}{nil, nil})
+	mock.ExpectHMGet("post:3", "created_at", "version").SetVal([]interface{}{"1", "4"})
+	mock.ExpectHMGet("post:4", "created_at", "version").SetVal([]interface{}{"6", "1"})
+
+	//The author count over-reports vt and still has robot, go isn't counted.
+	mock.ExpectZRangeWithScores("author_counts", 0, -1).SetVal([]redis.Z{{Score: 1, Member: "robot"}, {Score: 3, Member: "vt"}})
+	mock.ExpectZRangeWithScores("tag_counts", 0, -1).SetVal([]redis.Z{})
+
+	problems, err := CheckIndexes(context.Background(), client)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []IndexProblem{
+			{Kind: Dangling, Key: "author_counts", Member: "robot"},
+			{Kind: Outdated, Key: "author_counts", Member: "vt", Score: 1},
+			{Kind: Dangling, Key: "authors:vt", ID: 2, Version: -1},
+			{Kind: Dangling, Key: "ci:authors:vt", ID: 2, Version: -1},
+			{Kind: Dangling, Key: "names:old", ID: 2, Version: -1},
+			{Kind: Missing, Key: "tag_counts", Member: "go", Score: 1},
+			{Kind: Dangling, Key: "tags:rust", ID: 1, Version: 2},
+			{Kind: Missing, Key: "terms:test", ID: 1, Score: 1, Version: 2},
+			{Kind: Missing, Key: "timeline:authors:vt", ID: 1, Score: 5, Version: 2},
+			{Kind: Outdated, Key: "timeline:names:test", ID: 1, Score: 5, Version: 2},
+			{Kind: Dangling, Key: "trash", ID: 2, Version: -1},
+			{Kind: Missing, Key: "trash", ID: 3, Score: 9, Version: 4},
+		}, problems)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	assert.Equal(t, "author_counts: vt should have score 1", problems[1].String())
+	assert.Equal(t, "names:old: 2 is dangling", problems[4].String())
+	assert.Equal(t, "terms:test: 1 is missing", problems[7].String())
+	assert.Equal(t, "timeline:names:test: 1 should have score 5", problems[9].String())
+
+	//Post 1 is updated before its terms entry is repaired, so the entry is skipped.
+	mock.ClearExpect()
+	mock.ExpectScriptExists(repairScript.Hash()).SetVal([]bool{true})
+	mock.ExpectScriptExists(repairCountScript.Hash()).SetVal([]bool{true})
+	expectRepair := func(key string, id int64, version int64, command string, score float64) *redismock.ExpectedCmd {
+		return mock.ExpectEvalSha(repairScript.Hash(), []string{key, fmt.Sprintf("post:%v", id)}, id, version, command, score)
+	}
+	expectRepair("authors:vt", 2, -1, "SREM", 0).SetVal(int64(1))
+	expectRepair("ci:authors:vt", 2, -1, "SREM", 0).SetVal(int64(1))
+	expectRepair("names:old", 2, -1, "SREM", 0).SetVal(int64(1))
+	expectRepair("tags:rust", 1, 2, "SREM", 0).SetVal(int64(1))
+	expectRepair("terms:test", 1, 2, "ZADD", 1).SetVal(int64(0))
+	expectRepair("timeline:authors:vt", 1, 2, "ZADD", 5).SetVal(int64(0))
+	expectRepair("timeline:names:test", 1, 2, "ZADD", 5).SetVal(int64(0))
+	expectRepair("trash", 2, -1, "ZREM", 0).SetVal(int64(1))
+	expectRepair("trash", 3, 4, "ZADD", 9).SetVal(int64(1))
+	mock.ExpectEvalSha(repairCountScript.Hash(), []string{"author_counts", "authors:robot"}, "robot").SetVal(int64(0))
+	mock.ExpectEvalSha(repairCountScript.Hash(), []string{"author_counts", "authors:vt"}, "vt").SetVal(int64(1))
+	mock.ExpectEvalSha(repairCountScript.Hash(), []string{"tag_counts", "tags:go"}, "go").SetVal(int64(1))
+
+	repaired, err := RepairIndexes(context.Background(), client, problems)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 9, repaired)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
@@ -0,0 +1,112 @@
+package post
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type importKey struct{}
+
+//WithImport returns a context for importing posts. Posts created with it keep their created_at and updated_at,
+//otherwise the service assigns both.
+func WithImport(ctx context.Context) context.Context {
+	return context.WithValue(ctx, importKey{}, true)
+}
+
+//importing reports whether ctx was returned by WithImport.
+func importing(ctx context.Context) bool {
+	imported, _ := ctx.Value(importKey{}).(bool)
+	return imported
+}
+
+//clocked is implemented by repositories that stamp writes with their own clock, so the service can share its clock with them.
+type clocked interface {
+	withClock(now func() time.Time) Repository
+}
+
+//storedTime returns t the way repositories read it back: truncated to a microsecond, in the local time zone and without
+//a monotonic clock reading. Microseconds are the precision of time index scores, so indexes compare times exactly.
+func storedTime(t time.Time) time.Time {
+	return time.Unix(t.Unix(), int64(t.Nanosecond()/1e3*1e3))
+}
+
+//ceilMicro rounds t up to a microsecond. Stored times are at or after a bound exactly when they're at or after its ceiling.
+func ceilMicro(t time.Time) time.Time {
+	ceil := storedTime(t)
+	if ceil.Before(t) {
+		ceil = ceil.Add(time.Microsecond)
+	}
+
+	return ceil
+}
+
+//timeScore returns a stored time as a time index score, fractional seconds since Unix epoch. Scores of stored times
+//are distinct and ordered like the times and whole seconds are scores of themselves.
+func timeScore(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond()/1e3)/1e6
+}
+
+//scoreTime returns the stored time of a time index score.
+func scoreTime(score float64) time.Time {
+	micros := int64(math.Round(score * 1e6))
+	sec, usec := micros/1e6, micros%1e6
+	if usec < 0 {
+		sec, usec = sec-1, usec+1e6
+	}
+
+	return time.Unix(sec, usec*1e3)
+}
+
+//updateTime returns the last update time of a post being created. Posts that don't have one were last updated on creation.
+func updateTime(post *Post) time.Time {
+	if post.UpdatedAt.IsZero() {
+		return post.CreatedAt
+	}
+
+	return post.UpdatedAt
+}
+
+//formatUnix formats t as Unix seconds followed by a fraction of a second if there is one, e.g. "1612345678.25".
+func formatUnix(t time.Time) string {
+	unix := strconv.FormatInt(t.Unix(), 10)
+	if t.Nanosecond() == 0 {
+		return unix
+	}
+
+	return unix + "." + strings.TrimRight(fmt.Sprintf("%09d", t.Nanosecond()), "0")
+}
+
+//parseUnix parses a time formatted by formatUnix. Whole seconds written by older versions are accepted as well.
+func parseUnix(s string) (time.Time, error) {
+	i := strings.IndexByte(s, '.')
+	if i == -1 {
+		unix, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return time.Unix(unix, 0), nil
+	}
+
+	unix, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	//The fraction is nanoseconds without trailing zeros.
+	frac := s[i+1:]
+	if frac == "" || len(frac) > 9 || strings.Trim(frac, "0123456789") != "" {
+		return time.Time{}, &strconv.NumError{Func: "parseUnix", Num: s, Err: strconv.ErrSyntax}
+	}
+
+	nsec, err := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(unix, nsec), nil
+}
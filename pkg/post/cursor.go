@@ -0,0 +1,189 @@
+package post
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//cursor is a position in a search result. Posts are ordered by creation time and then by ID to break ties.
+//IDs are compared as decimal strings, just like Redis orders sorted set members with the same score.
+//
+//Relevance ranking depends on the whole collection, so relevance cursors are offsets in the ranked result instead.
+type cursor struct {
+	order Order
+	//createdAt is a stored time, so it's compared exactly.
+	createdAt time.Time
+	id        int64
+	offset    int64
+}
+
+//before reports whether c goes before other in c's order.
+func (c cursor) before(other cursor) bool {
+	if !c.createdAt.Equal(other.createdAt) {
+		if c.order == Ascending {
+			return c.createdAt.Before(other.createdAt)
+		}
+
+		return c.createdAt.After(other.createdAt)
+	}
+
+	id, otherID := strconv.FormatInt(c.id, 10), strconv.FormatInt(other.id, 10)
+	if c.order == Ascending {
+		return id < otherID
+	}
+
+	return id > otherID
+}
+
+//encode returns an opaque string representation of c.
+func (c cursor) encode() string {
+	raw := fmt.Sprintf("%d:%v:%d", c.order, formatUnix(c.createdAt), c.id)
+	if c.order == Relevance {
+		raw = fmt.Sprintf("%d:%d", c.order, c.offset)
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+//decodeCursor parses a cursor created by cursor.encode.
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	var c cursor
+	if _, err := fmt.Sscanf(string(raw), "%d:", &c.order); err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	switch c.order {
+	case Ascending, Descending:
+		//Cursors of older versions have creation time in whole seconds, parseUnix accepts them.
+		parts := strings.Split(string(raw), ":")
+		if len(parts) != 3 {
+			return cursor{}, ErrInvalidCursor
+		}
+
+		c.createdAt, err = parseUnix(parts[1])
+		if err == nil {
+			c.id, err = strconv.ParseInt(parts[2], 10, 64)
+		}
+	case Relevance:
+		_, err = fmt.Sscanf(string(raw), "%d:%d", &c.order, &c.offset)
+		if err == nil && c.offset < 0 {
+			err = ErrInvalidCursor
+		}
+	default:
+		err = ErrInvalidCursor
+	}
+
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+//pageStart checks that filter can be paginated and returns the position the page starts after, nil for the first page.
+func pageStart(filter *SearchFilter) (*cursor, error) {
+	if filter.Order == Relevance && filter.Query == "" {
+		return nil, Errorf(ErrInvalid, "relevance order requires a full-text query")
+	}
+
+	if filter.Cursor == "" {
+		return nil, nil
+	}
+
+	c, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.order != filter.Order {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+//trashStart returns the position a trash page starts after, nil for the first page.
+//Trash cursors keep deletion time in place of creation time.
+func trashStart(filter *TrashFilter) (*cursor, error) {
+	if filter.Cursor == "" {
+		return nil, nil
+	}
+
+	c, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.order != Descending {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+//orderEntries sorts entries in their order and drops the ones that don't go after a cursor.
+//Relevance order ranks entries by their relevance, newer posts go first on a tie.
+func orderEntries(entries []cursor, relevance map[int64]float64, after *cursor) []cursor {
+	if len(entries) == 0 {
+		return entries
+	}
+
+	if entries[0].order == Relevance {
+		sort.Slice(entries, func(i, j int) bool {
+			ri, rj := relevance[entries[i].id], relevance[entries[j].id]
+			if ri != rj {
+				return ri > rj
+			}
+
+			newer := entries[i]
+			newer.order = Descending
+			return newer.before(entries[j])
+		})
+
+		for i := range entries {
+			entries[i].offset = int64(i)
+		}
+
+		if after != nil {
+			start := after.offset + 1
+			if start > int64(len(entries)) {
+				start = int64(len(entries))
+			}
+			entries = entries[start:]
+		}
+
+		return entries
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].before(entries[j])
+	})
+
+	if after != nil {
+		i := sort.Search(len(entries), func(i int) bool {
+			return after.before(entries[i])
+		})
+		entries = entries[i:]
+	}
+
+	return entries
+}
+
+//page trims entries to limit and returns a cursor of the next page if there are more entries. Zero limit means no limit.
+func page(entries []cursor, limit int64) ([]cursor, string) {
+	if limit <= 0 || int64(len(entries)) <= limit {
+		return entries, ""
+	}
+
+	entries = entries[:limit]
+	return entries, entries[len(entries)-1].encode()
+}
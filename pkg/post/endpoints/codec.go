@@ -0,0 +1,256 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+//codec encodes response bodies and decodes request bodies of a media type.
+type codec struct {
+	mediaType string
+	marshal   func(src interface{}) ([]byte, error)
+	//decode decodes a request body to dst. Errors caused by the body are *requestError.
+	decode func(body io.Reader, dst interface{}) error
+}
+
+//Body codecs
+var (
+	jsonCodec     = &codec{"application/json", json.Marshal, decodeJSON}
+	msgpackCodec  = &codec{"application/msgpack", marshalMsgpack, decodeMsgpack}
+	protobufCodec = &codec{"application/x-protobuf", marshalProtobuf, decodeProtobuf}
+	//problemCodec writes RFC 7807 documents, it's never negotiated.
+	problemCodec = &codec{"application/problem+json", json.Marshal, decodeJSON}
+)
+
+//codecs is the registry of negotiable codecs in order of preference. JSON is the default.
+var codecs = []*codec{jsonCodec, msgpackCodec, protobufCodec}
+
+//requestCodec chooses a codec by the Content-Type header of r.
+func requestCodec(r *http.Request) (*codec, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for _, c := range codecs {
+		if c.mediaType == mediaType {
+			return c, nil
+		}
+	}
+
+	return nil, newRequestError(http.StatusUnsupportedMediaType, "Content-Type header is not one of "+strings.Join(codecMediaTypes(), ", "))
+}
+
+//responseCodecs lists codecs the Accept header of r allows, most preferred first. The list is empty if none is acceptable.
+func responseCodecs(r *http.Request) []*codec {
+	accepted := negotiate(r, codecMediaTypes())
+	res := make([]*codec, 0, len(accepted))
+	for _, mediaType := range accepted {
+		for _, c := range codecs {
+			if c.mediaType == mediaType {
+				res = append(res, c)
+			}
+		}
+	}
+
+	return res
+}
+
+//codecMediaTypes lists media types of negotiable codecs in order of preference.
+func codecMediaTypes() []string {
+	mediaTypes := make([]string, len(codecs))
+	for i, c := range codecs {
+		mediaTypes[i] = c.mediaType
+	}
+
+	return mediaTypes
+}
+
+//mediaRange is a media range of an Accept header, type and subtype may be *.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+//parseAccept parses media ranges of the Accept header of r. Malformed ranges are skipped and quality is 1 by default.
+func parseAccept(r *http.Request) []mediaRange {
+	var ranges []mediaRange
+	for _, accept := range r.Header.Values("Accept") {
+		for _, field := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(field)
+			if err != nil {
+				continue
+			}
+
+			slash := strings.Index(mediaType, "/")
+			if slash == -1 {
+				continue
+			}
+
+			q := 1.0
+			if query, ok := params["q"]; ok {
+				q, err = strconv.ParseFloat(query, 64)
+				if err != nil || q < 0 || q > 1 {
+					continue
+				}
+			}
+
+			ranges = append(ranges, mediaRange{mediaType[:slash], mediaType[slash+1:], q})
+		}
+	}
+
+	return ranges
+}
+
+//quality returns the quality of a media type given by the most specific range that matches it.
+//Specificity is 2 for the media type itself, 1 for type/* and 0 for */*, it's -1 if no range matches.
+func quality(ranges []mediaRange, mediaType string) (q float64, specificity int) {
+	specificity = -1
+	typ, subtype := mediaType, ""
+	if slash := strings.Index(mediaType, "/"); slash != -1 {
+		typ, subtype = mediaType[:slash], mediaType[slash+1:]
+	}
+
+	for _, rng := range ranges {
+		s := -1
+		switch {
+		case rng.typ == typ && rng.subtype == subtype:
+			s = 2
+		case rng.typ == typ && rng.subtype == "*":
+			s = 1
+		case rng.typ == "*" && rng.subtype == "*":
+			s = 0
+		}
+
+		if s > specificity {
+			q, specificity = rng.q, s
+		}
+	}
+
+	return q, specificity
+}
+
+//negotiate returns offered media types the Accept header of r allows, most preferred first. Offers are ordered by quality,
+//then by how specific the matching range is, then by their order. Ranges with quality 0 rule offers out.
+//Every offer is allowed if there is no Accept header.
+func negotiate(r *http.Request, offers []string) []string {
+	ranges := parseAccept(r)
+	if len(ranges) == 0 {
+		return offers
+	}
+
+	type match struct {
+		mediaType   string
+		q           float64
+		specificity int
+	}
+
+	matches := make([]match, 0, len(offers))
+	for _, offer := range offers {
+		q, specificity := quality(ranges, offer)
+		if specificity != -1 && q > 0 {
+			matches = append(matches, match{offer, q, specificity})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].q != matches[j].q {
+			return matches[i].q > matches[j].q
+		}
+		return matches[i].specificity > matches[j].specificity
+	})
+
+	res := make([]string, len(matches))
+	for i, m := range matches {
+		res[i] = m.mediaType
+	}
+
+	return res
+}
+
+//accepts reports whether the Accept header of r lists a media type itself, not by a wildcard, with a non-zero quality.
+func accepts(r *http.Request, mediaType string) bool {
+	q, specificity := quality(parseAccept(r), mediaType)
+	return specificity == 2 && q > 0
+}
+
+//decodeBody decodes http.Request.Body to dst with a codec chosen by the Content-Type header. This function errors if any of the following is true:
+//
+//- Content-Type is not application/json, application/msgpack or application/x-protobuf,
+//
+//- Body size is larger than 1MB,
+//
+//- Body is badly formatted,
+//
+//- Body contains unknown fields
+//
+//- Body is empty
+func decodeBody(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	c, err := requestCodec(r)
+	if err != nil {
+		return err
+	}
+
+	//Limit body size to 1MB.
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+
+	return c.decode(r.Body, dst)
+}
+
+//decodeJSON decodes a JSON document to dst, unknown fields aren't allowed.
+func decodeJSON(body io.Reader, dst interface{}) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+
+	return nil
+}
+
+//marshalMsgpack encodes src to MessagePack. Fields are named after their JSON tags and times are MessagePack timestamps.
+func marshalMsgpack(src interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+
+	if err := enc.Encode(src); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+//decodeMsgpack decodes MessagePack to dst following the rules of marshalMsgpack, unknown fields aren't allowed.
+func decodeMsgpack(body io.Reader, dst interface{}) error {
+	dec := msgpack.NewDecoder(body)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+
+	err := dec.Decode(dst)
+	switch {
+	case err == nil:
+		return nil
+
+	case errors.Is(err, io.EOF):
+		return newRequestError(http.StatusBadRequest, "Request body is empty")
+
+	case strings.HasPrefix(err.Error(), "msgpack: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "msgpack: unknown field ")
+		msg := "Request body contains unknown field " + fieldName
+
+		return newRequestError(http.StatusBadRequest, msg, invalidParam{strings.Trim(fieldName, `"`), "unknown field"})
+
+	case strings.HasPrefix(err.Error(), "msgpack: ") || errors.Is(err, io.ErrUnexpectedEOF):
+		return newRequestError(http.StatusBadRequest, "Request body contains badly-formatted MessagePack.")
+
+	default:
+		return decodeError(err)
+	}
+}
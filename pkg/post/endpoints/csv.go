@@ -0,0 +1,88 @@
+package endpoints
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VTGare/softserve-homework/pkg/post"
+)
+
+//responseFormat is a format of listings negotiated with clients.
+type responseFormat int
+
+//Response formats
+const (
+	formatJSON responseFormat = iota
+	formatCSV
+)
+
+//postHeader is the header row of posts written as CSV.
+var postHeader = []string{"id", "name", "author", "created_at", "updated_at", "format", "tags", "body"}
+
+//format negotiates a response format: the format parameter takes precedence over the Accept header and JSON is the default.
+//CSV is chosen if the Accept header ranks text/csv above the codecs.
+func (w *responseWriter) format() (responseFormat, error) {
+	switch query := w.req.URL.Query().Get("format"); query {
+	case "":
+	case "json":
+		return formatJSON, nil
+	case "csv":
+		return formatCSV, nil
+	default:
+		return formatJSON, newRequestError(http.StatusBadRequest, fmt.Sprintf("Unknown format: %v.", query))
+	}
+
+	accepted := negotiate(w.req, append(codecMediaTypes(), "text/csv"))
+	if len(accepted) != 0 && accepted[0] == "text/csv" {
+		return formatCSV, nil
+	}
+
+	return formatJSON, nil
+}
+
+//CSV writes a header row and n records as RFC 4180 CSV with a text/csv Content-Type header and status 200.
+//Records are encoded straight to the response as record returns them.
+func (w *responseWriter) CSV(header []string, n int, record func(i int) []string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	//Errors can't be reported once the status is written, the response is cut short.
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for i := 0; i < n; i++ {
+		fields := record(i)
+		for j, field := range fields {
+			fields[j] = csvField(field)
+		}
+		cw.Write(fields)
+	}
+	cw.Flush()
+}
+
+//csvField guards a field against formula injection. Spreadsheets run fields starting with =, +, -, @, a tab or a carriage return as formulas,
+//a leading single quote makes them text.
+func csvField(field string) string {
+	if field != "" && strings.ContainsRune("=+-@\t\r", rune(field[0])) {
+		return "'" + field
+	}
+
+	return field
+}
+
+//postRecord returns CSV fields of a post in the order of postHeader. Tags are separated with commas.
+func postRecord(p *post.Post) []string {
+	return []string{
+		strconv.FormatInt(p.ID, 10),
+		p.Name,
+		p.Author,
+		p.CreatedAt.Format(time.RFC3339Nano),
+		p.UpdatedAt.Format(time.RFC3339Nano),
+		p.Format,
+		strings.Join(p.Tags, ","),
+		p.Body,
+	}
+}
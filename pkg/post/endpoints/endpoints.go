@@ -1,187 +1,904 @@
 package endpoints
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/VTGare/softserve-homework/pkg/post"
 	"github.com/gorilla/mux"
 )
 
+//Search endpoint page size limits.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+//maxImportSize limits the body of an import request in bytes, larger imports are run with postadmin.
+const maxImportSize = 64 << 20
+
 //Set is a set of Post service endpoints.
 type Set struct {
-	GetEndpoint    func(http.ResponseWriter, *http.Request)
-	AddEndpoint    func(http.ResponseWriter, *http.Request)
-	DeleteEndpoint func(http.ResponseWriter, *http.Request)
-	SearchEndpoint func(http.ResponseWriter, *http.Request)
-	CountEndpoint  func(http.ResponseWriter, *http.Request)
+	GetEndpoint         func(http.ResponseWriter, *http.Request)
+	HTMLEndpoint        func(http.ResponseWriter, *http.Request)
+	AddEndpoint         func(http.ResponseWriter, *http.Request)
+	UpdateEndpoint      func(http.ResponseWriter, *http.Request)
+	PatchEndpoint       func(http.ResponseWriter, *http.Request)
+	DeleteEndpoint      func(http.ResponseWriter, *http.Request)
+	SearchEndpoint      func(http.ResponseWriter, *http.Request)
+	CountEndpoint       func(http.ResponseWriter, *http.Request)
+	TagsEndpoint        func(http.ResponseWriter, *http.Request)
+	RestoreEndpoint     func(http.ResponseWriter, *http.Request)
+	TrashEndpoint       func(http.ResponseWriter, *http.Request)
+	RevisionsEndpoint   func(http.ResponseWriter, *http.Request)
+	RevisionEndpoint    func(http.ResponseWriter, *http.Request)
+	RevertEndpoint      func(http.ResponseWriter, *http.Request)
+	BatchAddEndpoint    func(http.ResponseWriter, *http.Request)
+	BatchDeleteEndpoint func(http.ResponseWriter, *http.Request)
+	LookupEndpoint      func(http.ResponseWriter, *http.Request)
+	ExportEndpoint      func(http.ResponseWriter, *http.Request)
+	ImportEndpoint      func(http.ResponseWriter, *http.Request)
 }
 
 //NewEndpointSet creates a set of endpoints aware of our service.
 func NewEndpointSet(svc post.Service) *Set {
 	return &Set{
-		GetEndpoint:    makeGetEndpoint(svc),
-		AddEndpoint:    makeAddEndpoint(svc),
-		DeleteEndpoint: makeDeleteEndpoint(svc),
-		SearchEndpoint: makeSearchEndpoint(svc),
-		CountEndpoint:  makeCountEndpoint(svc),
+		GetEndpoint:         makeGetEndpoint(svc),
+		HTMLEndpoint:        makeHTMLEndpoint(svc),
+		AddEndpoint:         makeAddEndpoint(svc),
+		UpdateEndpoint:      makeUpdateEndpoint(svc),
+		PatchEndpoint:       makePatchEndpoint(svc),
+		DeleteEndpoint:      makeDeleteEndpoint(svc),
+		SearchEndpoint:      makeSearchEndpoint(svc),
+		CountEndpoint:       makeCountEndpoint(svc),
+		TagsEndpoint:        makeTagsEndpoint(svc),
+		RestoreEndpoint:     makeRestoreEndpoint(svc),
+		TrashEndpoint:       makeTrashEndpoint(svc),
+		RevisionsEndpoint:   makeRevisionsEndpoint(svc),
+		RevisionEndpoint:    makeRevisionEndpoint(svc),
+		RevertEndpoint:      makeRevertEndpoint(svc),
+		BatchAddEndpoint:    makeBatchAddEndpoint(svc),
+		BatchDeleteEndpoint: makeBatchDeleteEndpoint(svc),
+		LookupEndpoint:      makeLookupEndpoint(svc),
+		ExportEndpoint:      makeExportEndpoint(svc),
+		ImportEndpoint:      makeImportEndpoint(svc),
 	}
 }
 
 func makeGetEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rw := &responseWriter{w}
+		rw := &responseWriter{w, r}
 		vars := mux.Vars(r)
 
 		id, err := strconv.ParseInt(vars["id"], 10, 64)
 		if err != nil {
-			rw.JSON(jsonResp{http.StatusBadRequest, "unable to parse an ID."}, http.StatusBadRequest)
+			rw.Error(newRequestError(http.StatusBadRequest, "unable to parse an ID."))
 			return
 		}
 
 		post, err := svc.FindOne(r.Context(), id)
 		if err != nil {
-			switch {
-			case strings.Contains(err.Error(), "not found"):
-				rw.JSON(jsonResp{http.StatusNotFound, fmt.Sprintf("post %v was not found.", id)}, http.StatusNotFound)
-				return
-			default:
-				rw.JSON(jsonResp{http.StatusInternalServerError, err.Error()}, http.StatusInternalServerError)
-				return
-			}
+			rw.Error(err)
+			return
+		}
+
+		etag := formatETag(post.Version)
+		w.Header().Set("ETag", etag)
+		if header := r.Header.Get("If-None-Match"); header != "" && noneMatch(header, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
 		}
 
-		rw.JSON(post)
+		rw.Encode(post)
 	}
 }
 
-func makeAddEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+func makeHTMLEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rw := &responseWriter{w}
+		rw := &responseWriter{w, r}
+		vars := mux.Vars(r)
 
-		var post post.Post
-		err := decodeJSONBody(w, r, &post)
+		id, err := strconv.ParseInt(vars["id"], 10, 64)
 		if err != nil {
-			var jr *jsonResp
-			if errors.As(err, &jr) {
-				rw.JSON(jr, jr.Status)
-			} else {
-				rw.JSON(&jsonResp{http.StatusInternalServerError, err.Error()}, http.StatusInternalServerError)
-			}
+			rw.Error(newRequestError(http.StatusBadRequest, "unable to parse an ID."))
 			return
 		}
 
-		switch {
-		case post.Author == "":
-			rw.JSON(&jsonResp{http.StatusBadRequest, "author field cannot be empty."}, http.StatusBadRequest)
+		p, err := svc.FindOne(r.Context(), id)
+		if err != nil {
+			rw.Error(err)
 			return
-		case post.Name == "":
-			rw.JSON(&jsonResp{http.StatusBadRequest, "name field cannot be empty."}, http.StatusBadRequest)
+		}
+
+		etag := formatETag(p.Version)
+		w.Header().Set("ETag", etag)
+		if header := r.Header.Get("If-None-Match"); header != "" && noneMatch(header, etag) {
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 
-		id, err := svc.Create(r.Context(), &post)
+		body, err := post.RenderHTML(p)
 		if err != nil {
-			rw.JSON(&jsonResp{http.StatusInternalServerError, ""}, http.StatusInternalServerError)
+			rw.Error(err)
 			return
 		}
 
-		rw.JSON(newPostResp{
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}
+}
+
+func makeAddEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		var post post.Post
+		err := decodeBody(w, r, &post)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		id, err := svc.Create(editorContext(r), &post)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		rw.Encode(newPostResp{
 			jsonResp: jsonResp{200, "Successfully created a new post."},
 			ID:       id,
 		})
 	}
 }
 
+func makeUpdateEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+		vars := mux.Vars(r)
+
+		id, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			rw.Error(newRequestError(http.StatusBadRequest, "unable to parse an ID."))
+			return
+		}
+
+		version, ok := parseIfMatch(r.Header.Get("If-Match"))
+		if !ok {
+			rw.Error(newRequestError(http.StatusPreconditionFailed, "If-Match header doesn't match the current post version."))
+			return
+		}
+
+		var replacement post.Post
+		err = decodeBody(w, r, &replacement)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		updated, err := svc.Update(editorContext(r), id, &replacement, version)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		w.Header().Set("ETag", formatETag(updated.Version))
+		rw.Encode(updated)
+	}
+}
+
+func makePatchEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+		vars := mux.Vars(r)
+
+		id, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			rw.Error(newRequestError(http.StatusBadRequest, "unable to parse an ID."))
+			return
+		}
+
+		version, ok := parseIfMatch(r.Header.Get("If-Match"))
+		if !ok {
+			rw.Error(newRequestError(http.StatusPreconditionFailed, "If-Match header doesn't match the current post version."))
+			return
+		}
+
+		original, err := svc.FindOne(r.Context(), id)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		//The patch is applied to the version we've just read, it must not overwrite a concurrent change.
+		if version == post.AnyVersion {
+			version = original.Version
+		}
+
+		var patched post.Post
+		err = decodeMergePatch(w, r, original, &patched)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		updated, err := svc.Update(editorContext(r), id, &patched, version)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		w.Header().Set("ETag", formatETag(updated.Version))
+		rw.Encode(updated)
+	}
+}
+
 func makeSearchEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rw := &responseWriter{w}
+		rw := &responseWriter{w, r}
+
+		//Posts listed by ID aren't filtered, sorted or paged.
+		if query := r.URL.Query().Get("ids"); query != "" {
+			ids, err := parseIDs(query)
+			if err != nil {
+				rw.Error(err)
+				return
+			}
+
+			lookup(rw, svc, ids)
+			return
+		}
+
+		format, err := rw.format()
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		//Descending sort by default, full-text search results are sorted by relevance.
+		q := r.URL.Query().Get("q")
+		order := post.Descending
+		if q != "" {
+			order = post.Relevance
+		}
 
-		//Descending sort by default
-		var order post.Order
 		if query := r.URL.Query().Get("order"); query != "" {
 			switch query {
 			case "asc":
 				order = post.Ascending
 			case "desc":
 				order = post.Descending
+			case "relevance":
+				if q == "" {
+					rw.Error(newRequestError(http.StatusBadRequest, "relevance sort option requires a q parameter."))
+					return
+				}
+				order = post.Relevance
 			default:
-				rw.JSON(jsonResp{http.StatusBadRequest, fmt.Sprintf("Unknown sort option: %v.", query)}, http.StatusBadRequest)
+				rw.Error(newRequestError(http.StatusBadRequest, fmt.Sprintf("Unknown sort option: %v.", query)))
 				return
 			}
 		}
 
-		posts, err := svc.FindMany(r.Context(), &post.SearchFilter{
-			Name:   r.URL.Query().Get("name"),
-			Author: r.URL.Query().Get("author"),
-			Order:  order,
-		})
+		limit, err := parseLimit(r, defaultPageSize, maxPageSize)
 		if err != nil {
-			switch {
-			case strings.Contains(err.Error(), "no results"):
-				rw.JSON(jsonResp{http.StatusNotFound, "No results found with applied filters."}, http.StatusNotFound)
+			rw.Error(err)
+			return
+		}
+
+		var createdAfter, createdBefore time.Time
+		timeParams := []struct {
+			name string
+			dst  *time.Time
+		}{
+			{"created_after", &createdAfter},
+			{"created_before", &createdBefore},
+		}
+
+		for _, param := range timeParams {
+			query := r.URL.Query().Get(param.name)
+			if query == "" {
+				continue
+			}
+
+			parsed, err := time.Parse(time.RFC3339, query)
+			if err != nil {
+				rw.Error(newRequestError(http.StatusBadRequest, fmt.Sprintf("%v must be an RFC 3339 timestamp.", param.name)))
 				return
-			default:
-				rw.JSON(jsonResp{http.StatusInternalServerError, err.Error()}, http.StatusInternalServerError)
+			}
+			*param.dst = parsed
+		}
+
+		if !createdAfter.IsZero() && !createdBefore.IsZero() && !createdAfter.Before(createdBefore) {
+			rw.Error(newRequestError(http.StatusBadRequest, "created_after must be earlier than created_before."))
+			return
+		}
+
+		var omitBody bool
+		if query := r.URL.Query().Get("omit_body"); query != "" {
+			parsed, err := strconv.ParseBool(query)
+			if err != nil {
+				rw.Error(newRequestError(http.StatusBadRequest, "omit_body must be a boolean."))
 				return
 			}
+			omitBody = parsed
+		}
+
+		//Posts must have all of the tags by default.
+		var anyTag bool
+		switch query := r.URL.Query().Get("tag_match"); query {
+		case "", "all":
+		case "any":
+			anyTag = true
+		default:
+			rw.Error(newRequestError(http.StatusBadRequest, fmt.Sprintf("Unknown tag_match option: %v.", query)))
+			return
+		}
+
+		match, err := parseMatch(r)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		posts, next, err := svc.FindMany(r.Context(), &post.SearchFilter{
+			Name:          r.URL.Query().Get("name"),
+			Author:        r.URL.Query().Get("author"),
+			Order:         order,
+			Limit:         limit,
+			Cursor:        r.URL.Query().Get("cursor"),
+			CreatedAfter:  createdAfter,
+			CreatedBefore: createdBefore,
+			Query:         q,
+			OmitBody:      omitBody,
+			Tags:          r.URL.Query()["tag"],
+			AnyTag:        anyTag,
+			Match:         match,
+		})
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		if format == formatCSV {
+			//CSV has no place for the cursor of the next page, it's sent in a header.
+			if next != "" {
+				w.Header().Set("X-Next-Cursor", next)
+			}
+
+			rw.CSV(postHeader, len(posts), func(i int) []string { return postRecord(posts[i]) })
+			return
 		}
 
-		rw.JSON(posts)
+		rw.Encode(searchResp{
+			Posts:      posts,
+			NextCursor: next,
+		})
 	}
 }
 
 func makeDeleteEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rw := &responseWriter{w}
+		rw := &responseWriter{w, r}
 		vars := mux.Vars(r)
 
 		id, err := strconv.ParseInt(vars["id"], 10, 64)
 		if err != nil {
-			rw.JSON(jsonResp{http.StatusBadRequest, "Error parsing an ID. Provide an integer."}, http.StatusBadRequest)
+			rw.Error(newRequestError(http.StatusBadRequest, "Error parsing an ID. Provide an integer."))
+			return
+		}
+
+		version, ok := parseIfMatch(r.Header.Get("If-Match"))
+		if !ok {
+			rw.Error(newRequestError(http.StatusPreconditionFailed, "If-Match header doesn't match the current post version."))
+			return
+		}
+
+		_, err = svc.Remove(editorContext(r), id, version)
+		if err != nil {
+			rw.Error(err)
 			return
 		}
 
-		_, err = svc.Remove(r.Context(), id)
+		rw.Encode(jsonResp{http.StatusOK, "Successfully removed a post with ID: " + vars["id"]})
+	}
+}
+
+func makeBatchAddEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		atomic, err := parseAtomic(r)
 		if err != nil {
-			switch {
-			case strings.Contains(err.Error(), "not found"):
-				rw.JSON(jsonResp{http.StatusNotFound, fmt.Sprintf("Post with ID %v is not found", id)}, http.StatusNotFound)
+			rw.Error(err)
+			return
+		}
+
+		var posts []*post.Post
+		if err := decodeBody(w, r, &posts); err != nil {
+			rw.Error(err)
+			return
+		}
+
+		for i, p := range posts {
+			if p == nil {
+				rw.Error(newRequestError(http.StatusBadRequest, fmt.Sprintf("Item %v of the batch is null.", i)))
 				return
-			default:
-				rw.JSON(jsonResp{http.StatusInternalServerError, err.Error()}, http.StatusInternalServerError)
+			}
+		}
+
+		results, err := svc.CreateMany(editorContext(r), posts, atomic)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		rw.batch(results)
+	}
+}
+
+func makeBatchDeleteEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		atomic, err := parseAtomic(r)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		var ids []int64
+		if err := decodeBody(w, r, &ids); err != nil {
+			rw.Error(err)
+			return
+		}
+
+		results, err := svc.RemoveMany(editorContext(r), ids, atomic)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		rw.batch(results)
+	}
+}
+
+func makeLookupEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		var ids []int64
+		if err := decodeBody(w, r, &ids); err != nil {
+			rw.Error(err)
+			return
+		}
+
+		lookup(rw, svc, ids)
+	}
+}
+
+func makeExportEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		var full bool
+		if query := r.URL.Query().Get("full"); query != "" {
+			parsed, err := strconv.ParseBool(query)
+			if err != nil {
+				rw.Error(newRequestError(http.StatusBadRequest, "full must be a boolean."))
 				return
 			}
+			full = parsed
+		}
+
+		//Exports take longer than the server write timeout. Writers that can't clear it, like test recorders, have none.
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		exported, err := post.ExportNDJSON(r.Context(), svc, w, full)
+		if err == nil {
+			return
+		}
+
+		//The status is sent with the first post, a failed export is cut short after that.
+		if exported == 0 {
+			rw.Error(err)
+			return
+		}
+		svc.Logger().Errorf("Export failed after %v posts: %v", exported, err)
+	}
+}
+
+func makeImportEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			rw.Error(newRequestError(http.StatusUnsupportedMediaType, "Content-Type header is not application/x-ndjson"))
+			return
+		}
+
+		//Imports take longer than the server read and write timeouts, the body size is limited instead.
+		//Writers that can't clear them, like test recorders, have none. Lines are limited by post.MaxLineSize.
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+		report, err := post.ImportNDJSON(editorContext(r), svc, r.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = newRequestError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body shouldn't be larger than %vMB, posts before the limit were imported.", maxImportSize>>20))
+		}
+
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		resp := importResp{Imported: report.Imported, Failed: make([]*importFailure, len(report.Failed))}
+		for i, failed := range report.Failed {
+			resp.Failed[i] = &importFailure{
+				Line:          failed.Line,
+				ID:            failed.ID,
+				Status:        errorStatus(failed.Err),
+				Message:       failed.Err.Error(),
+				InvalidParams: invalidParams(failed.Err),
+			}
+		}
+
+		status := http.StatusOK
+		if len(resp.Failed) != 0 {
+			status = http.StatusMultiStatus
 		}
 
-		rw.JSON(jsonResp{http.StatusOK, "Successfully removed a post with ID: " + vars["id"]})
+		rw.Encode(resp, status)
 	}
 }
 
+//lookup finds posts by IDs and writes them with the IDs of missing posts in a negotiated format.
+func lookup(rw *responseWriter, svc post.Service, ids []int64) {
+	format, err := rw.format()
+	if err != nil {
+		rw.Error(err)
+		return
+	}
+
+	posts, missing, err := svc.FindByIDs(rw.req.Context(), ids)
+	if err != nil {
+		rw.Error(err)
+		return
+	}
+
+	if format == formatCSV {
+		//CSV has no place for missing IDs, they're sent in a header.
+		if len(missing) != 0 {
+			fields := make([]string, len(missing))
+			for i, id := range missing {
+				fields[i] = strconv.FormatInt(id, 10)
+			}
+			rw.Header().Set("X-Missing-IDs", strings.Join(fields, ","))
+		}
+
+		rw.CSV(postHeader, len(posts), func(i int) []string { return postRecord(posts[i]) })
+		return
+	}
+
+	rw.Encode(lookupResp{posts, missing})
+}
+
+//parseIDs parses a comma-separated list of post IDs.
+func parseIDs(query string) ([]int64, error) {
+	fields := strings.Split(query, ",")
+	ids := make([]int64, len(fields))
+	for i, field := range fields {
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, newRequestError(http.StatusBadRequest, "ids must be a comma-separated list of integers.")
+		}
+		ids[i] = id
+	}
+
+	return ids, nil
+}
+
+//parseLimit parses the limit parameter of listings, it must be between 1 and max. def is used if there is none.
+func parseLimit(r *http.Request, def, max int64) (int64, error) {
+	query := r.URL.Query().Get("limit")
+	if query == "" {
+		return def, nil
+	}
+
+	limit, err := strconv.ParseInt(query, 10, 64)
+	if err != nil || limit < 1 || limit > max {
+		return 0, newRequestError(http.StatusBadRequest, fmt.Sprintf("limit must be an integer between 1 and %v.", max))
+	}
+
+	return limit, nil
+}
+
+//parseMatch parses the match parameter of name and author filters, they must be equal by default.
+func parseMatch(r *http.Request) (post.Match, error) {
+	switch query := r.URL.Query().Get("match"); query {
+	case "", "exact":
+		return post.MatchExact, nil
+	case "ci":
+		return post.MatchCaseInsensitive, nil
+	case "prefix":
+		return post.MatchPrefix, nil
+	default:
+		return post.MatchExact, newRequestError(http.StatusBadRequest, fmt.Sprintf("Unknown match option: %v.", query))
+	}
+}
+
+//parseAtomic parses the atomic parameter of batch endpoints, batches are written partially by default.
+func parseAtomic(r *http.Request) (bool, error) {
+	query := r.URL.Query().Get("atomic")
+	if query == "" {
+		return false, nil
+	}
+
+	atomic, err := strconv.ParseBool(query)
+	if err != nil {
+		return false, newRequestError(http.StatusBadRequest, "atomic must be a boolean.")
+	}
+
+	return atomic, nil
+}
+
 func makeCountEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rw := &responseWriter{w}
+		rw := &responseWriter{w, r}
 
-		res, err := svc.Count(r.Context())
+		format, err := rw.format()
 		if err != nil {
-			rw.JSON(jsonResp{http.StatusInternalServerError, err.Error()}, http.StatusInternalServerError)
+			rw.Error(err)
+			return
 		}
 
-		//Turn the database result into a pretty struct. I'd normally do that in a separate views package, but it's not a common practice with microservices
-		authors := make([]*authorCount, 0, len(res))
-		count := 0
-		for author, num := range res {
-			authors = append(authors, &authorCount{author, num})
+		//Authors with the most posts go first by default.
+		order := post.Descending
+		if query := r.URL.Query().Get("order"); query != "" {
+			switch query {
+			case "asc":
+				order = post.Ascending
+			case "desc":
+				order = post.Descending
+			default:
+				rw.Error(newRequestError(http.StatusBadRequest, fmt.Sprintf("Unknown sort option: %v.", query)))
+				return
+			}
+		}
+
+		limit, err := parseLimit(r, 0, maxPageSize)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		match, err := parseMatch(r)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		res, total, err := svc.Count(r.Context(), &post.CountFilter{
+			Author: r.URL.Query().Get("author"),
+			Order:  order,
+			Limit:  limit,
+			Match:  match,
+		})
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		if format == formatCSV {
+			//CSV has no place for the total count, it's sent in a header.
+			w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+			rw.CSV([]string{"author", "count"}, len(res), func(i int) []string {
+				return []string{res[i].Author, strconv.FormatInt(res[i].Count, 10)}
+			})
+			return
+		}
 
-			count += num
+		authors := make([]*authorCount, 0, len(res))
+		for _, count := range res {
+			authors = append(authors, &authorCount{count.Author, count.Count})
 		}
 
-		rw.JSON(&countResp{
-			Count:   count,
+		rw.Encode(&countResp{
+			Count:   total,
 			Authors: authors,
 		})
 	}
 }
+
+func makeTagsEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		//Most used tags go first by default.
+		order := post.Descending
+		if query := r.URL.Query().Get("order"); query != "" {
+			switch query {
+			case "asc":
+				order = post.Ascending
+			case "desc":
+				order = post.Descending
+			default:
+				rw.Error(newRequestError(http.StatusBadRequest, fmt.Sprintf("Unknown sort option: %v.", query)))
+				return
+			}
+		}
+
+		limit, err := parseLimit(r, 0, maxPageSize)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		res, err := svc.Tags(r.Context(), &post.TagFilter{
+			Order: order,
+			Limit: limit,
+		})
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		tags := make([]*tagCount, 0, len(res))
+		for _, count := range res {
+			tags = append(tags, &tagCount{count.Tag, count.Count})
+		}
+
+		rw.Encode(&tagsResp{Tags: tags})
+	}
+}
+
+func makeRestoreEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+		vars := mux.Vars(r)
+
+		id, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			rw.Error(newRequestError(http.StatusBadRequest, "unable to parse an ID."))
+			return
+		}
+
+		restored, err := svc.Restore(editorContext(r), id)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		w.Header().Set("ETag", formatETag(restored.Version))
+		rw.Encode(restored)
+	}
+}
+
+func makeTrashEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		limit, err := parseLimit(r, defaultPageSize, maxPageSize)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		//Recently removed posts go first.
+		posts, next, err := svc.FindTrashed(r.Context(), &post.TrashFilter{
+			Limit:  limit,
+			Cursor: r.URL.Query().Get("cursor"),
+		})
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		rw.Encode(searchResp{
+			Posts:      posts,
+			NextCursor: next,
+		})
+	}
+}
+
+func makeRevisionsEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+		vars := mux.Vars(r)
+
+		id, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			rw.Error(newRequestError(http.StatusBadRequest, "unable to parse an ID."))
+			return
+		}
+
+		revisions, err := svc.Revisions(r.Context(), id)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		rw.Encode(revisionsResp{Revisions: revisions})
+	}
+}
+
+func makeRevisionEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		id, n, err := parseRevision(mux.Vars(r))
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		rev, err := svc.Revision(r.Context(), id, n)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		rw.Encode(rev)
+	}
+}
+
+func makeRevertEndpoint(svc post.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{w, r}
+
+		id, n, err := parseRevision(mux.Vars(r))
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		version, ok := parseIfMatch(r.Header.Get("If-Match"))
+		if !ok {
+			rw.Error(newRequestError(http.StatusPreconditionFailed, "If-Match header doesn't match the current post version."))
+			return
+		}
+
+		reverted, err := svc.Revert(editorContext(r), id, n, version)
+		if err != nil {
+			rw.Error(err)
+			return
+		}
+
+		w.Header().Set("ETag", formatETag(reverted.Version))
+		rw.Encode(reverted)
+	}
+}
+
+//parseRevision parses post ID and revision number route variables.
+func parseRevision(vars map[string]string) (int64, int64, error) {
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		return 0, 0, newRequestError(http.StatusBadRequest, "unable to parse an ID.")
+	}
+
+	n, err := strconv.ParseInt(vars["n"], 10, 64)
+	if err != nil || n < 1 {
+		return 0, 0, newRequestError(http.StatusBadRequest, "revision number must be a positive integer.")
+	}
+
+	return id, n, nil
+}
+
+//editorContext attributes writes made with the request context to the editor named in X-Editor header, if any.
+func editorContext(r *http.Request) context.Context {
+	if editor := r.Header.Get("X-Editor"); editor != "" {
+		return post.WithEditor(r.Context(), editor)
+	}
+
+	return r.Context()
+}
@@ -1,7 +1,9 @@
 package endpoints
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -14,39 +16,105 @@ import (
 	"github.com/VTGare/softserve-homework/pkg/post"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 type serviceMock struct{}
 
-func (m serviceMock) Create(_ context.Context, _ *post.Post) (int64, error) {
+func (m serviceMock) Create(_ context.Context, p *post.Post) (int64, error) {
+	if _, err := post.ValidatePost(p, post.Options{}); err != nil {
+		return 0, err
+	}
+
 	return 1, nil
 }
 
 func (m serviceMock) FindOne(_ context.Context, id int64) (*post.Post, error) {
 	posts := map[int64]*post.Post{
-		1: {1, "test1", "vt", time.Unix(1, 0)},
-		2: {2, "test2", "vt", time.Unix(1, 0)},
-		3: {3, "test3", "vt", time.Unix(1, 0)},
+		1: {ID: 1, Name: "test1", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Version: 1},
+		2: {ID: 2, Name: "test2", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Version: 2},
+		3: {ID: 3, Name: "test3", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Version: 3},
+		7: {ID: 7, Name: "test7", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Body: "*hi* <b>", Format: post.FormatMarkdown, Version: 1},
+		8: {ID: 8, Name: "test8", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Body: "a & b\nc", Version: 1},
+	}
+
+	switch id {
+	case 5:
+		return nil, post.Errorf(post.ErrUnavailable, "database is unavailable")
+	case 6:
+		return nil, fmt.Errorf("ERR script not found")
 	}
 
-	post, ok := posts[id]
+	p, ok := posts[id]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, post.Errorf(post.ErrNotFound, "post %v was not found", id)
 	}
 
-	return post, nil
+	return p, nil
 }
 
-func (m serviceMock) FindMany(_ context.Context, filters *post.SearchFilter) ([]*post.Post, error) {
+func (m serviceMock) FindMany(_ context.Context, filters *post.SearchFilter) ([]*post.Post, string, error) {
+	if filters.Cursor != "" && filters.Cursor != "2" {
+		return nil, "", post.ErrInvalidCursor
+	}
+
 	postsMap := map[int64]*post.Post{
-		1: {1, "test", "vt", time.Unix(1, 0)},
-		2: {2, "test", "robot", time.Unix(1, 0)},
-		3: {3, "test2", "vt", time.Unix(1, 0)},
+		1: {ID: 1, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0)},
+		2: {ID: 2, Name: "test", Author: "robot", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0)},
+		3: {ID: 3, Name: "test2", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0)},
+	}
+
+	if !filters.OmitBody {
+		postsMap[3].Body = "body"
+	}
+
+	for id, p := range postsMap {
+		if !filters.CreatedAfter.IsZero() && p.CreatedAt.Before(filters.CreatedAfter) {
+			delete(postsMap, id)
+		}
+
+		if !filters.CreatedBefore.IsZero() && !p.CreatedAt.Before(filters.CreatedBefore) {
+			delete(postsMap, id)
+		}
+	}
+
+	//The mock only knows post 2 is tagged robots.
+	if len(filters.Tags) != 0 {
+		matched := 0
+		for _, tag := range filters.Tags {
+			if tag == "robots" {
+				matched++
+			}
+		}
+		tagged := matched != 0 && (filters.AnyTag || matched == len(filters.Tags))
+
+		for id := range postsMap {
+			if id != 2 || !tagged {
+				delete(postsMap, id)
+			}
+		}
+	}
+
+	//The mock only matches whole names.
+	for id, p := range postsMap {
+		if filters.Query != "" && !strings.EqualFold(p.Name, filters.Query) {
+			delete(postsMap, id)
+		}
 	}
 
 	posts := make([]*post.Post, 0)
 	switch {
+	case filters.Match != post.MatchExact:
+		//The mock only matches name prefixes ignoring case.
+		for _, p := range postsMap {
+			if strings.HasPrefix(strings.ToLower(p.Name), strings.ToLower(filters.Name)) {
+				posts = append(posts, p)
+			}
+		}
 	case filters.Author != "" && filters.Name != "":
 		for _, post := range postsMap {
 			if post.Author == filters.Author && post.Name == filters.Name {
@@ -71,29 +139,58 @@ func (m serviceMock) FindMany(_ context.Context, filters *post.SearchFilter) ([]
 		}
 	}
 
+	//All posts have the same creation time, so they're sorted by ID.
 	switch filters.Order {
 	case post.Ascending:
 		sort.Slice(posts, func(i, j int) bool {
-			return posts[i].CreatedAt.Before(posts[j].CreatedAt)
+			return posts[i].ID < posts[j].ID
 		})
-	case post.Descending:
+	case post.Descending, post.Relevance:
 		sort.Slice(posts, func(i, j int) bool {
-			return posts[i].CreatedAt.After(posts[j].CreatedAt)
+			return posts[i].ID > posts[j].ID
 		})
 	}
 
-	return posts, nil
+	//The mock only knows a cursor pointing after the second post.
+	if filters.Cursor == "2" {
+		posts = posts[2:]
+	}
+
+	var next string
+	if int64(len(posts)) > filters.Limit {
+		posts = posts[:filters.Limit]
+		next = "2"
+	}
+
+	return posts, next, nil
 }
 
-func (m serviceMock) Remove(_ context.Context, id int64) (bool, error) {
-	posts := map[int64]*post.Post{
-		1: {1, "test1", "vt", time.Unix(1, 0)},
-		2: {2, "test2", "vt", time.Unix(1, 0)},
-		3: {3, "test3", "vt", time.Unix(1, 0)},
+func (m serviceMock) Update(ctx context.Context, id int64, p *post.Post, version int64) (*post.Post, error) {
+	old, err := m.FindOne(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != post.AnyVersion && version != old.Version {
+		return nil, post.ErrVersionMismatch
 	}
 
-	if _, ok := posts[id]; !ok {
-		return false, errors.New("not found")
+	p, err = post.ValidatePost(p, post.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &post.Post{ID: id, Name: p.Name, Author: p.Author, CreatedAt: old.CreatedAt, UpdatedAt: time.Unix(2, 0), Version: old.Version + 1}, nil
+}
+
+func (m serviceMock) Remove(ctx context.Context, id int64, version int64) (bool, error) {
+	old, err := m.FindOne(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	if version != post.AnyVersion && version != old.Version {
+		return false, post.ErrVersionMismatch
 	}
 
 	return true, nil
@@ -103,11 +200,31 @@ func (m serviceMock) Logger() *zap.SugaredLogger {
 	return zap.NewExample().Sugar()
 }
 
-func (m serviceMock) Count(_ context.Context) (map[string]int, error) {
-	return map[string]int{
-		"vt":    2,
-		"robot": 1,
-	}, nil
+func (m serviceMock) Now() time.Time {
+	return time.Unix(100, 0)
+}
+
+func (m serviceMock) Count(_ context.Context, filter *post.CountFilter) ([]post.AuthorCount, int64, error) {
+	counts := []post.AuthorCount{{Author: "vt", Count: 2}, {Author: "robot", Count: 1}}
+	if filter.Author != "" {
+		for _, count := range counts {
+			if count.Author == filter.Author || (filter.Match == post.MatchCaseInsensitive && strings.EqualFold(count.Author, filter.Author)) {
+				return []post.AuthorCount{{Author: filter.Author, Count: count.Count}}, count.Count, nil
+			}
+		}
+
+		return []post.AuthorCount{}, 0, nil
+	}
+
+	if filter.Order == post.Ascending {
+		counts[0], counts[1] = counts[1], counts[0]
+	}
+
+	if filter.Limit > 0 && int64(len(counts)) > filter.Limit {
+		counts = counts[:filter.Limit]
+	}
+
+	return counts, 3, nil
 }
 
 func TestGetEndpoint(t *testing.T) {
@@ -119,15 +236,34 @@ func TestGetEndpoint(t *testing.T) {
 	tests := []struct {
 		name           string
 		id             string
+		ifNoneMatch    string
 		expectedBody   string
+		expectedETag   string
 		expectedStatus int
 	}{
 		{
 			name:           "Post 1. Success.",
 			id:             "1",
-			expectedBody:   fmt.Sprintf(`{"id":1,"name":"test1","author":"vt","created_at":"%v"}`, time.Unix(1, 0).Format(time.RFC3339)),
+			expectedBody:   fmt.Sprintf(`{"id":1,"name":"test1","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}`, time.Unix(1, 0).Format(time.RFC3339)),
+			expectedETag:   `"1"`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Post 1. Stale If-None-Match.",
+			id:             "1",
+			ifNoneMatch:    `"0"`,
+			expectedBody:   fmt.Sprintf(`{"id":1,"name":"test1","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}`, time.Unix(1, 0).Format(time.RFC3339)),
+			expectedETag:   `"1"`,
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name:           "Post 2. Not modified.",
+			id:             "2",
+			ifNoneMatch:    `"1", W/"2"`,
+			expectedBody:   "",
+			expectedETag:   `"2"`,
+			expectedStatus: http.StatusNotModified,
+		},
 		{
 			name:           "Non-integer ID. Fail.",
 			id:             "pog",
@@ -137,20 +273,106 @@ func TestGetEndpoint(t *testing.T) {
 		{
 			name:           "Post 4. Fail.",
 			id:             "4",
-			expectedBody:   `{"status":404,"message":"post 4 was not found."}`,
+			expectedBody:   `{"status":404,"message":"post 4 was not found"}`,
 			expectedStatus: http.StatusNotFound,
 		},
+		{
+			name:           "Database is unavailable.",
+			id:             "5",
+			expectedBody:   `{"status":503,"message":"database is unavailable"}`,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "Unknown error that mentions not found.",
+			id:             "6",
+			expectedBody:   `{"status":500,"message":"ERR script not found"}`,
+			expectedStatus: http.StatusInternalServerError,
+		},
 	}
 
 	for _, test := range tests {
 		rec := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/api/posts/"+test.id, nil)
+		if test.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
 
 		r.ServeHTTP(rec, req)
 		body := rec.Body.String()
 
 		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedETag, rec.Header().Get("ETag"), test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestHTMLEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeHTMLEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/{id}/html", ep)
+
+	tests := []struct {
+		name                string
+		id                  string
+		ifNoneMatch         string
+		expectedBody        string
+		expectedContentType string
+		expectedStatus      int
+	}{
+		{
+			name:                "Markdown. Success.",
+			id:                  "7",
+			expectedBody:        "<p><em>hi</em> <!-- raw HTML omitted --></p>\n",
+			expectedContentType: "text/html; charset=utf-8",
+			expectedStatus:      http.StatusOK,
+		},
+		{
+			name:                "Markdown. Stale If-None-Match.",
+			id:                  "7",
+			ifNoneMatch:         `"0"`,
+			expectedBody:        "<p><em>hi</em> <!-- raw HTML omitted --></p>\n",
+			expectedContentType: "text/html; charset=utf-8",
+			expectedStatus:      http.StatusOK,
+		},
+		{
+			name:           "Markdown. Not modified.",
+			id:             "7",
+			ifNoneMatch:    `W/"1"`,
+			expectedBody:   "",
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:                "Plain text. Success.",
+			id:                  "8",
+			expectedBody:        "<p>a &amp; b<br>\nc</p>\n",
+			expectedContentType: "text/html; charset=utf-8",
+			expectedStatus:      http.StatusOK,
+		},
+		{
+			name:                "Post 4. Fail.",
+			id:                  "4",
+			expectedBody:        `{"status":404,"message":"post 4 was not found"}`,
+			expectedContentType: "application/json",
+			expectedStatus:      http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/posts/"+test.id+"/html", nil)
+		if test.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+
+		r.ServeHTTP(rec, req)
+
+		assert.Equal(t, test.expectedBody, rec.Body.String(), test.name)
+		assert.Equal(t, test.expectedContentType, rec.Header().Get("Content-Type"), test.name)
 		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+		if test.expectedStatus != http.StatusNotFound {
+			assert.Equal(t, `"1"`, rec.Header().Get("ETag"), test.name)
+		}
 	}
 }
 
@@ -202,6 +424,12 @@ func TestAddEndpoint(t *testing.T) {
 			expectedBody:   `{"status":400,"message":"author field cannot be empty."}`,
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name:           "Control character in name",
+			body:           `{"name":"a\u0007b","author":"vt"}`,
+			expectedBody:   `{"status":400,"message":"name field cannot contain control characters."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, test := range tests {
@@ -216,3 +444,1839 @@ func TestAddEndpoint(t *testing.T) {
 		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
 	}
 }
+
+func TestUpdateEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeUpdateEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/{id}", ep).Methods("PUT")
+
+	tests := []struct {
+		name           string
+		id             string
+		ifMatch        string
+		body           string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Success.",
+			id:             "1",
+			body:           `{"name":"renamed","author":"robot","created_at":"2021-02-28T20:15:24Z"}`,
+			expectedBody:   `{"id":1,"name":"renamed","author":"robot","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:02Z"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Matching If-Match. Success.",
+			id:             "1",
+			ifMatch:        `"1"`,
+			body:           `{"name":"renamed","author":"robot","created_at":"2021-02-28T20:15:24Z"}`,
+			expectedBody:   `{"id":1,"name":"renamed","author":"robot","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:02Z"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Stale If-Match. Fail.",
+			id:             "1",
+			ifMatch:        `"0"`,
+			body:           `{"name":"renamed","author":"robot","created_at":"2021-02-28T20:15:24Z"}`,
+			expectedBody:   `{"status":412,"message":"If-Match header doesn't match the current post version."}`,
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Non-integer ID. Fail.",
+			id:             "pog",
+			body:           `{"name":"renamed","author":"robot","created_at":"2021-02-28T20:15:24Z"}`,
+			expectedBody:   `{"status":400,"message":"unable to parse an ID."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Post 4. Fail.",
+			id:             "4",
+			body:           `{"name":"renamed","author":"robot","created_at":"2021-02-28T20:15:24Z"}`,
+			expectedBody:   `{"status":404,"message":"post 4 was not found"}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Empty author",
+			id:             "1",
+			body:           `{"name":"renamed","created_at":"2021-02-28T20:15:24Z"}`,
+			expectedBody:   `{"status":400,"message":"author field cannot be empty."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/api/posts/"+test.id, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		if test.ifMatch != "" {
+			req.Header.Set("If-Match", test.ifMatch)
+		}
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestPatchEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makePatchEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/{id}", ep).Methods("PATCH")
+
+	tests := []struct {
+		name           string
+		id             string
+		contentType    string
+		body           string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Rename. Success.",
+			id:             "2",
+			contentType:    "application/merge-patch+json",
+			body:           `{"name":"renamed"}`,
+			expectedBody:   fmt.Sprintf(`{"id":2,"name":"renamed","author":"vt","created_at":"%v","updated_at":"%v"}`, time.Unix(1, 0).Format(time.RFC3339), time.Unix(2, 0).Format(time.RFC3339)),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Plain JSON content type. Success.",
+			id:             "2",
+			contentType:    "application/json",
+			body:           `{"author":"robot"}`,
+			expectedBody:   fmt.Sprintf(`{"id":2,"name":"test2","author":"robot","created_at":"%v","updated_at":"%v"}`, time.Unix(1, 0).Format(time.RFC3339), time.Unix(2, 0).Format(time.RFC3339)),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Null removes a required field.",
+			id:             "2",
+			contentType:    "application/merge-patch+json",
+			body:           `{"author":null}`,
+			expectedBody:   `{"status":400,"message":"author field cannot be empty."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown field.",
+			id:             "2",
+			contentType:    "application/merge-patch+json",
+			body:           `{"unknown_field":true}`,
+			expectedBody:   `{"status":400,"message":"Request body contains unknown field \"unknown_field\""}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unsupported content type.",
+			id:             "2",
+			contentType:    "text/plain",
+			body:           `{"name":"renamed"}`,
+			expectedBody:   `{"status":415,"message":"Content-Type header is not application/merge-patch+json"}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Post 4. Fail.",
+			id:             "4",
+			contentType:    "application/merge-patch+json",
+			body:           `{"name":"renamed"}`,
+			expectedBody:   `{"status":404,"message":"post 4 was not found"}`,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/api/posts/"+test.id, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestDeleteEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeDeleteEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/{id}", ep).Methods("DELETE")
+
+	tests := []struct {
+		name           string
+		id             string
+		ifMatch        string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Unconditional. Success.",
+			id:             "1",
+			expectedBody:   `{"status":200,"message":"Successfully removed a post with ID: 1"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Matching If-Match. Success.",
+			id:             "2",
+			ifMatch:        `"2"`,
+			expectedBody:   `{"status":200,"message":"Successfully removed a post with ID: 2"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Stale If-Match. Fail.",
+			id:             "2",
+			ifMatch:        `"1"`,
+			expectedBody:   `{"status":412,"message":"If-Match header doesn't match the current post version."}`,
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Weak If-Match. Fail.",
+			id:             "2",
+			ifMatch:        `W/"2"`,
+			expectedBody:   `{"status":412,"message":"If-Match header doesn't match the current post version."}`,
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Post 4. Fail.",
+			id:             "4",
+			expectedBody:   `{"status":404,"message":"post 4 was not found"}`,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/api/posts/"+test.id, nil)
+		if test.ifMatch != "" {
+			req.Header.Set("If-Match", test.ifMatch)
+		}
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestSearchEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeSearchEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts", ep).Methods("GET")
+
+	created := time.Unix(1, 0).Format(time.RFC3339)
+	tests := []struct {
+		name           string
+		query          string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Filter by author without bodies. Success.",
+			query:          "author=vt&order=asc&omit_body=true",
+			expectedBody:   fmt.Sprintf(`{"posts":[{"id":1,"name":"test","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"},{"id":3,"name":"test2","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}]}`, created),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "First page.",
+			query:          "limit=2",
+			expectedBody:   fmt.Sprintf(`{"posts":[{"id":3,"name":"test2","author":"vt","created_at":"%[1]v","updated_at":"%[1]v","body":"body"},{"id":2,"name":"test","author":"robot","created_at":"%[1]v","updated_at":"%[1]v"}],"next_cursor":"2"}`, created),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Last page.",
+			query:          "limit=2&cursor=2",
+			expectedBody:   fmt.Sprintf(`{"posts":[{"id":1,"name":"test","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}]}`, created),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "No results.",
+			query:          "author=nobody",
+			expectedBody:   `{"posts":[]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Bad limit.",
+			query:          "limit=0",
+			expectedBody:   `{"status":400,"message":"limit must be an integer between 1 and 1000."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Bad cursor.",
+			query:          "cursor=pog",
+			expectedBody:   `{"status":400,"message":"invalid cursor, use a cursor returned for the same search order"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Created in a time range.",
+			query:          "created_after=1970-01-01T00:00:01Z&created_before=1970-01-01T00:00:02Z&author=robot",
+			expectedBody:   fmt.Sprintf(`{"posts":[{"id":2,"name":"test","author":"robot","created_at":"%[1]v","updated_at":"%[1]v"}]}`, created),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Created before all posts.",
+			query:          "created_before=1970-01-01T00:00:01Z",
+			expectedBody:   `{"posts":[]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Bad time.",
+			query:          "created_after=yesterday",
+			expectedBody:   `{"status":400,"message":"created_after must be an RFC 3339 timestamp."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Empty time range.",
+			query:          "created_after=2021-01-02T00:00:00Z&created_before=2021-01-01T00:00:00Z",
+			expectedBody:   `{"status":400,"message":"created_after must be earlier than created_before."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Full-text search.",
+			query:          "q=TEST2",
+			expectedBody:   fmt.Sprintf(`{"posts":[{"id":3,"name":"test2","author":"vt","created_at":"%[1]v","updated_at":"%[1]v","body":"body"}]}`, created),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Relevance without a query.",
+			query:          "order=relevance",
+			expectedBody:   `{"status":400,"message":"relevance sort option requires a q parameter."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Filter by tag.",
+			query:          "tag=robots",
+			expectedBody:   fmt.Sprintf(`{"posts":[{"id":2,"name":"test","author":"robot","created_at":"%[1]v","updated_at":"%[1]v"}]}`, created),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Filter by all tags.",
+			query:          "tag=robots&tag=go",
+			expectedBody:   `{"posts":[]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Filter by any tag.",
+			query:          "tag=go&tag=robots&tag_match=any",
+			expectedBody:   fmt.Sprintf(`{"posts":[{"id":2,"name":"test","author":"robot","created_at":"%[1]v","updated_at":"%[1]v"}]}`, created),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Name prefix.",
+			query:          "name=TEST2&match=prefix",
+			expectedBody:   fmt.Sprintf(`{"posts":[{"id":3,"name":"test2","author":"vt","created_at":"%[1]v","updated_at":"%[1]v","body":"body"}]}`, created),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Bad match.",
+			query:          "name=test&match=fuzzy",
+			expectedBody:   `{"status":400,"message":"Unknown match option: fuzzy."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Bad tag_match.",
+			query:          "tag=go&tag_match=some",
+			expectedBody:   `{"status":400,"message":"Unknown tag_match option: some."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Bad omit_body.",
+			query:          "omit_body=maybe",
+			expectedBody:   `{"status":400,"message":"omit_body must be a boolean."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Bad order.",
+			query:          "order=random",
+			expectedBody:   `{"status":400,"message":"Unknown sort option: random."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/posts?"+test.query, nil)
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestProblemResponse(t *testing.T) {
+	svc := serviceMock{}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/{id}", makeGetEndpoint(svc)).Methods("GET")
+	r.HandleFunc("/api/posts", makeAddEndpoint(svc)).Methods("POST")
+
+	tests := []struct {
+		name                string
+		method              string
+		url                 string
+		body                string
+		accept              string
+		expectedBody        string
+		expectedContentType string
+		expectedStatus      int
+	}{
+		{
+			name:                "Not found. Legacy response.",
+			method:              "GET",
+			url:                 "/api/posts/4",
+			accept:              "application/json",
+			expectedBody:        `{"status":404,"message":"post 4 was not found"}`,
+			expectedContentType: "application/json",
+			expectedStatus:      http.StatusNotFound,
+		},
+		{
+			name:                "Not found. JSON is preferred.",
+			method:              "GET",
+			url:                 "/api/posts/4",
+			accept:              "application/json, application/problem+json;q=0.9",
+			expectedBody:        `{"status":404,"message":"post 4 was not found"}`,
+			expectedContentType: "application/json",
+			expectedStatus:      http.StatusNotFound,
+		},
+		{
+			name:                "Not found. Problem response.",
+			method:              "GET",
+			url:                 "/api/posts/4",
+			accept:              "application/json;q=0.5, application/problem+json",
+			expectedBody:        `{"type":"about:blank","title":"Not Found","status":404,"detail":"post 4 was not found","instance":"/api/posts/4"}`,
+			expectedContentType: "application/problem+json",
+			expectedStatus:      http.StatusNotFound,
+		},
+		{
+			name:                "Database is unavailable. Problem response.",
+			method:              "GET",
+			url:                 "/api/posts/5",
+			accept:              "application/problem+json",
+			expectedBody:        `{"type":"about:blank","title":"Service Unavailable","status":503,"detail":"database is unavailable","instance":"/api/posts/5"}`,
+			expectedContentType: "application/problem+json",
+			expectedStatus:      http.StatusServiceUnavailable,
+		},
+		{
+			name:                "Empty fields. Problem response.",
+			method:              "POST",
+			url:                 "/api/posts",
+			body:                `{"name":"","author":""}`,
+			accept:              "application/problem+json",
+			expectedBody:        `{"type":"about:blank","title":"Bad Request","status":400,"detail":"author field cannot be empty.","instance":"/api/posts","invalid_params":[{"name":"author","reason":"cannot be empty"},{"name":"name","reason":"cannot be empty"}]}`,
+			expectedContentType: "application/problem+json",
+			expectedStatus:      http.StatusBadRequest,
+		},
+		{
+			name:                "Unknown field. Problem response.",
+			method:              "POST",
+			url:                 "/api/posts",
+			body:                `{"name":"test","unknown_field":true}`,
+			accept:              "application/problem+json",
+			expectedBody:        `{"type":"about:blank","title":"Bad Request","status":400,"detail":"Request body contains unknown field \"unknown_field\"","instance":"/api/posts","invalid_params":[{"name":"unknown_field","reason":"unknown field"}]}`,
+			expectedContentType: "application/problem+json",
+			expectedStatus:      http.StatusBadRequest,
+		},
+		{
+			name:                "Invalid field type. Problem response.",
+			method:              "POST",
+			url:                 "/api/posts",
+			body:                `{"name":1,"author":"vt"}`,
+			accept:              "application/problem+json",
+			expectedBody:        `{"type":"about:blank","title":"Bad Request","status":400,"detail":"Request body contains an invalid value for the \"name\" field (at position 9)","instance":"/api/posts","invalid_params":[{"name":"name","reason":"invalid value type"}]}`,
+			expectedContentType: "application/problem+json",
+			expectedStatus:      http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", test.accept)
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedContentType, rec.Header().Get("Content-Type"), test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func (m serviceMock) Tags(_ context.Context, filter *post.TagFilter) ([]post.TagCount, error) {
+	counts := []post.TagCount{{Tag: "go", Count: 2}, {Tag: "robots", Count: 1}}
+	if filter.Order == post.Ascending {
+		counts[0], counts[1] = counts[1], counts[0]
+	}
+
+	if filter.Limit > 0 && int64(len(counts)) > filter.Limit {
+		counts = counts[:filter.Limit]
+	}
+
+	return counts, nil
+}
+
+func TestCountEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeCountEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/count", ep)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "All authors.",
+			expectedBody:   `{"total_count":3,"authors":[{"name":"vt","count":2},{"name":"robot","count":1}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Top author.",
+			query:          "?limit=1",
+			expectedBody:   `{"total_count":3,"authors":[{"name":"vt","count":2}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Ascending.",
+			query:          "?order=asc",
+			expectedBody:   `{"total_count":3,"authors":[{"name":"robot","count":1},{"name":"vt","count":2}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Single author.",
+			query:          "?author=robot",
+			expectedBody:   `{"total_count":1,"authors":[{"name":"robot","count":1}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown author.",
+			query:          "?author=nobody",
+			expectedBody:   `{"total_count":0,"authors":[]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Author of any case.",
+			query:          "?author=VT&match=ci",
+			expectedBody:   `{"total_count":2,"authors":[{"name":"VT","count":2}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown match.",
+			query:          "?author=vt&match=fuzzy",
+			expectedBody:   `{"status":400,"message":"Unknown match option: fuzzy."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid limit.",
+			query:          "?limit=0",
+			expectedBody:   `{"status":400,"message":"limit must be an integer between 1 and 1000."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown order.",
+			query:          "?order=relevance",
+			expectedBody:   `{"status":400,"message":"Unknown sort option: relevance."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/count"+test.query, nil)
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestTagsEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeTagsEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/tags", ep)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "All tags.",
+			expectedBody:   `{"tags":[{"name":"go","count":2},{"name":"robots","count":1}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Least used tag.",
+			query:          "?order=asc&limit=1",
+			expectedBody:   `{"tags":[{"name":"robots","count":1}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid limit.",
+			query:          "?limit=1001",
+			expectedBody:   `{"status":400,"message":"limit must be an integer between 1 and 1000."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown order.",
+			query:          "?order=relevance",
+			expectedBody:   `{"status":400,"message":"Unknown sort option: relevance."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/tags"+test.query, nil)
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+//Post 9 is the only trashed post.
+func (m serviceMock) Restore(ctx context.Context, id int64) (*post.Post, error) {
+	if id == 9 {
+		return &post.Post{ID: 9, Name: "test9", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Version: 3}, nil
+	}
+
+	if _, err := m.FindOne(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return nil, post.Errorf(post.ErrConflict, "post %v isn't in the trash", id)
+}
+
+func (m serviceMock) FindTrashed(_ context.Context, filter *post.TrashFilter) ([]*post.Post, string, error) {
+	if filter.Cursor != "" {
+		return nil, "", post.ErrInvalidCursor
+	}
+
+	deletedAt := time.Unix(2, 0).UTC()
+	return []*post.Post{{ID: 9, Name: "test9", Author: "vt", CreatedAt: time.Unix(1, 0).UTC(), UpdatedAt: time.Unix(1, 0).UTC(), DeletedAt: &deletedAt, Version: 2}}, "", nil
+}
+
+func (m serviceMock) Purge(_ context.Context, _ time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestRestoreEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeRestoreEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/{id}/restore", ep).Methods("POST")
+
+	tests := []struct {
+		name           string
+		id             string
+		expectedBody   string
+		expectedETag   string
+		expectedStatus int
+	}{
+		{
+			name:           "Trashed post. Success.",
+			id:             "9",
+			expectedBody:   `{"id":9,"name":"test9","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"}`,
+			expectedETag:   `"3"`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Post isn't in the trash. Fail.",
+			id:             "1",
+			expectedBody:   `{"status":409,"message":"post 1 isn't in the trash"}`,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Post 4. Fail.",
+			id:             "4",
+			expectedBody:   `{"status":404,"message":"post 4 was not found"}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid ID. Fail.",
+			id:             "abc",
+			expectedBody:   `{"status":400,"message":"unable to parse an ID."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/posts/"+test.id+"/restore", nil)
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedETag, rec.Header().Get("ETag"), test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestTrashEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeTrashEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/trash", ep)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "First page.",
+			expectedBody:   `{"posts":[{"id":9,"name":"test9","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z","deleted_at":"1970-01-01T00:00:02Z"}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid cursor.",
+			query:          "?cursor=abc",
+			expectedBody:   `{"status":400,"message":"invalid cursor, use a cursor returned for the same search order"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid limit.",
+			query:          "?limit=0",
+			expectedBody:   `{"status":400,"message":"limit must be an integer between 1 and 1000."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/trash"+test.query, nil)
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+//Post 1 is the only post with a history.
+func (m serviceMock) Revisions(ctx context.Context, id int64) ([]*post.Revision, error) {
+	if _, err := m.FindOne(ctx, id); err != nil {
+		return nil, err
+	}
+
+	if id != 1 {
+		return []*post.Revision{}, nil
+	}
+
+	return []*post.Revision{
+		{
+			Number:    1,
+			Action:    post.ActionCreate,
+			Editor:    "vt",
+			CreatedAt: time.Unix(1, 0).UTC(),
+			Changed:   []string{"name", "author", "created_at"},
+			Post:      &post.Post{ID: 1, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0).UTC(), UpdatedAt: time.Unix(1, 0).UTC(), Version: 1},
+		},
+	}, nil
+}
+
+func (m serviceMock) Revision(ctx context.Context, id int64, n int64) (*post.Revision, error) {
+	revisions, err := m.Revisions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rev := range revisions {
+		if rev.Number == n {
+			return rev, nil
+		}
+	}
+
+	return nil, post.Errorf(post.ErrNotFound, "revision %v of post %v was not found", n, id)
+}
+
+func (m serviceMock) Revert(ctx context.Context, id int64, n int64, version int64) (*post.Post, error) {
+	rev, err := m.Revision(ctx, id, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Update(ctx, id, rev.Post, version)
+}
+
+func TestRevisionsEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/{id}/revisions", makeRevisionsEndpoint(svc))
+	r.HandleFunc("/api/posts/{id}/revisions/{n}", makeRevisionEndpoint(svc))
+
+	first := `{"number":1,"action":"create","editor":"vt","created_at":"1970-01-01T00:00:01Z","changed":["name","author","created_at"],"post":{"id":1,"name":"test","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"}}`
+	tests := []struct {
+		name           string
+		path           string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "History of post 1.",
+			path:           "/api/posts/1/revisions",
+			expectedBody:   `{"revisions":[` + first + `]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Empty history.",
+			path:           "/api/posts/2/revisions",
+			expectedBody:   `{"revisions":[]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Post 4.",
+			path:           "/api/posts/4/revisions",
+			expectedBody:   `{"status":404,"message":"post 4 was not found"}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Single revision.",
+			path:           "/api/posts/1/revisions/1",
+			expectedBody:   first,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown revision.",
+			path:           "/api/posts/1/revisions/2",
+			expectedBody:   `{"status":404,"message":"revision 2 of post 1 was not found"}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid revision number.",
+			path:           "/api/posts/1/revisions/0",
+			expectedBody:   `{"status":400,"message":"revision number must be a positive integer."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.path, nil)
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestRevertEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeRevertEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/{id}/revisions/{n}/revert", ep).Methods("POST")
+
+	tests := []struct {
+		name           string
+		path           string
+		ifMatch        string
+		expectedBody   string
+		expectedETag   string
+		expectedStatus int
+	}{
+		{
+			name:           "Matching If-Match. Success.",
+			path:           "/api/posts/1/revisions/1/revert",
+			ifMatch:        `"1"`,
+			expectedBody:   `{"id":1,"name":"test","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:02Z"}`,
+			expectedETag:   `"2"`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Stale If-Match. Fail.",
+			path:           "/api/posts/1/revisions/1/revert",
+			ifMatch:        `"3"`,
+			expectedBody:   `{"status":412,"message":"If-Match header doesn't match the current post version."}`,
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Unknown revision. Fail.",
+			path:           "/api/posts/1/revisions/5/revert",
+			expectedBody:   `{"status":404,"message":"revision 5 of post 1 was not found"}`,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", test.path, nil)
+		if test.ifMatch != "" {
+			req.Header.Set("If-Match", test.ifMatch)
+		}
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedETag, rec.Header().Get("ETag"), test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func (m serviceMock) CreateMany(_ context.Context, posts []*post.Post, atomic bool) ([]post.BatchResult, error) {
+	if len(posts) > post.MaxBatchSize {
+		return nil, post.Errorf(post.ErrInvalid, "batch can't have more than %v items", post.MaxBatchSize)
+	}
+
+	results := make([]post.BatchResult, len(posts))
+	for i, p := range posts {
+		if _, err := post.ValidatePost(p, post.Options{}); err != nil {
+			results[i].Err = err
+		} else {
+			results[i].ID = int64(i + 1)
+		}
+	}
+
+	return abortBatch(results, atomic), nil
+}
+
+func (m serviceMock) RemoveMany(_ context.Context, ids []int64, atomic bool) ([]post.BatchResult, error) {
+	results := make([]post.BatchResult, len(ids))
+	for i, id := range ids {
+		if id > 3 {
+			results[i].Err = post.Errorf(post.ErrNotFound, "post %v was not found", id)
+		} else {
+			results[i].ID = id
+		}
+	}
+
+	return abortBatch(results, atomic), nil
+}
+
+//abortBatch fails every written item of an atomic batch with a failed item.
+func abortBatch(results []post.BatchResult, atomic bool) []post.BatchResult {
+	if !atomic {
+		return results
+	}
+
+	for _, res := range results {
+		if res.Err == nil {
+			continue
+		}
+
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = post.BatchResult{Err: post.ErrBatchAborted}
+			}
+		}
+		break
+	}
+
+	return results
+}
+
+func TestBatchAddEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeBatchAddEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/batch", ep).Methods("POST")
+
+	tests := []struct {
+		name           string
+		query          string
+		body           string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Success.",
+			body:           `[{"name":"test","author":"vt"},{"name":"test 2","author":"vt"}]`,
+			expectedBody:   `{"results":[{"id":1,"status":200},{"id":2,"status":200}],"failed":0}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid item. Partial failure.",
+			body:           `[{"name":"test","author":"vt"},{"name":"","author":""}]`,
+			expectedBody:   `{"results":[{"id":1,"status":200},{"status":400,"message":"author field cannot be empty.","invalid_params":[{"name":"author","reason":"cannot be empty"},{"name":"name","reason":"cannot be empty"}]}],"failed":1}`,
+			expectedStatus: http.StatusMultiStatus,
+		},
+		{
+			name:           "Invalid item. Atomic batch is aborted.",
+			query:          "?atomic=true",
+			body:           `[{"name":"test","author":"vt"},{"name":"test 2","author":""}]`,
+			expectedBody:   `{"results":[{"status":424,"message":"not written, another item of the batch failed"},{"status":400,"message":"author field cannot be empty.","invalid_params":[{"name":"author","reason":"cannot be empty"}]}],"failed":2}`,
+			expectedStatus: http.StatusMultiStatus,
+		},
+		{
+			name:           "Empty batch.",
+			body:           `[]`,
+			expectedBody:   `{"results":[],"failed":0}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Null item.",
+			body:           `[{"name":"test","author":"vt"},null]`,
+			expectedBody:   `{"status":400,"message":"Item 1 of the batch is null."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Not an array.",
+			body:           `{"name":"test","author":"vt"}`,
+			expectedBody:   `{"status":400,"message":"Request body contains an invalid value for the \"\" field (at position 1)"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Bad atomic.",
+			query:          "?atomic=maybe",
+			body:           `[]`,
+			expectedBody:   `{"status":400,"message":"atomic must be a boolean."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Too many items.",
+			body:           "[" + strings.Repeat(`{"name":"test","author":"vt"},`, post.MaxBatchSize) + `{"name":"test","author":"vt"}]`,
+			expectedBody:   `{"status":400,"message":"batch can't have more than 1000 items"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/posts/batch"+test.query, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestBatchDeleteEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeBatchDeleteEndpoint(svc)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/batch", ep).Methods("DELETE")
+
+	tests := []struct {
+		name           string
+		query          string
+		body           string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Success.",
+			body:           `[1,2]`,
+			expectedBody:   `{"results":[{"id":1,"status":200},{"id":2,"status":200}],"failed":0}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Missing post. Partial failure.",
+			body:           `[1,4]`,
+			expectedBody:   `{"results":[{"id":1,"status":200},{"status":404,"message":"post 4 was not found"}],"failed":1}`,
+			expectedStatus: http.StatusMultiStatus,
+		},
+		{
+			name:           "Missing post. Atomic batch is aborted.",
+			query:          "?atomic=1",
+			body:           `[1,4]`,
+			expectedBody:   `{"results":[{"status":424,"message":"not written, another item of the batch failed"},{"status":404,"message":"post 4 was not found"}],"failed":2}`,
+			expectedStatus: http.StatusMultiStatus,
+		},
+		{
+			name:           "Badly formatted JSON. Trailing coma.",
+			body:           `[1,2,]`,
+			expectedBody:   `{"status":400,"message":"Request body contains badly-formatted JSON."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/api/posts/batch"+test.query, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func (m serviceMock) FindByIDs(ctx context.Context, ids []int64) ([]*post.Post, []int64, error) {
+	posts, missing := make([]*post.Post, 0), make([]int64, 0)
+	for _, id := range ids {
+		p, err := m.FindOne(ctx, id)
+		switch {
+		case errors.Is(err, post.ErrNotFound):
+			missing = append(missing, id)
+		case err != nil:
+			return nil, nil, err
+		default:
+			posts = append(posts, p)
+		}
+	}
+
+	return posts, missing, nil
+}
+
+func TestLookupEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts", makeSearchEndpoint(svc)).Methods("GET")
+	r.HandleFunc("/api/posts/lookup", makeLookupEndpoint(svc)).Methods("POST")
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		body           string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "IDs query. Success.",
+			method:         "GET",
+			url:            "/api/posts?ids=2,4,1&limit=1",
+			expectedBody:   `{"posts":[{"id":2,"name":"test2","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"},{"id":1,"name":"test1","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"}],"missing":[4]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Lookup body. Success.",
+			method:         "POST",
+			url:            "/api/posts/lookup",
+			body:           `[4, 3]`,
+			expectedBody:   `{"posts":[{"id":3,"name":"test3","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"}],"missing":[4]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Nothing found.",
+			method:         "POST",
+			url:            "/api/posts/lookup",
+			body:           `[]`,
+			expectedBody:   `{"posts":[],"missing":[]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Bad ID.",
+			method:         "GET",
+			url:            "/api/posts?ids=1,a",
+			expectedBody:   `{"status":400,"message":"ids must be a comma-separated list of integers."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Database is unavailable.",
+			method:         "GET",
+			url:            "/api/posts?ids=1,5",
+			expectedBody:   `{"status":503,"message":"database is unavailable"}`,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+
+		r.ServeHTTP(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func (m serviceMock) Import(_ context.Context, records []*post.Record) ([]post.BatchResult, error) {
+	results := make([]post.BatchResult, len(records))
+	for i, rec := range records {
+		switch _, err := post.ValidatePost(rec.Post, post.Options{}); {
+		case err != nil:
+			results[i].Err = err
+		case rec.ID <= 3:
+			results[i].Err = post.Errorf(post.ErrConflict, "post %v already exists", rec.ID)
+		default:
+			results[i].ID = rec.ID
+		}
+	}
+
+	return results, nil
+}
+
+func (m serviceMock) Export(ctx context.Context, full bool, fn func(*post.Record) error) error {
+	for _, id := range []int64{1, 2} {
+		p, _ := m.FindOne(ctx, id)
+		rec := &post.Record{ID: id, Post: p}
+		if full {
+			rec.Revisions, _ = m.Revisions(ctx, id)
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestExportEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeExportEndpoint(svc)
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name: "Live posts.",
+			url:  "/api/export",
+			expectedBody: `{"id":1,"name":"test1","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"}
+{"id":2,"name":"test2","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"}
+`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Full export.",
+			url:  "/api/export?full=true",
+			expectedBody: `{"id":1,"post":{"id":1,"name":"test1","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"},"revisions":[{"number":1,"action":"create","editor":"vt","created_at":"1970-01-01T00:00:01Z","changed":["name","author","created_at"],"post":{"id":1,"name":"test","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"}}]}
+{"id":2,"post":{"id":2,"name":"test2","author":"vt","created_at":"1970-01-01T00:00:01Z","updated_at":"1970-01-01T00:00:01Z"}}
+`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid full parameter.",
+			url:            "/api/export?full=all",
+			expectedBody:   `{"status":400,"message":"full must be a boolean."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.url, nil)
+
+		ep(rec, req)
+
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+		assert.Equal(t, test.expectedBody, rec.Body.String(), test.name)
+		if test.expectedStatus == http.StatusOK {
+			assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"), test.name)
+		}
+	}
+}
+
+func TestImportEndpoint(t *testing.T) {
+	svc := serviceMock{}
+	ep := makeImportEndpoint(svc)
+
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Success.",
+			contentType:    "application/x-ndjson",
+			body:           "{\"id\":4,\"name\":\"test\",\"author\":\"vt\"}\n{\"id\":5,\"name\":\"test\",\"author\":\"vt\"}\n",
+			expectedBody:   `{"imported":2,"failed":[]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Taken ID and invalid post. Partial failure.",
+			contentType:    "application/x-ndjson",
+			body:           "{\"id\":1,\"name\":\"test\",\"author\":\"vt\"}\n{\"id\":4,\"name\":\"test\",\"author\":\"vt\"}\n{\"id\":5,\"name\":\"\",\"author\":\"vt\"}",
+			expectedBody:   `{"imported":1,"failed":[{"line":1,"id":1,"status":409,"message":"post 1 already exists"},{"line":3,"id":5,"status":400,"message":"name field cannot be empty.","invalid_params":[{"name":"name","reason":"cannot be empty"}]}]}`,
+			expectedStatus: http.StatusMultiStatus,
+		},
+		{
+			name:           "Malformed line.",
+			contentType:    "application/x-ndjson",
+			body:           "{\"id\":4,\"name\":\"test\",\"author\":\"vt\"}\n{\"id\":5,\n",
+			expectedBody:   `{"status":400,"message":"line 2 is not a valid post: unexpected EOF"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Body too large.",
+			contentType:    "application/x-ndjson",
+			body:           strings.Repeat(strings.Repeat(" ", 1<<20)+"\n", maxImportSize>>20+1),
+			expectedBody:   `{"status":413,"message":"Request body shouldn't be larger than 64MB, posts before the limit were imported."}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "Wrong content type.",
+			contentType:    "application/json",
+			body:           "{\"id\":4,\"name\":\"test\",\"author\":\"vt\"}",
+			expectedBody:   `{"status":415,"message":"Content-Type header is not application/x-ndjson"}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/import", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+
+		ep(rec, req)
+		body := rec.Body.String()
+
+		assert.Equal(t, test.expectedBody, body, test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestCSVResponse(t *testing.T) {
+	svc := serviceMock{}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts", makeSearchEndpoint(svc)).Methods("GET")
+	r.HandleFunc("/api/count", makeCountEndpoint(svc)).Methods("GET")
+	created := time.Unix(1, 0).Format(time.RFC3339Nano)
+
+	tests := []struct {
+		name            string
+		url             string
+		accept          string
+		expectedBody    string
+		expectedType    string
+		expectedHeaders map[string]string
+		expectedStatus  int
+	}{
+		{
+			name:            "Accept header.",
+			url:             "/api/posts?order=asc&limit=2",
+			accept:          "text/csv",
+			expectedBody:    fmt.Sprintf("id,name,author,created_at,updated_at,format,tags,body\n1,test,vt,%[1]v,%[1]v,,,\n2,test,robot,%[1]v,%[1]v,,,\n", created),
+			expectedType:    "text/csv; charset=utf-8",
+			expectedHeaders: map[string]string{"X-Next-Cursor": "2"},
+			expectedStatus:  http.StatusOK,
+		},
+		{
+			name:           "Format parameter.",
+			url:            "/api/posts?name=test2&format=csv",
+			expectedBody:   fmt.Sprintf("id,name,author,created_at,updated_at,format,tags,body\n3,test2,vt,%[1]v,%[1]v,,,body\n", created),
+			expectedType:   "text/csv; charset=utf-8",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Format parameter takes precedence.",
+			url:            "/api/posts?name=test2&format=json",
+			accept:         "text/csv",
+			expectedBody:   fmt.Sprintf(`{"posts":[{"id":3,"name":"test2","author":"vt","created_at":"%[1]v","updated_at":"%[1]v","body":"body"}]}`, created),
+			expectedType:   "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "JSON by default.",
+			url:            "/api/count?author=robot",
+			accept:         "application/json, */*",
+			expectedBody:   `{"total_count":1,"authors":[{"name":"robot","count":1}]}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "JSON has a higher quality.",
+			url:            "/api/count?author=robot",
+			accept:         "application/json, text/csv;q=0.5",
+			expectedBody:   `{"total_count":1,"authors":[{"name":"robot","count":1}]}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Text wildcard.",
+			url:            "/api/count?author=robot",
+			accept:         "text/*, application/json;q=0.8",
+			expectedBody:   "author,count\nrobot,1\n",
+			expectedType:   "text/csv; charset=utf-8",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:            "Author counts.",
+			url:             "/api/count",
+			accept:          "text/html, text/csv;q=0.9",
+			expectedBody:    "author,count\nvt,2\nrobot,1\n",
+			expectedType:    "text/csv; charset=utf-8",
+			expectedHeaders: map[string]string{"X-Total-Count": "3"},
+			expectedStatus:  http.StatusOK,
+		},
+		{
+			name:           "Errors are JSON.",
+			url:            "/api/count?limit=0",
+			accept:         "text/csv",
+			expectedBody:   `{"status":400,"message":"limit must be an integer between 1 and 1000."}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:            "Posts by IDs.",
+			url:             "/api/posts?ids=2,4&format=csv",
+			expectedBody:    fmt.Sprintf("id,name,author,created_at,updated_at,format,tags,body\n2,test2,vt,%[1]v,%[1]v,,,\n", created),
+			expectedType:    "text/csv; charset=utf-8",
+			expectedHeaders: map[string]string{"X-Missing-IDs": "4"},
+			expectedStatus:  http.StatusOK,
+		},
+		{
+			name:           "Posts by IDs with Accept header.",
+			url:            "/api/posts?ids=1",
+			accept:         "text/csv",
+			expectedBody:   fmt.Sprintf("id,name,author,created_at,updated_at,format,tags,body\n1,test1,vt,%[1]v,%[1]v,,,\n", created),
+			expectedType:   "text/csv; charset=utf-8",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Posts by IDs with unknown format.",
+			url:            "/api/posts?ids=1&format=xml",
+			expectedBody:   `{"status":400,"message":"Unknown format: xml."}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown format.",
+			url:            "/api/posts?format=xml",
+			expectedBody:   `{"status":400,"message":"Unknown format: xml."}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.url, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+
+		r.ServeHTTP(rec, req)
+
+		assert.Equal(t, test.expectedBody, rec.Body.String(), test.name)
+		assert.Equal(t, test.expectedType, rec.Header().Get("Content-Type"), test.name)
+		for header, value := range test.expectedHeaders {
+			assert.Equal(t, value, rec.Header().Get(header), test.name)
+		}
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+	}
+}
+
+func TestCSVEscaping(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := &responseWriter{rec, nil}
+	p := &post.Post{ID: 1, Name: `say "hi"`, Author: "vt, robot", CreatedAt: time.Unix(1, 500).UTC(), UpdatedAt: time.Unix(2, 0).UTC(), Format: post.FormatMarkdown, Tags: []string{"go", "sql"}, Body: "line 1\nline 2"}
+
+	rw.CSV(postHeader, 1, func(int) []string { return postRecord(p) })
+
+	assert.Equal(t, "id,name,author,created_at,updated_at,format,tags,body\n"+
+		`1,"say ""hi""","vt, robot",1970-01-01T00:00:01.0000005Z,1970-01-01T00:00:02Z,markdown,"go,sql","line 1`+"\n"+`line 2"`+"\n", rec.Body.String())
+}
+
+func TestCSVFormulas(t *testing.T) {
+	tests := []struct {
+		field    string
+		expected string
+	}{
+		{"=HYPERLINK(\"http://example.com\")", `"'=HYPERLINK(""http://example.com"")"`},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"\rcmd", "\"'\rcmd\""},
+		{"a=b", "a=b"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		rw := &responseWriter{rec, nil}
+
+		rw.CSV([]string{"name"}, 1, func(int) []string { return []string{test.field} })
+
+		assert.Equal(t, "name\n"+test.expected+"\n", rec.Body.String(), test.field)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	svc := serviceMock{}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/posts/{id}", makeGetEndpoint(svc)).Methods("GET")
+	r.HandleFunc("/api/posts/{id}/revisions", makeRevisionsEndpoint(svc)).Methods("GET")
+	r.HandleFunc("/api/posts", makeAddEndpoint(svc)).Methods("POST")
+	r.HandleFunc("/api/posts/lookup", makeLookupEndpoint(svc)).Methods("POST")
+	created := time.Unix(1, 0).Format(time.RFC3339)
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		accept      string
+		//body is encoded with the codec of contentType, rawBody is sent as is if body is nil.
+		body    interface{}
+		rawBody string
+		//message is the post.proto message of Protocol Buffers responses.
+		message protoreflect.Name
+		//expectedBody is the JSON document of the response body, Protocol Buffers responses are mapped to JSON by protojson.
+		expectedBody   string
+		expectedType   string
+		expectedStatus int
+	}{
+		{
+			name:           "MessagePack request and response.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/msgpack",
+			accept:         "application/msgpack",
+			body:           map[string]interface{}{"name": "test", "author": "vt"},
+			expectedBody:   `{"status":200,"message":"Successfully created a new post.","id":1}`,
+			expectedType:   "application/msgpack",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Protocol Buffers request and response.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/x-protobuf",
+			accept:         "application/x-protobuf",
+			body:           &post.Post{Name: "test", Author: "vt", Tags: []string{"go"}},
+			message:        "Status",
+			expectedBody:   `{"status":200,"message":"Successfully created a new post.","id":"1"}`,
+			expectedType:   "application/x-protobuf",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "MessagePack post.",
+			method:         "GET",
+			url:            "/api/posts/1",
+			accept:         "application/msgpack",
+			expectedBody:   fmt.Sprintf(`{"id":1,"name":"test1","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}`, created),
+			expectedType:   "application/msgpack",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Protocol Buffers post.",
+			method:         "GET",
+			url:            "/api/posts/1",
+			accept:         "text/html, application/x-protobuf;q=0.9",
+			message:        "Post",
+			expectedBody:   fmt.Sprintf(`{"id":"1","name":"test1","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}`, created),
+			expectedType:   "application/x-protobuf",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "JSON is preferred.",
+			method:         "GET",
+			url:            "/api/posts/1",
+			accept:         "application/msgpack, application/json",
+			expectedBody:   fmt.Sprintf(`{"id":1,"name":"test1","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}`, created),
+			expectedType:   "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Errors are encoded.",
+			method:         "GET",
+			url:            "/api/posts/4",
+			accept:         "application/x-protobuf",
+			message:        "Status",
+			expectedBody:   `{"status":404,"message":"post 4 was not found"}`,
+			expectedType:   "application/x-protobuf",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Quality order.",
+			method:         "GET",
+			url:            "/api/posts/1",
+			accept:         "application/json;q=0.5, application/msgpack",
+			expectedBody:   fmt.Sprintf(`{"id":1,"name":"test1","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}`, created),
+			expectedType:   "application/msgpack",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Zero quality rules a codec out.",
+			method:         "GET",
+			url:            "/api/posts/1",
+			accept:         "application/json;q=0, */*",
+			expectedBody:   fmt.Sprintf(`{"id":1,"name":"test1","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}`, created),
+			expectedType:   "application/msgpack",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Type wildcard.",
+			method:         "GET",
+			url:            "/api/posts/1",
+			accept:         "text/*;q=0.9, application/*",
+			expectedBody:   fmt.Sprintf(`{"id":1,"name":"test1","author":"vt","created_at":"%[1]v","updated_at":"%[1]v"}`, created),
+			expectedType:   "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Not acceptable.",
+			method:         "GET",
+			url:            "/api/posts/1",
+			accept:         "text/html",
+			expectedBody:   `{"status":406,"message":"Accept header doesn't list any media type of this response: application/json, application/msgpack, application/x-protobuf"}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusNotAcceptable,
+		},
+		{
+			name:           "Errors keep their status.",
+			method:         "GET",
+			url:            "/api/posts/4",
+			accept:         "text/html",
+			expectedBody:   `{"status":404,"message":"post 4 was not found"}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Response without a message.",
+			method:         "GET",
+			url:            "/api/posts/2/revisions",
+			accept:         "application/x-protobuf, application/json;q=0.5",
+			expectedBody:   `{"revisions":[]}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Response without a message falls back to JSON.",
+			method:         "GET",
+			url:            "/api/posts/2/revisions",
+			accept:         "application/x-protobuf",
+			expectedBody:   `{"revisions":[]}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Protocol Buffers. Request without a message.",
+			method:         "POST",
+			url:            "/api/posts/lookup",
+			contentType:    "application/x-protobuf",
+			rawBody:        "\x08\x01",
+			expectedBody:   `{"status":415,"message":"Only posts can be sent as application/x-protobuf, use application/json or application/msgpack"}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Problem details take precedence.",
+			method:         "GET",
+			url:            "/api/posts/4",
+			accept:         "application/msgpack, application/problem+json",
+			expectedBody:   `{"type":"about:blank","title":"Not Found","status":404,"detail":"post 4 was not found","instance":"/api/posts/4"}`,
+			expectedType:   "application/problem+json",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Content-Type parameters.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/json; charset=utf-8",
+			rawBody:        `{"name":"test","author":"vt"}`,
+			expectedBody:   `{"status":200,"message":"Successfully created a new post.","id":1}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unsupported Content-Type.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "text/plain",
+			rawBody:        "test",
+			expectedBody:   `{"status":415,"message":"Content-Type header is not one of application/json, application/msgpack, application/x-protobuf"}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "MessagePack. Unknown field.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/msgpack",
+			body:           map[string]interface{}{"name": "test", "views": 1},
+			expectedBody:   `{"status":400,"message":"Request body contains unknown field \"views\""}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "MessagePack. Invalid value.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/msgpack",
+			body:           map[string]interface{}{"name": 1},
+			expectedBody:   `{"status":400,"message":"Request body contains badly-formatted MessagePack."}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "MessagePack. Badly formatted.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/msgpack",
+			rawBody:        "\xc1",
+			expectedBody:   `{"status":400,"message":"Request body contains badly-formatted MessagePack."}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "MessagePack. Empty body.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/msgpack",
+			expectedBody:   `{"status":400,"message":"Request body is empty"}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Protocol Buffers. Unknown field.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/x-protobuf",
+			rawBody:        "\x12\x04test\xa0\x01\x01",
+			expectedBody:   `{"status":400,"message":"Request body contains unknown field 20"}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Protocol Buffers. Badly formatted.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/x-protobuf",
+			rawBody:        "\xff",
+			expectedBody:   `{"status":400,"message":"Request body contains badly-formatted Protocol Buffers."}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Protocol Buffers. Empty body.",
+			method:         "POST",
+			url:            "/api/posts",
+			contentType:    "application/x-protobuf",
+			expectedBody:   `{"status":400,"message":"Request body is empty"}`,
+			expectedType:   "application/json",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		body := []byte(test.rawBody)
+		if test.body != nil {
+			c, err := requestCodec(&http.Request{Header: http.Header{"Content-Type": {test.contentType}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if body, err = c.marshal(test.body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.url, bytes.NewReader(body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+
+		r.ServeHTTP(rec, req)
+
+		assert.Equal(t, test.expectedType, rec.Header().Get("Content-Type"), test.name)
+		assert.Equal(t, test.expectedStatus, rec.Code, test.name)
+		assert.JSONEq(t, test.expectedBody, responseJSON(t, rec, test.message), test.name)
+	}
+}
+
+//responseJSON decodes a recorded response with the codec of its Content-Type and returns its JSON document.
+//Protocol Buffers responses are decoded as a message of post.proto.
+func responseJSON(t *testing.T, rec *httptest.ResponseRecorder, message protoreflect.Name) string {
+	var doc interface{}
+	switch rec.Header().Get("Content-Type") {
+	case "application/msgpack":
+		if err := msgpack.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+	case "application/x-protobuf":
+		m := newProtoMessage(message)
+		if err := proto.Unmarshal(rec.Body.Bytes(), m.Message); err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m.Message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(raw)
+	default:
+		return rec.Body.String()
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(raw)
+}
+
+func TestProtobufMessages(t *testing.T) {
+	deletedAt := time.Unix(3, 0).UTC()
+	p := &post.Post{ID: 1, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 500).UTC(), UpdatedAt: time.Unix(2, 0).UTC(), Body: "*hi*", Format: post.FormatMarkdown, Tags: []string{"go", "sql"}, DeletedAt: &deletedAt}
+
+	raw, err := marshalProtobuf(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded post.Post
+	if err := decodeProtobuf(bytes.NewReader(raw), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p, &decoded)
+
+	tests := []struct {
+		src      interface{}
+		message  protoreflect.Name
+		expected string
+	}{
+		{
+			src:      searchResp{Posts: []*post.Post{{ID: 2, Name: "test", Author: "vt"}}, NextCursor: "2"},
+			message:  "SearchResponse",
+			expected: `{"posts":[{"id":"2","name":"test","author":"vt"}],"next_cursor":"2"}`,
+		},
+		{
+			src:      &countResp{Count: 3, Authors: []*authorCount{{"vt", 2}, {"robot", 1}}},
+			message:  "CountResponse",
+			expected: `{"total_count":"3","authors":[{"name":"vt","count":"2"},{"name":"robot","count":"1"}]}`,
+		},
+		{
+			src:      jsonResp{http.StatusNotFound, "post 4 was not found"},
+			message:  "Status",
+			expected: `{"status":404,"message":"post 4 was not found"}`,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/x-protobuf")
+
+		raw, err := marshalProtobuf(test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Body.Write(raw)
+
+		assert.JSONEq(t, test.expected, responseJSON(t, rec, test.message), string(test.message))
+	}
+
+	_, err = marshalProtobuf(lookupResp{})
+	assert.ErrorIs(t, err, errNoMessage)
+}
@@ -0,0 +1,122 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/VTGare/softserve-homework/pkg/post"
+)
+
+//problem is an RFC 7807 problem details object.
+type problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	InvalidParams []invalidParam `json:"invalid_params,omitempty"`
+}
+
+//invalidParam describes why a request field or parameter is invalid.
+type invalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+//requestError is an error caused by a malformed request, as opposed to errors returned by post.Service.
+type requestError struct {
+	status  int
+	message string
+	params  []invalidParam
+}
+
+//newRequestError creates a request error with a status code, a message and optional invalid parameters.
+func newRequestError(status int, message string, params ...invalidParam) *requestError {
+	return &requestError{status, message, params}
+}
+
+//Error satisfies in-built Error interface
+func (e *requestError) Error() string {
+	return e.message
+}
+
+//errorStatus maps an error returned by post.Service to an HTTP status code.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, post.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, post.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, post.ErrBatchAborted):
+		return http.StatusFailedDependency
+	case errors.Is(err, post.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, post.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, post.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+//Error writes a request error or an error returned by post.Service with a matching status code.
+//
+//Clients that list application/problem+json and prefer it over the codecs get an RFC 7807 document, others get a jsonResp
+//encoded with a negotiated codec.
+func (w *responseWriter) Error(err error) {
+	var (
+		status int
+		msg    string
+		params []invalidParam
+		reqErr *requestError
+	)
+
+	if errors.As(err, &reqErr) {
+		status, msg, params = reqErr.status, reqErr.message, reqErr.params
+	} else {
+		status, msg, params = errorStatus(err), err.Error(), invalidParams(err)
+		if status == http.StatusPreconditionFailed {
+			msg = "If-Match header doesn't match the current post version."
+		}
+	}
+
+	if w.req == nil || !prefersProblem(w.req) {
+		//Errors are sent as JSON even if the client accepts none of the codecs.
+		if !w.encode(jsonResp{status, msg}, status) {
+			w.write(jsonCodec, jsonResp{status, msg}, status)
+		}
+		return
+	}
+
+	w.write(problemCodec, problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        msg,
+		Instance:      w.req.URL.Path,
+		InvalidParams: params,
+	}, status)
+}
+
+//invalidParams lists invalid fields of a post rejected by post.Service.
+func invalidParams(err error) []invalidParam {
+	var (
+		params []invalidParam
+		valErr *post.ValidationError
+	)
+
+	if errors.As(err, &valErr) {
+		for _, field := range valErr.Fields {
+			params = append(params, invalidParam{field.Field, field.Reason})
+		}
+	}
+
+	return params
+}
+
+//prefersProblem reports whether the Accept header of r lists application/problem+json and ranks it above the codecs.
+func prefersProblem(r *http.Request) bool {
+	accepted := negotiate(r, append([]string{problemCodec.mediaType}, codecMediaTypes()...))
+	return len(accepted) != 0 && accepted[0] == problemCodec.mediaType && accepts(r, problemCodec.mediaType)
+}
@@ -0,0 +1,47 @@
+package endpoints
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VTGare/softserve-homework/pkg/post"
+)
+
+//formatETag turns a post version into a strong entity tag.
+func formatETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+//parseIfMatch parses an If-Match header value into a version expected by write operations.
+//Missing header and "*" result in post.AnyVersion. The second return value is false if header can't match any version.
+func parseIfMatch(header string) (int64, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return post.AnyVersion, true
+	}
+
+	//If-Match requires strong comparison, weak tags never match.
+	if len(header) < 2 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, false
+	}
+
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version < 0 {
+		return 0, false
+	}
+
+	return version, true
+}
+
+//noneMatch reports whether an If-None-Match header value matches etag using weak comparison.
+func noneMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
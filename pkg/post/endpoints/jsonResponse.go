@@ -1,12 +1,15 @@
 package endpoints
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/VTGare/softserve-homework/pkg/post"
 )
 
 type jsonResp struct {
@@ -19,34 +22,159 @@ type newPostResp struct {
 	ID int64 `json:"id"`
 }
 
+type searchResp struct {
+	Posts      []*post.Post `json:"posts"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
 type countResp struct {
-	Count   int            `json:"total_count"`
+	Count   int64          `json:"total_count"`
 	Authors []*authorCount `json:"authors"`
 }
 
 type authorCount struct {
 	Name  string `json:"name"`
-	Count int    `json:"count"`
+	Count int64  `json:"count"`
 }
 
-//Error satisfies in-built Error interface
-func (r *jsonResp) Error() string {
-	return r.Message
+type tagsResp struct {
+	Tags []*tagCount `json:"tags"`
+}
+
+type tagCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
 }
 
-//responseWriter is a http.ResponseWriter wrapper that adds JSON decoding and encoding methods.
+type revisionsResp struct {
+	Revisions []*post.Revision `json:"revisions"`
+}
+
+type lookupResp struct {
+	Posts   []*post.Post `json:"posts"`
+	Missing []int64      `json:"missing"`
+}
+
+type batchResp struct {
+	Results []*batchItem `json:"results"`
+	Failed  int          `json:"failed"`
+}
+
+//batchItem is a result of a batch item, ID is set if the item was written.
+type batchItem struct {
+	ID            int64          `json:"id,omitempty"`
+	Status        int            `json:"status"`
+	Message       string         `json:"message,omitempty"`
+	InvalidParams []invalidParam `json:"invalid_params,omitempty"`
+}
+
+type importResp struct {
+	Imported int              `json:"imported"`
+	Failed   []*importFailure `json:"failed"`
+}
+
+//importFailure is a line of an import that wasn't imported.
+type importFailure struct {
+	Line          int            `json:"line"`
+	ID            int64          `json:"id,omitempty"`
+	Status        int            `json:"status"`
+	Message       string         `json:"message"`
+	InvalidParams []invalidParam `json:"invalid_params,omitempty"`
+}
+
+//responseWriter is a http.ResponseWriter wrapper that adds encoding methods.
+//The request is used to negotiate response formats and codecs.
 type responseWriter struct {
 	http.ResponseWriter
+	req *http.Request
 }
 
-//JSON encodes src, writes it to the ResponseWriter and changes Content-Type header to "application/json"
+//Encode encodes src with a codec negotiated from the Accept header, writes it to the ResponseWriter and changes
+//Content-Type header to the media type of the codec. JSON is used if the client doesn't ask for another codec.
+//Codecs that can't encode src are skipped, src is sent as JSON if they were the only acceptable ones.
+//The response is 406 Not Acceptable if the client accepts no codec at all.
 //
 //Status is 200 by default, you can optionally overwrite it by passing a second argument.
-func (w *responseWriter) JSON(src interface{}, status ...int) {
-	msg, err := json.Marshal(src)
+func (w *responseWriter) Encode(src interface{}, status ...int) {
+	code := http.StatusOK
+	if len(status) != 0 {
+		code = status[0]
+	}
+
+	if w.encode(src, code) {
+		return
+	}
+
+	var available []string
+	for _, c := range codecs {
+		if _, err := c.marshal(src); !errors.Is(err, errNoMessage) {
+			available = append(available, c.mediaType)
+		}
+	}
+
+	w.write(jsonCodec, jsonResp{http.StatusNotAcceptable, "Accept header doesn't list any media type of this response: " + strings.Join(available, ", ")}, http.StatusNotAcceptable)
+}
+
+//encode writes src with the first acceptable codec that can encode it and reports whether there was one.
+func (w *responseWriter) encode(src interface{}, status int) bool {
+	//format=json keeps JSON regardless of the Accept header.
+	accepted := []*codec{jsonCodec}
+	if w.req != nil && w.req.URL.Query().Get("format") != "json" {
+		accepted = responseCodecs(w.req)
+	}
+
+	for _, c := range accepted {
+		msg, err := c.marshal(src)
+		if errors.Is(err, errNoMessage) {
+			continue
+		}
+
+		w.send(c, msg, err, status)
+		return true
+	}
+
+	//Responses without a Protocol Buffers message fall back to JSON rather than being unacceptable.
+	if len(accepted) != 0 {
+		msg, err := jsonCodec.marshal(src)
+		w.send(jsonCodec, msg, err, status)
+		return true
+	}
+
+	return false
+}
+
+//batch writes results of a batch write. Status is 200 if every item was written and 207 Multi-Status otherwise.
+func (w *responseWriter) batch(results []post.BatchResult) {
+	resp := batchResp{Results: make([]*batchItem, len(results))}
+	for i, res := range results {
+		if res.Err == nil {
+			resp.Results[i] = &batchItem{ID: res.ID, Status: http.StatusOK}
+			continue
+		}
+
+		resp.Failed++
+		resp.Results[i] = &batchItem{Status: errorStatus(res.Err), Message: res.Err.Error(), InvalidParams: invalidParams(res.Err)}
+	}
+
+	status := http.StatusOK
+	if resp.Failed != 0 {
+		status = http.StatusMultiStatus
+	}
+
+	w.Encode(resp, status)
+}
+
+//write encodes src with a codec and writes it with the Content-Type header of the codec and a status code.
+func (w *responseWriter) write(c *codec, src interface{}, status int) {
+	msg, err := c.marshal(src)
+	w.send(c, msg, err, status)
+}
+
+//send writes a body encoded with a codec, or 500 if encoding failed.
+func (w *responseWriter) send(c *codec, msg []byte, err error, status int) {
 	if err != nil {
-		w.WriteHeader(500)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
 
 		msg, _ := json.Marshal(jsonResp{500, err.Error()})
 		w.Write(msg)
@@ -54,69 +182,108 @@ func (w *responseWriter) JSON(src interface{}, status ...int) {
 		return
 	}
 
-	if len(status) != 0 {
-		w.WriteHeader(status[0])
-	} else {
-		w.WriteHeader(200)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", c.mediaType)
+	w.WriteHeader(status)
 	w.Write(msg)
 }
 
-//decodeJSONBody decodes JSON from http.Request.Body to dst. This function errors if any of the following is true:
-//
-//- Content-Type is not application/json,
-//
-//- Body size is larger than 1MB,
-//
-//- Body contains badly formatted JSON,
-//
-//- JSON contains unknown fields
+//decodeMergePatch applies a JSON merge patch (RFC 7396) from http.Request.Body to original and decodes the result to dst.
+//Content-Type must be either application/merge-patch+json or application/json, all other rules of decodeBody apply.
 //
-//- Body is empty
-func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) error {
-	if r.Header.Get("Content-Type") != "application/json" {
-		return &jsonResp{http.StatusUnsupportedMediaType, "Content-Type header is not application/json"}
+//Fields removed by the patch are left zero in dst, so dst should point to a zero value.
+func decodeMergePatch(w http.ResponseWriter, r *http.Request, original, dst interface{}) error {
+	switch r.Header.Get("Content-Type") {
+	case "application/merge-patch+json", "application/json":
+	default:
+		return newRequestError(http.StatusUnsupportedMediaType, "Content-Type header is not application/merge-patch+json")
 	}
 
 	//Limit JSON body size to 1MB.
 	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
 
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
+	var patch interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return decodeError(err)
+	}
 
-	err := dec.Decode(&dst)
+	raw, err := json.Marshal(original)
 	if err != nil {
-		var (
-			syntaxError    *json.SyntaxError
-			unmarshalError *json.UnmarshalTypeError
-		)
+		return err
+	}
 
-		switch {
-		case errors.As(err, &syntaxError) || errors.Is(err, io.ErrUnexpectedEOF):
-			return &jsonResp{http.StatusBadRequest, "Request body contains badly-formatted JSON."}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
 
-		case errors.As(err, &unmarshalError):
-			msg := fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalError.Field, unmarshalError.Offset)
-			return &jsonResp{http.StatusBadRequest, msg}
+	merged, err := json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return err
+	}
 
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			msg := fmt.Sprintf("Request body contains unknown field %s", fieldName)
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
 
-			return &jsonResp{http.StatusBadRequest, msg}
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+
+	return nil
+}
 
-		case errors.Is(err, io.EOF):
-			return &jsonResp{http.StatusBadRequest, "Request body is empty"}
+//mergePatch merges patch into target following RFC 7396: objects are merged recursively, null removes a member
+//and any other value replaces the target.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
 
-		case err.Error() == "http: request body too large":
-			return &jsonResp{http.StatusRequestEntityTooLarge, "Request body shouldn't be larger than 1MB."}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
 
-		default:
-			return err
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
 		}
+
+		targetObj[k] = mergePatch(targetObj[k], v)
 	}
 
-	return nil
+	return targetObj
+}
+
+//decodeError turns a JSON decoding error into a user friendly *requestError. Unknown errors are returned as is.
+func decodeError(err error) error {
+	var (
+		syntaxError    *json.SyntaxError
+		unmarshalError *json.UnmarshalTypeError
+	)
+
+	switch {
+	case errors.As(err, &syntaxError) || errors.Is(err, io.ErrUnexpectedEOF):
+		return newRequestError(http.StatusBadRequest, "Request body contains badly-formatted JSON.")
+
+	case errors.As(err, &unmarshalError):
+		msg := fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalError.Field, unmarshalError.Offset)
+		return newRequestError(http.StatusBadRequest, msg, invalidParam{unmarshalError.Field, "invalid value type"})
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		msg := fmt.Sprintf("Request body contains unknown field %s", fieldName)
+
+		return newRequestError(http.StatusBadRequest, msg, invalidParam{strings.Trim(fieldName, `"`), "unknown field"})
+
+	case errors.Is(err, io.EOF):
+		return newRequestError(http.StatusBadRequest, "Request body is empty")
+
+	case err.Error() == "http: request body too large":
+		return newRequestError(http.StatusRequestEntityTooLarge, "Request body shouldn't be larger than 1MB.")
+
+	default:
+		return err
+	}
 }
@@ -0,0 +1,276 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/VTGare/softserve-homework/pkg/post"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	//Registers google/protobuf/timestamp.proto imported by post.proto.
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//errNoMessage is returned by marshalProtobuf for values that have no message in post.proto.
+var errNoMessage = errors.New("value has no Protocol Buffers message")
+
+//protoFile is the descriptor of post.proto. It's built by hand, so the package doesn't need generated code.
+var protoFile = func() protoreflect.FileDescriptor {
+	const (
+		typeInt32   = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		typeInt64   = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		typeString  = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		typeMessage = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		timestamp   = ".google.protobuf.Timestamp"
+	)
+
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: typ.Enum(), Label: label.Enum()}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+
+		return f
+	}
+
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("post.proto"),
+		Package:    proto.String("softserve.post"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		Syntax:     proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("Post",
+				field("id", 1, typeInt64, "", false),
+				field("name", 2, typeString, "", false),
+				field("author", 3, typeString, "", false),
+				field("created_at", 4, typeMessage, timestamp, false),
+				field("updated_at", 5, typeMessage, timestamp, false),
+				field("body", 6, typeString, "", false),
+				field("format", 7, typeString, "", false),
+				field("tags", 8, typeString, "", true),
+				field("deleted_at", 9, typeMessage, timestamp, false),
+			),
+			message("SearchResponse",
+				field("posts", 1, typeMessage, ".softserve.post.Post", true),
+				field("next_cursor", 2, typeString, "", false),
+			),
+			message("CountResponse",
+				field("total_count", 1, typeInt64, "", false),
+				field("authors", 2, typeMessage, ".softserve.post.AuthorCount", true),
+			),
+			message("AuthorCount",
+				field("name", 1, typeString, "", false),
+				field("count", 2, typeInt64, "", false),
+			),
+			message("Status",
+				field("status", 1, typeInt32, "", false),
+				field("message", 2, typeString, "", false),
+				field("id", 3, typeInt64, "", false),
+			),
+		},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+
+	return fd
+}()
+
+//protoMessage is a dynamic message of post.proto. Setters leave zero values unset, proto3 doesn't encode them anyway.
+type protoMessage struct {
+	*dynamicpb.Message
+}
+
+//newProtoMessage creates an empty message of post.proto by its name.
+func newProtoMessage(name protoreflect.Name) protoMessage {
+	return protoMessage{dynamicpb.NewMessage(protoFile.Messages().ByName(name))}
+}
+
+func (m protoMessage) field(name protoreflect.Name) protoreflect.FieldDescriptor {
+	return m.Descriptor().Fields().ByName(name)
+}
+
+func (m protoMessage) setInt(name protoreflect.Name, v int64) {
+	if v == 0 {
+		return
+	}
+
+	fd := m.field(name)
+	if fd.Kind() == protoreflect.Int32Kind {
+		m.Set(fd, protoreflect.ValueOfInt32(int32(v)))
+		return
+	}
+	m.Set(fd, protoreflect.ValueOfInt64(v))
+}
+
+func (m protoMessage) setString(name protoreflect.Name, v string) {
+	if v != "" {
+		m.Set(m.field(name), protoreflect.ValueOfString(v))
+	}
+}
+
+func (m protoMessage) setTime(name protoreflect.Name, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+
+	fd := m.field(name)
+	ts := m.NewField(fd).Message()
+	ts.Set(ts.Descriptor().Fields().ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+	ts.Set(ts.Descriptor().Fields().ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+	m.Set(fd, protoreflect.ValueOfMessage(ts))
+}
+
+//time returns a timestamp field in UTC, like times of JSON bodies. It's zero if the field isn't set.
+func (m protoMessage) time(name protoreflect.Name) time.Time {
+	fd := m.field(name)
+	if !m.Has(fd) {
+		return time.Time{}
+	}
+
+	ts := m.Get(fd).Message()
+	seconds := ts.Get(ts.Descriptor().Fields().ByName("seconds")).Int()
+	nanos := ts.Get(ts.Descriptor().Fields().ByName("nanos")).Int()
+
+	return time.Unix(seconds, nanos).UTC()
+}
+
+//appendMessage appends a message to a repeated field.
+func (m protoMessage) appendMessage(name protoreflect.Name, v protoMessage) {
+	m.Mutable(m.field(name)).List().Append(protoreflect.ValueOfMessage(v.Message))
+}
+
+//postMessage converts a post to a Post message.
+func postMessage(p *post.Post) protoMessage {
+	m := newProtoMessage("Post")
+	m.setInt("id", p.ID)
+	m.setString("name", p.Name)
+	m.setString("author", p.Author)
+	m.setTime("created_at", p.CreatedAt)
+	m.setTime("updated_at", p.UpdatedAt)
+	m.setString("body", p.Body)
+	m.setString("format", p.Format)
+	if len(p.Tags) != 0 {
+		tags := m.Mutable(m.field("tags")).List()
+		for _, tag := range p.Tags {
+			tags.Append(protoreflect.ValueOfString(tag))
+		}
+	}
+	if p.DeletedAt != nil {
+		m.setTime("deleted_at", *p.DeletedAt)
+	}
+
+	return m
+}
+
+//messagePost converts a Post message to a post.
+func messagePost(m protoMessage) *post.Post {
+	p := &post.Post{
+		ID:        m.Get(m.field("id")).Int(),
+		Name:      m.Get(m.field("name")).String(),
+		Author:    m.Get(m.field("author")).String(),
+		CreatedAt: m.time("created_at"),
+		UpdatedAt: m.time("updated_at"),
+		Body:      m.Get(m.field("body")).String(),
+		Format:    m.Get(m.field("format")).String(),
+	}
+
+	tags := m.Get(m.field("tags")).List()
+	for i := 0; i < tags.Len(); i++ {
+		p.Tags = append(p.Tags, tags.Get(i).String())
+	}
+
+	if deletedAt := m.time("deleted_at"); !deletedAt.IsZero() {
+		p.DeletedAt = &deletedAt
+	}
+
+	return p
+}
+
+//marshalProtobuf encodes src to its message in post.proto. Values without a message return errNoMessage.
+func marshalProtobuf(src interface{}) ([]byte, error) {
+	var m protoMessage
+	switch v := src.(type) {
+	case *post.Post:
+		m = postMessage(v)
+	case searchResp:
+		m = newProtoMessage("SearchResponse")
+		for _, p := range v.Posts {
+			m.appendMessage("posts", postMessage(p))
+		}
+		m.setString("next_cursor", v.NextCursor)
+	case *countResp:
+		m = newProtoMessage("CountResponse")
+		m.setInt("total_count", v.Count)
+		for _, author := range v.Authors {
+			count := newProtoMessage("AuthorCount")
+			count.setString("name", author.Name)
+			count.setInt("count", author.Count)
+			m.appendMessage("authors", count)
+		}
+	case jsonResp:
+		m = newProtoMessage("Status")
+		m.setInt("status", int64(v.Status))
+		m.setString("message", v.Message)
+	case newPostResp:
+		m = newProtoMessage("Status")
+		m.setInt("status", int64(v.Status))
+		m.setString("message", v.Message)
+		m.setInt("id", v.ID)
+	default:
+		return nil, errNoMessage
+	}
+
+	return proto.Marshal(m.Message)
+}
+
+//decodeProtobuf decodes a Post message to dst. Only posts can be sent as Protocol Buffers and unknown fields aren't allowed.
+func decodeProtobuf(body io.Reader, dst interface{}) error {
+	p, ok := dst.(*post.Post)
+	if !ok {
+		return newRequestError(http.StatusUnsupportedMediaType, "Only posts can be sent as application/x-protobuf, use application/json or application/msgpack")
+	}
+
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return decodeError(err)
+	}
+
+	if len(raw) == 0 {
+		return newRequestError(http.StatusBadRequest, "Request body is empty")
+	}
+
+	m := newProtoMessage("Post")
+	if err := proto.Unmarshal(raw, m.Message); err != nil {
+		return newRequestError(http.StatusBadRequest, "Request body contains badly-formatted Protocol Buffers.")
+	}
+
+	if unknown := m.GetUnknown(); len(unknown) != 0 {
+		num, _, _ := protowire.ConsumeTag(unknown)
+		msg := fmt.Sprintf("Request body contains unknown field %v", num)
+
+		return newRequestError(http.StatusBadRequest, msg, invalidParam{fmt.Sprint(num), "unknown field"})
+	}
+
+	*p = *messagePost(m)
+	return nil
+}
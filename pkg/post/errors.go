@@ -0,0 +1,69 @@
+package post
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//Error kinds returned by Service. Use errors.Is to check an error's kind, its message is meant for clients.
+var (
+	//ErrNotFound means a requested post doesn't exist.
+	ErrNotFound = errors.New("not found")
+	//ErrConflict means an operation conflicts with the current state of a post.
+	ErrConflict = errors.New("conflict")
+	//ErrInvalid means an operation got invalid arguments.
+	ErrInvalid = errors.New("invalid argument")
+	//ErrUnavailable means the database can't be reached, the operation may succeed later.
+	ErrUnavailable = errors.New("unavailable")
+)
+
+//ErrVersionMismatch is returned by write operations when the stored post version differs from the expected one.
+var ErrVersionMismatch error = &kindError{kind: ErrConflict, msg: "post version mismatch"}
+
+//ErrInvalidCursor is returned by FindMany if SearchFilter.Cursor wasn't issued for the same search order.
+var ErrInvalidCursor error = &kindError{kind: ErrInvalid, msg: "invalid cursor, use a cursor returned for the same search order"}
+
+//kindError is an error of a kind with its own message. It optionally wraps an underlying error.
+type kindError struct {
+	kind  error
+	msg   string
+	cause error
+}
+
+//Errorf formats an error of a kind, errors.Is reports true for the result and kind.
+func Errorf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+//Error satisfies in-built Error interface
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+//Is reports whether target is the error's kind.
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+//Unwrap returns the underlying error.
+func (e *kindError) Unwrap() error {
+	return e.cause
+}
+
+//storageError marks errors caused by an unreachable database as ErrUnavailable. Other errors are returned as is.
+func storageError(err error) error {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &netErr), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, io.EOF), errors.Is(err, redis.ErrClosed):
+		return &kindError{kind: ErrUnavailable, msg: "database is unavailable", cause: err}
+	}
+
+	return err
+}
@@ -0,0 +1,46 @@
+package post
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//Match is a way SearchFilter's name and author are compared with stored posts.
+type Match int
+
+//Match type enum
+const (
+	//MatchExact matches names and authors equal to the filter.
+	MatchExact Match = iota
+	//MatchCaseInsensitive matches names and authors equal to the filter ignoring case and repeated, leading or trailing whitespace.
+	MatchCaseInsensitive
+	//MatchPrefix matches names and authors starting with the filter, compared the same way as MatchCaseInsensitive.
+	MatchPrefix
+)
+
+//matchKey normalizes s for case-insensitive and prefix matching: it's NFC normalized and lower-cased,
+//whitespace runs are collapsed into single spaces and trimmed.
+func matchKey(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(norm.NFC.String(s)), " "))
+}
+
+//normalizeFilter normalizes a name or author filter like ValidatePost normalizes stored names and authors,
+//so exact filters with stray whitespace still match.
+func normalizeFilter(s string) string {
+	return strings.TrimSpace(norm.NFC.String(s))
+}
+
+//matches reports whether a stored value matches a filter value. Empty filters match anything.
+func matches(value, filter string, match Match) bool {
+	switch {
+	case filter == "":
+		return true
+	case match == MatchCaseInsensitive:
+		return matchKey(value) == matchKey(filter)
+	case match == MatchPrefix:
+		return strings.HasPrefix(matchKey(value), matchKey(filter))
+	default:
+		return value == filter
+	}
+}
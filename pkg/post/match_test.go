@@ -0,0 +1,29 @@
+package post
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		filter   string
+		match    Match
+		expected bool
+	}{
+		{"Exact.", "Intro to Go", "Intro to Go", MatchExact, true},
+		{"Exact is case-sensitive.", "Intro to Go", "intro to go", MatchExact, false},
+		{"Case and whitespace.", "Intro\tto  Go", " intro to go ", MatchCaseInsensitive, true},
+		{"Unicode normalization.", "Cafe\u0301", "CAFÉ", MatchCaseInsensitive, true},
+		{"Prefix.", "Introduction", "INTRO", MatchPrefix, true},
+		{"Prefix doesn't match the middle.", "Go intro", "intro", MatchPrefix, false},
+		{"Empty filter.", "anything", "", MatchCaseInsensitive, true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, matches(test.value, test.filter, test.match), test.name)
+	}
+}
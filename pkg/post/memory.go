@@ -0,0 +1,590 @@
+package post
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+//memoryRepository keeps posts in a map. It's meant for tests and local development, nothing survives a restart.
+//Trashed posts stay in the map with DeletedAt set.
+type memoryRepository struct {
+	*memoryStore
+	now func() time.Time
+}
+
+//memoryStore is the state of a memory repository, copies of the repository with other clocks share it.
+type memoryStore struct {
+	mu        sync.RWMutex
+	posts     map[int64]*Post
+	revisions map[int64][]*Revision
+	nextID    int64
+}
+
+//NewMemoryRepository creates an empty in-memory repository.
+func NewMemoryRepository() Repository {
+	store := &memoryStore{posts: make(map[int64]*Post), revisions: make(map[int64][]*Revision)}
+	return &memoryRepository{store, time.Now}
+}
+
+func (mr *memoryRepository) withClock(now func() time.Time) Repository {
+	return &memoryRepository{mr.memoryStore, now}
+}
+
+func (mr *memoryRepository) Create(ctx context.Context, post *Post) (int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	return mr.create(ctx, post), nil
+}
+
+func (mr *memoryRepository) CreateMany(ctx context.Context, posts []*Post, atomic bool) ([]BatchResult, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	results := make([]BatchResult, len(posts))
+	for i, post := range posts {
+		results[i].ID = mr.create(ctx, post)
+	}
+
+	return results, nil
+}
+
+func (mr *memoryRepository) Import(ctx context.Context, records []*Record) ([]BatchResult, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	results := make([]BatchResult, len(records))
+	for i, rec := range records {
+		if _, ok := mr.posts[rec.ID]; ok {
+			results[i].Err = Errorf(ErrConflict, "post %v already exists", rec.ID)
+			continue
+		}
+
+		if len(mr.revisions[rec.ID]) != 0 {
+			results[i].Err = purgedID(rec.ID)
+			continue
+		}
+
+		if rec.ID > mr.nextID {
+			mr.nextID = rec.ID
+		}
+
+		history := importedHistory(ctx, rec, mr.now())
+		if rec.Post != nil {
+			mr.posts[rec.ID] = importedPost(rec.ID, rec.Post, history[len(history)-1].Number)
+		}
+		mr.revisions[rec.ID] = history
+		results[i].ID = rec.ID
+	}
+
+	return results, nil
+}
+
+//create stores a post with a new ID and returns the ID. The caller must hold the write lock.
+func (mr *memoryRepository) create(ctx context.Context, post *Post) int64 {
+	mr.nextID++
+	return mr.store(ctx, mr.nextID, post)
+}
+
+//store stores a post with an ID and returns the ID. The caller must hold the write lock.
+func (mr *memoryRepository) store(ctx context.Context, id int64, post *Post) int64 {
+	mr.posts[id] = &Post{
+		ID:        id,
+		Name:      post.Name,
+		Author:    post.Author,
+		CreatedAt: storedTime(post.CreatedAt),
+		UpdatedAt: storedTime(updateTime(post)),
+		Body:      post.Body,
+		Format:    post.Format,
+		Tags:      copyTags(post.Tags),
+		Version:   1,
+	}
+	mr.revise(ctx, ActionCreate, &Post{}, mr.posts[id])
+
+	return id
+}
+
+func (mr *memoryRepository) FindOne(ctx context.Context, id int64) (*Post, error) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	post, ok := mr.posts[id]
+	if !ok || post.DeletedAt != nil {
+		return nil, notFound(id)
+	}
+
+	return copyPost(post), nil
+}
+
+func (mr *memoryRepository) Export(ctx context.Context, full bool, fn func(*Record) error) error {
+	mr.mu.RLock()
+	records := make([]*Record, 0, len(mr.posts))
+	for id, post := range mr.posts {
+		if post.DeletedAt == nil || full {
+			records = append(records, &Record{ID: id, Post: copyPost(post)})
+		}
+	}
+
+	//Purged posts only have their history.
+	if full {
+		for id := range mr.revisions {
+			if _, ok := mr.posts[id]; !ok {
+				records = append(records, &Record{ID: id})
+			}
+		}
+
+		for _, rec := range records {
+			for _, rev := range mr.revisions[rec.ID] {
+				rec.Revisions = append(rec.Revisions, copyRevision(rev))
+			}
+		}
+	}
+	mr.mu.RUnlock()
+
+	//fn is called without the lock, so it can't block writes.
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mr *memoryRepository) FindByIDs(ctx context.Context, ids []int64) ([]*Post, []int64, error) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	found := make(map[int64]*Post, len(ids))
+	for _, id := range ids {
+		if post, ok := mr.posts[id]; ok && post.DeletedAt == nil {
+			found[id] = copyPost(post)
+		}
+	}
+
+	posts, missing := splitFound(ids, found)
+	return posts, missing, nil
+}
+
+func (mr *memoryRepository) FindMany(ctx context.Context, filter *SearchFilter) ([]*Post, string, error) {
+	after, err := pageStart(filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	var relevance map[int64]float64
+	if filter.Query != "" {
+		relevance = mr.rank(filter.Query)
+	}
+
+	entries := make([]cursor, 0)
+	for id, post := range mr.posts {
+		if post.DeletedAt != nil {
+			continue
+		}
+
+		if relevance != nil {
+			if _, ok := relevance[id]; !ok {
+				continue
+			}
+		}
+
+		switch {
+		case !matches(post.Name, filter.Name, filter.Match):
+			continue
+		case !matches(post.Author, filter.Author, filter.Match):
+			continue
+		case !filter.CreatedAfter.IsZero() && post.CreatedAt.Before(filter.CreatedAfter):
+			continue
+		case !filter.CreatedBefore.IsZero() && !post.CreatedAt.Before(filter.CreatedBefore):
+			continue
+		case !hasTags(post.Tags, filter):
+			continue
+		}
+
+		entries = append(entries, cursor{order: filter.Order, createdAt: post.CreatedAt, id: id})
+	}
+
+	entries, next := page(orderEntries(entries, relevance, after), filter.Limit)
+
+	posts := make([]*Post, 0, len(entries))
+	for _, entry := range entries {
+		copied := copyPost(mr.posts[entry.id])
+		if filter.OmitBody {
+			copied.Body = ""
+		}
+		posts = append(posts, copied)
+	}
+
+	return posts, next, nil
+}
+
+//rank scores posts whose names contain any of query terms the same way as the Redis term index does.
+func (mr *memoryRepository) rank(query string) map[int64]float64 {
+	terms := tokenize(query)
+
+	//Term frequencies of every post name that contains a query term.
+	postings := make([]map[int64]int, len(terms))
+	for i := range postings {
+		postings[i] = make(map[int64]int)
+	}
+
+	live := 0
+	for id, post := range mr.posts {
+		if post.DeletedAt != nil {
+			continue
+		}
+		live++
+
+		for _, t := range tokenize(post.Name) {
+			for i, qt := range terms {
+				if t.value == qt.value {
+					postings[i][id] = t.count
+				}
+			}
+		}
+	}
+
+	relevance := make(map[int64]float64)
+	for i, posting := range postings {
+		if len(posting) == 0 {
+			continue
+		}
+
+		idf := math.Log(1 + float64(live)/float64(len(posting)))
+		for id, count := range posting {
+			relevance[id] += float64(count) * idf * float64(terms[i].count)
+		}
+	}
+
+	return relevance
+}
+
+func (mr *memoryRepository) Update(ctx context.Context, id int64, post *Post, version int64) (*Post, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	old, ok := mr.posts[id]
+	if !ok || old.DeletedAt != nil {
+		return nil, notFound(id)
+	}
+
+	if version != AnyVersion && version != old.Version {
+		return nil, ErrVersionMismatch
+	}
+
+	updated := &Post{
+		ID:        id,
+		Name:      post.Name,
+		Author:    post.Author,
+		CreatedAt: old.CreatedAt,
+		UpdatedAt: storedTime(mr.now()),
+		Body:      post.Body,
+		Format:    post.Format,
+		Tags:      copyTags(post.Tags),
+		Version:   old.Version + 1,
+	}
+	mr.posts[id] = updated
+	mr.revise(ctx, ActionUpdate, old, updated)
+
+	return copyPost(updated), nil
+}
+
+func (mr *memoryRepository) Remove(ctx context.Context, id int64, version int64) (bool, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if err := mr.checkRemove(id, version); err != nil {
+		return false, err
+	}
+	mr.remove(ctx, id)
+
+	return true, nil
+}
+
+func (mr *memoryRepository) RemoveMany(ctx context.Context, ids []int64, atomic bool) ([]BatchResult, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	results := make([]BatchResult, len(ids))
+	for i, id := range ids {
+		if err := mr.checkRemove(id, AnyVersion); err != nil {
+			results[i].Err = err
+		} else {
+			results[i].ID = id
+		}
+	}
+
+	if atomic && failed(results) {
+		failBatch(results, ErrBatchAborted)
+		return results, nil
+	}
+
+	for _, res := range results {
+		if res.Err == nil {
+			mr.remove(ctx, res.ID)
+		}
+	}
+
+	return results, nil
+}
+
+//checkRemove reports why a post can't be removed. The caller must hold the lock.
+func (mr *memoryRepository) checkRemove(id int64, version int64) error {
+	post, ok := mr.posts[id]
+	if !ok || post.DeletedAt != nil {
+		return notFound(id)
+	}
+
+	if version != AnyVersion && version != post.Version {
+		return ErrVersionMismatch
+	}
+
+	return nil
+}
+
+//remove moves a post to the trash. The caller must hold the write lock.
+func (mr *memoryRepository) remove(ctx context.Context, id int64) {
+	post := mr.posts[id]
+	deletedAt := time.Unix(mr.now().Unix(), 0)
+	trashed := copyPost(post)
+	trashed.DeletedAt = &deletedAt
+	trashed.Version++
+	mr.posts[id] = trashed
+	mr.revise(ctx, ActionRemove, post, trashed)
+}
+
+func (mr *memoryRepository) Restore(ctx context.Context, id int64) (*Post, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	post, ok := mr.posts[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+
+	if post.DeletedAt == nil {
+		return nil, notTrashed(id)
+	}
+
+	restored := copyPost(post)
+	restored.DeletedAt = nil
+	restored.Version++
+	mr.posts[id] = restored
+	mr.revise(ctx, ActionRestore, post, restored)
+
+	return copyPost(restored), nil
+}
+
+func (mr *memoryRepository) FindTrashed(ctx context.Context, filter *TrashFilter) ([]*Post, string, error) {
+	after, err := trashStart(filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	entries := make([]cursor, 0)
+	for id, post := range mr.posts {
+		if post.DeletedAt != nil {
+			entries = append(entries, cursor{order: Descending, createdAt: *post.DeletedAt, id: id})
+		}
+	}
+
+	entries, next := page(orderEntries(entries, nil, after), filter.Limit)
+
+	posts := make([]*Post, 0, len(entries))
+	for _, entry := range entries {
+		posts = append(posts, copyPost(mr.posts[entry.id]))
+	}
+
+	return posts, next, nil
+}
+
+func (mr *memoryRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var purged int64
+	for id, post := range mr.posts {
+		if post.DeletedAt != nil && post.DeletedAt.Before(before) {
+			final := copyPost(post)
+			final.Version++
+			mr.revise(ctx, ActionPurge, post, final)
+			delete(mr.posts, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+//revise appends a revision of a write to the post history. The caller must hold the write lock.
+func (mr *memoryRepository) revise(ctx context.Context, action string, old, current *Post) {
+	mr.revisions[current.ID] = append(mr.revisions[current.ID], newRevision(ctx, action, old, current, mr.now()))
+}
+
+func (mr *memoryRepository) Revisions(ctx context.Context, id int64) ([]*Revision, error) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	if _, ok := mr.posts[id]; !ok && len(mr.revisions[id]) == 0 {
+		return nil, notFound(id)
+	}
+
+	revisions := make([]*Revision, 0, len(mr.revisions[id]))
+	for _, rev := range mr.revisions[id] {
+		revisions = append(revisions, copyRevision(rev))
+	}
+
+	return revisions, nil
+}
+
+func (mr *memoryRepository) Revision(ctx context.Context, id int64, n int64) (*Revision, error) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	if _, ok := mr.posts[id]; !ok && len(mr.revisions[id]) == 0 {
+		return nil, notFound(id)
+	}
+
+	for _, rev := range mr.revisions[id] {
+		if rev.Number == n {
+			return copyRevision(rev), nil
+		}
+	}
+
+	return nil, revisionNotFound(id, n)
+}
+
+func (mr *memoryRepository) Count(ctx context.Context, filter *CountFilter) ([]AuthorCount, int64, error) {
+	if err := checkCountFilter(filter); err != nil {
+		return nil, 0, err
+	}
+
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	postsCount := make(map[string]int64)
+	var total int64
+	for _, post := range mr.posts {
+		if post.DeletedAt != nil || !matches(post.Author, filter.Author, filter.Match) {
+			continue
+		}
+
+		//Every spelling of the author is counted as the filter.
+		author := post.Author
+		if filter.Match == MatchCaseInsensitive && filter.Author != "" {
+			author = filter.Author
+		}
+
+		postsCount[author]++
+		total++
+	}
+
+	authors := make([]AuthorCount, 0, len(postsCount))
+	for author, count := range postsCount {
+		authors = append(authors, AuthorCount{Author: author, Count: count})
+	}
+
+	sort.Slice(authors, func(i, j int) bool {
+		a, b := authors[i], authors[j]
+		if filter.Order != Ascending {
+			a, b = b, a
+		}
+
+		if a.Count != b.Count {
+			return a.Count < b.Count
+		}
+
+		return a.Author < b.Author
+	})
+
+	if filter.Limit > 0 && int64(len(authors)) > filter.Limit {
+		authors = authors[:filter.Limit]
+	}
+
+	return authors, total, nil
+}
+
+func (mr *memoryRepository) Tags(ctx context.Context, filter *TagFilter) ([]TagCount, error) {
+	if err := checkTagFilter(filter); err != nil {
+		return nil, err
+	}
+
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	postsCount := make(map[string]int64)
+	for _, post := range mr.posts {
+		if post.DeletedAt != nil {
+			continue
+		}
+
+		for _, tag := range post.Tags {
+			postsCount[tag]++
+		}
+	}
+
+	tags := make([]TagCount, 0, len(postsCount))
+	for tag, count := range postsCount {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		a, b := tags[i], tags[j]
+		if filter.Order != Ascending {
+			a, b = b, a
+		}
+
+		if a.Count != b.Count {
+			return a.Count < b.Count
+		}
+
+		return a.Tag < b.Tag
+	})
+
+	if filter.Limit > 0 && int64(len(tags)) > filter.Limit {
+		tags = tags[:filter.Limit]
+	}
+
+	return tags, nil
+}
+
+//copyPost returns a copy of a stored post that doesn't share tags with it.
+func copyPost(post *Post) *Post {
+	copied := *post
+	copied.Tags = copyTags(post.Tags)
+	if post.DeletedAt != nil {
+		deletedAt := *post.DeletedAt
+		copied.DeletedAt = &deletedAt
+	}
+
+	return &copied
+}
+
+//copyRevision returns a copy of a stored revision that doesn't share its post or fields with it.
+func copyRevision(rev *Revision) *Revision {
+	copied := *rev
+	copied.Changed = append([]string(nil), rev.Changed...)
+	copied.Post = copyPost(rev.Post)
+
+	return &copied
+}
+
+//copyTags returns a copy of tags, nil if there are none.
+func copyTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	return append([]string(nil), tags...)
+}
@@ -0,0 +1,161 @@
+package post
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+//MaxLineSize is the longest line ImportNDJSON reads, in bytes. A line of a full export holds the whole history of a post.
+const MaxLineSize = 16 << 20
+
+//ImportError is a line of an NDJSON import that wasn't imported.
+type ImportError struct {
+	Line int
+	ID   int64
+	Err  error
+}
+
+//ImportReport sums up an NDJSON import.
+type ImportReport struct {
+	Imported int
+	Failed   []ImportError
+}
+
+//ExportNDJSON writes every post that isn't trashed to w as a line of JSON and returns the number of written lines.
+//A full export writes a Record of every post instead, trashed and purged posts included, with its history.
+//Posts are written as they're read, the collection is never buffered.
+func ExportNDJSON(ctx context.Context, svc Service, w io.Writer, full bool) (int, error) {
+	var written int
+	enc := json.NewEncoder(w)
+	err := svc.Export(ctx, full, func(rec *Record) error {
+		var line interface{} = rec.Post
+		if full {
+			line = rec
+		}
+
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+		written++
+
+		return nil
+	})
+
+	return written, err
+}
+
+//ImportNDJSON reads posts or records of a full export from r, a JSON object per line, and imports them in batches
+//of MaxBatchSize. Blank lines are skipped. Posts that fail to be imported are listed in the report. A line that
+//isn't a post or is longer than MaxLineSize stops the import with ErrInvalid, posts of earlier lines are imported.
+func ImportNDJSON(ctx context.Context, svc Service, r io.Reader) (*ImportReport, error) {
+	var (
+		report  ImportReport
+		records = make([]*Record, 0, MaxBatchSize)
+		lines   = make([]int, 0, MaxBatchSize)
+	)
+
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+
+		results, err := svc.Import(ctx, records)
+		if err != nil {
+			return err
+		}
+
+		for i, res := range results {
+			if res.Err != nil {
+				report.Failed = append(report.Failed, ImportError{lines[i], records[i].ID, res.Err})
+			} else {
+				report.Imported++
+			}
+		}
+		records, lines = records[:0], lines[:0]
+
+		return nil
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), MaxLineSize)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := sc.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		rec, err := decodeLine(line)
+		if err != nil {
+			if err := flush(); err != nil {
+				return &report, err
+			}
+
+			return &report, Errorf(ErrInvalid, "line %v is not a valid post: %v", n, err)
+		}
+
+		records, lines = append(records, rec), append(lines, n)
+		if len(records) == MaxBatchSize {
+			if err := flush(); err != nil {
+				return &report, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return &report, err
+	}
+
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		return &report, Errorf(ErrInvalid, "line %v is longer than %v bytes", n+1, MaxLineSize)
+	}
+
+	return &report, sc.Err()
+}
+
+//decodeLine decodes a single JSON post or a record of a full export, which has a post or revisions field.
+//Unknown fields and trailing values aren't allowed.
+func decodeLine(line []byte) (*Record, error) {
+	var fields map[string]json.RawMessage
+	if err := decodeStrict(line, &fields); err != nil {
+		return nil, err
+	}
+
+	_, hasPost := fields["post"]
+	_, hasRevisions := fields["revisions"]
+	if hasPost || hasRevisions {
+		var rec Record
+		if err := decodeStrict(line, &rec); err != nil {
+			return nil, err
+		}
+
+		return &rec, nil
+	}
+
+	var post Post
+	if err := decodeStrict(line, &post); err != nil {
+		return nil, err
+	}
+
+	return &Record{ID: post.ID, Post: &post}, nil
+}
+
+//decodeStrict decodes a single JSON value to v, unknown fields and trailing values aren't allowed.
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	if dec.More() {
+		return Errorf(ErrInvalid, "more than one value in a line")
+	}
+
+	return nil
+}
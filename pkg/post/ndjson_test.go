@@ -0,0 +1,155 @@
+package post
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestImportNDJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		imported int
+		failed   []ImportError
+		err      error
+	}{
+		{
+			name:     "Success.",
+			input:    "{\"id\":3,\"name\":\"test 3\",\"author\":\"vt\",\"created_at\":\"1970-01-01T00:00:03Z\"}\n\n{\"id\":1,\"name\":\"test 1\",\"author\":\"vt\"}",
+			imported: 2,
+		},
+		{
+			name:     "Invalid and taken posts.",
+			input:    "{\"id\":3,\"name\":\"test 3\",\"author\":\"vt\"}\n{\"name\":\"test\",\"author\":\"vt\"}\n{\"id\":3,\"name\":\"again\",\"author\":\"vt\"}\n",
+			imported: 1,
+			failed:   []ImportError{{Line: 2, Err: ErrInvalid}, {Line: 3, ID: 3, Err: ErrConflict}},
+		},
+		{
+			name:     "Malformed line.",
+			input:    "{\"id\":3,\"name\":\"test 3\",\"author\":\"vt\"}\n{\"id\":4,\"name\":\"test 4\"\n{\"id\":5,\"name\":\"test 5\",\"author\":\"vt\"}\n",
+			imported: 1,
+			err:      ErrInvalid,
+		},
+		{
+			name:  "Two values in a line.",
+			input: "{\"id\":3,\"name\":\"test 3\",\"author\":\"vt\"} {\"id\":4,\"name\":\"test 4\",\"author\":\"vt\"}\n",
+			err:   ErrInvalid,
+		},
+		{
+			name:  "Unknown field.",
+			input: "{\"id\":3,\"name\":\"test 3\",\"author\":\"vt\",\"views\":1}\n",
+			err:   ErrInvalid,
+		},
+		{
+			name:     "Line too long.",
+			input:    "{\"id\":3,\"name\":\"test 3\",\"author\":\"vt\"}\n{\"id\":4,\"name\":\"" + strings.Repeat("a", MaxLineSize) + "\"}\n",
+			imported: 1,
+			err:      ErrInvalid,
+		},
+		{
+			name:     "Records of a full export.",
+			input:    "{\"id\":3,\"post\":{\"name\":\"test 3\",\"author\":\"vt\"}}\n{\"id\":4,\"revisions\":[{\"number\":1,\"action\":\"create\",\"editor\":\"vt\",\"post\":{\"name\":\"test 4\",\"author\":\"vt\"}}]}\n",
+			imported: 2,
+		},
+		{
+			name:   "Record without a post or revisions.",
+			input:  "{\"id\":3,\"revisions\":[]}\n{\"id\":4,\"post\":{\"id\":5,\"name\":\"test\",\"author\":\"vt\"}}\n",
+			failed: []ImportError{{Line: 1, ID: 3, Err: ErrInvalid}, {Line: 2, ID: 4, Err: ErrInvalid}},
+		},
+	}
+
+	for _, test := range tests {
+		svc := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{Clock: func() time.Time { return time.Unix(100, 0) }})
+
+		report, err := ImportNDJSON(context.Background(), svc, strings.NewReader(test.input))
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else {
+			assert.NoError(t, err, test.name)
+		}
+
+		if assert.NotNil(t, report, test.name) && assert.Len(t, report.Failed, len(test.failed), test.name) {
+			assert.Equal(t, test.imported, report.Imported, test.name)
+			for i, failed := range report.Failed {
+				assert.Equal(t, test.failed[i].Line, failed.Line, test.name)
+				assert.Equal(t, test.failed[i].ID, failed.ID, test.name)
+				assert.ErrorIs(t, failed.Err, test.failed[i].Err, test.name)
+			}
+		}
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{Clock: func() time.Time { return time.Unix(100, 0) }})
+
+	input := "{\"id\":3,\"name\":\"test 3\",\"author\":\"vt\",\"created_at\":\"1970-01-01T00:00:03Z\",\"updated_at\":\"1970-01-01T00:00:04Z\",\"tags\":[\"go\"]}\n" +
+		"{\"id\":1,\"name\":\"test 1\",\"author\":\"vt\",\"created_at\":\"1970-01-01T00:00:01Z\",\"updated_at\":\"1970-01-01T00:00:01Z\",\"body\":\"hi\"}\n"
+	if _, err := ImportNDJSON(ctx, svc, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := ExportNDJSON(ctx, svc, &buf, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, n)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if assert.Len(t, lines, 2) {
+			rec, err := decodeLine([]byte(lines[0]))
+			if assert.NoError(t, err) {
+				assert.Equal(t, int64(1), rec.ID)
+				assert.Equal(t, "hi", rec.Post.Body)
+				assert.True(t, rec.Post.CreatedAt.Equal(time.Unix(1, 0)))
+			}
+
+			rec, err = decodeLine([]byte(lines[1]))
+			if assert.NoError(t, err) {
+				assert.Equal(t, int64(3), rec.ID)
+				assert.Equal(t, []string{"go"}, rec.Post.Tags)
+				assert.True(t, rec.Post.UpdatedAt.Equal(time.Unix(4, 0)))
+			}
+		}
+	}
+
+	id, err := svc.Create(ctx, &Post{Name: "new", Author: "vt"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(4), id)
+	}
+
+	//A full export restores trashed posts and histories.
+	if _, err := svc.Remove(ctx, 3, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	n, err = ExportNDJSON(ctx, svc, &buf, true)
+	if !assert.NoError(t, err) || !assert.Equal(t, 3, n) {
+		return
+	}
+
+	restored := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{Clock: func() time.Time { return time.Unix(200, 0) }})
+	report, err := ImportNDJSON(ctx, restored, &buf)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, report.Imported)
+		assert.Empty(t, report.Failed)
+	}
+
+	for _, id := range []int64{1, 3, 4} {
+		expected, _ := svc.Revisions(ctx, id)
+		revisions, err := restored.Revisions(ctx, id)
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, revisions)
+		}
+	}
+
+	trashed, _, err := restored.FindTrashed(ctx, &TrashFilter{})
+	if assert.NoError(t, err) && assert.Len(t, trashed, 1) {
+		assert.Equal(t, int64(3), trashed[0].ID)
+	}
+}
@@ -3,13 +3,9 @@ package post
 import (
 	"context"
 	"errors"
-	"fmt"
-	"sort"
-	"strconv"
-	"strings"
+	"regexp"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 )
 
@@ -20,14 +16,66 @@ type Order int
 const (
 	Descending Order = iota
 	Ascending
+	//Relevance orders full-text search results by their score, newer posts go first on a tie.
+	Relevance
 )
 
+//Post body formats
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+//DefaultMaxBodySize is a post body size limit in bytes used when Options don't set one.
+const DefaultMaxBodySize = 64 << 10
+
 //Post is a response model
 type Post struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	Author    string    `json:"author"`
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Author string `json:"author"`
+	//CreatedAt and UpdatedAt are assigned by the service. Creation time never changes after a post is created.
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body      string    `json:"body,omitempty"`
+	//Format is either FormatPlain or FormatMarkdown. Empty format is plain.
+	Format string `json:"format,omitempty"`
+	//Tags are lower-case, sorted and unique. The service normalizes tags submitted by clients.
+	Tags []string `json:"tags,omitempty"`
+	//DeletedAt is set for posts in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	//Version is incremented on every write. It's exposed to clients as an ETag, not in the JSON body.
+	Version int64 `json:"-"`
+}
+
+//Record is a post with its history, the unit of exports and imports. Exports of live posts leave the history out.
+//The post of a trashed post has a deletion time and a purged post has none, only its history is kept.
+type Record struct {
+	ID        int64       `json:"id"`
+	Post      *Post       `json:"post,omitempty"`
+	Revisions []*Revision `json:"revisions,omitempty"`
+}
+
+//Revision actions
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionRemove  = "remove"
+	ActionRestore = "restore"
+	//ActionPurge is the last revision of a post, it's the state of the post when it was purged from the trash.
+	ActionPurge = "purge"
+)
+
+//Revision is a state of a post after a write. Revision numbers match post versions.
+type Revision struct {
+	Number int64  `json:"number"`
+	Action string `json:"action"`
+	//Editor is who made the write, the post author unless the context names someone else with WithEditor.
+	Editor    string    `json:"editor"`
+	CreatedAt time.Time `json:"created_at"`
+	//Changed lists JSON names of post fields changed by the write.
+	Changed []string `json:"changed,omitempty"`
+	Post    *Post    `json:"post"`
 }
 
 //SearchFilter groups search options
@@ -35,226 +83,380 @@ type SearchFilter struct {
 	Name   string
 	Author string
 	Order  Order
+	//Limit is a maximum number of posts in a page. Zero means no limit.
+	Limit int64
+	//Cursor is a position after which the page starts. It's returned by a previous FindMany call.
+	Cursor string
+	//CreatedAfter and CreatedBefore limit creation time to [CreatedAfter, CreatedBefore). Zero values are unbounded.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	//Query is a full-text search over post names. Posts that contain any of the query terms match.
+	Query string
+	//OmitBody leaves bodies of found posts empty, so they aren't read from the database.
+	OmitBody bool
+	//Tags limits the result to posts having all of the tags, or any of them if AnyTag is set.
+	Tags   []string
+	AnyTag bool
+	//Match is how Name and Author are compared, MatchExact by default.
+	Match Match
+}
+
+//CountFilter groups post count options
+type CountFilter struct {
+	//Author limits the result to a single author.
+	Author string
+	//Order sorts authors by their post count. Ties are broken by author name in the same direction.
+	Order Order
+	//Limit is a maximum number of authors. Zero means no limit.
+	Limit int64
+	//Match is a way Author is compared with authors of posts, MatchPrefix isn't supported. A case-insensitive count has
+	//a single author, the filter, with posts of every spelling of the author. Authors are listed by their exact spelling otherwise.
+	Match Match
+}
+
+//AuthorCount is a number of posts of an author
+type AuthorCount struct {
+	Author string
+	Count  int64
+}
+
+//TrashFilter groups trash listing options. Trashed posts are listed from the most recently removed one.
+type TrashFilter struct {
+	//Limit is a maximum number of posts in a page. Zero means no limit.
+	Limit int64
+	//Cursor is a position after which the page starts. It's returned by a previous FindTrashed call.
+	Cursor string
+}
+
+//TagFilter groups tag count options
+type TagFilter struct {
+	//Order sorts tags by their post count. Ties are broken by tag in the same direction.
+	Order Order
+	//Limit is a maximum number of tags. Zero means no limit.
+	Limit int64
+}
+
+//TagCount is a number of posts with a tag
+type TagCount struct {
+	Tag   string
+	Count int64
+}
+
+//AnyVersion disables the version precondition of write operations.
+const AnyVersion int64 = -1
+
+//Options configure a Service.
+type Options struct {
+	//MaxBodySize is a maximum post body size in bytes. Zero means DefaultMaxBodySize.
+	MaxBodySize int
+	//Clock returns the current time, it's time.Now if nil. The repository shares it if it stamps writes itself.
+	Clock func() time.Time
+	//AuthorPatterns restrict author names, an author must match at least one of them. Any author is allowed if it's empty.
+	AuthorPatterns []*regexp.Regexp
 }
 
 type postService struct {
-	db     *redis.Client
+	repo   Repository
 	logger *zap.SugaredLogger
+	opts   Options
 }
 
-//NewService creates and returns a new service with a database and a logger.
-func NewService(db *redis.Client, logger *zap.SugaredLogger) Service {
-	return postService{db, logger}
-}
+//NewService creates and returns a new service with a repository, a logger and options.
+func NewService(repo Repository, logger *zap.SugaredLogger, opts Options) Service {
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
 
-func (ps postService) Create(ctx context.Context, post *Post) (int64, error) {
-	id, err := ps.db.Incr(ctx, "next_post_id").Result()
-	if err != nil {
-		return 0, err
+	if opts.Clock == nil {
+		opts.Clock = time.Now
 	}
 
-	_, err = ps.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
-		unix := post.CreatedAt.Unix()
-		pipe.HSet(ctx, fmt.Sprintf("post:%v", id), "name", post.Name, "author", post.Author, "created_at", unix)
-		pipe.SAdd(ctx, fmt.Sprintf("names:%v", post.Name), id)
-		pipe.SAdd(ctx, fmt.Sprintf("authors:%v", post.Author), id)
+	if c, ok := repo.(clocked); ok {
+		repo = c.withClock(opts.Clock)
+	}
 
-		return nil
-	})
+	return postService{repo, logger, opts}
+}
 
+func (ps postService) Create(ctx context.Context, post *Post) (int64, error) {
+	post, err := ValidatePost(post, ps.opts)
 	if err != nil {
 		return 0, err
 	}
+	ps.stamp(ctx, post)
 
-	return id, nil
+	return ps.repo.Create(ctx, post)
 }
 
-func (ps postService) FindOne(ctx context.Context, id int64) (*Post, error) {
-	key := fmt.Sprintf("post:%v", id)
+func (ps postService) CreateMany(ctx context.Context, posts []*Post, atomic bool) ([]BatchResult, error) {
+	if err := checkBatch(len(posts)); err != nil {
+		return nil, err
+	}
+
+	//Only valid posts are passed to the repository, index maps them back to their results.
+	results := make([]BatchResult, len(posts))
+	valid := make([]*Post, 0, len(posts))
+	index := make([]int, 0, len(posts))
+	for i, post := range posts {
+		validated, err := ValidatePost(post, ps.opts)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		ps.stamp(ctx, validated)
+
+		valid = append(valid, validated)
+		index = append(index, i)
+	}
 
-	exists, err := ps.db.Exists(ctx, key).Result()
-	if exists == 0 {
-		return nil, errors.New("post not found")
+	if atomic && len(valid) != len(posts) {
+		failBatch(results, ErrBatchAborted)
+		return results, nil
 	}
 
-	res, err := ps.db.HGetAll(ctx, key).Result()
+	written, err := ps.repo.CreateMany(ctx, valid, atomic)
 	if err != nil {
 		return nil, err
 	}
 
-	return toPost(id, res)
+	for i, res := range written {
+		results[index[i]] = res
+	}
+
+	return results, nil
 }
 
-func (ps postService) FindMany(ctx context.Context, filter *SearchFilter) ([]*Post, error) {
-	var ids []int64
-	switch {
-	case filter.Author != "" && filter.Name != "":
-		err := ps.db.SInter(ctx, "authors:"+filter.Author, "names:"+filter.Name).ScanSlice(&ids)
-		if err != nil {
-			return nil, err
-		}
-	case filter.Author != "" && filter.Name == "":
-		err := ps.db.SMembers(ctx, "authors:"+filter.Author).ScanSlice(&ids)
-		if err != nil {
-			return nil, err
-		}
-	case filter.Author == "" && filter.Name != "":
-		err := ps.db.SMembers(ctx, "names:"+filter.Name).ScanSlice(&ids)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		var (
-			keys   []string
-			cursor uint64
-			err    error
-		)
-
-		for {
-			keys, cursor, err = ps.db.Scan(ctx, cursor, "post:*", 10).Result()
-			if err != nil {
-				return nil, err
-			}
-
-			for _, key := range keys {
-				strID := strings.TrimPrefix(key, "post:")
-
-				id, err := strconv.ParseInt(strID, 10, 64)
-				if err != nil {
-					return nil, err
-				}
-
-				ids = append(ids, id)
-			}
-
-			if cursor == 0 {
-				break
-			}
-		}
+func (ps postService) Import(ctx context.Context, records []*Record) ([]BatchResult, error) {
+	if err := checkBatch(len(records)); err != nil {
+		return nil, err
 	}
 
-	rawPosts := make(map[int64]*redis.StringStringMapCmd)
-	_, err := ps.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
-		for _, id := range ids {
-			rawPosts[id] = pipe.HGetAll(ctx, fmt.Sprintf("post:%v", id))
+	ctx = WithImport(ctx)
+	results := make([]BatchResult, len(records))
+	valid := make([]*Record, 0, len(records))
+	index := make([]int, 0, len(records))
+	for i, rec := range records {
+		validated, err := ps.validateImport(ctx, rec)
+		if err != nil {
+			results[i].Err = err
+			continue
 		}
 
-		return nil
-	})
+		valid = append(valid, validated)
+		index = append(index, i)
+	}
 
+	imported, err := ps.repo.Import(ctx, valid)
 	if err != nil {
 		return nil, err
 	}
 
-	posts := make([]*Post, 0, len(rawPosts))
-	for id, rp := range rawPosts {
-		m, err := rp.Result()
-		if err != nil {
-			return nil, err
+	for i, res := range imported {
+		results[index[i]] = res
+	}
+
+	return results, nil
+}
+
+//validateImport validates the post of an imported record like ValidatePost and stamps it like Create. A record must have
+//an ID, a post or a history, and its history must be numbered from 1.
+func (ps postService) validateImport(ctx context.Context, rec *Record) (*Record, error) {
+	var fields []FieldError
+	if rec.ID <= 0 {
+		fields = append(fields, FieldError{"id", "must be a positive integer"})
+	}
+
+	for i, rev := range rec.Revisions {
+		if rev.Number != int64(i+1) || rev.Post == nil {
+			fields = append(fields, FieldError{"revisions", "must be numbered from 1 and have posts"})
+			break
 		}
+	}
 
-		post, err := toPost(id, m)
-		if err != nil {
-			return nil, err
+	validated := &Record{ID: rec.ID, Revisions: rec.Revisions}
+	switch {
+	case rec.Post != nil:
+		if rec.Post.ID != 0 && rec.Post.ID != rec.ID {
+			fields = append(fields, FieldError{"post.id", "must match id"})
+		}
+
+		post, err := ValidatePost(rec.Post, ps.opts)
+		var valErr *ValidationError
+		if errors.As(err, &valErr) {
+			fields = append(fields, valErr.Fields...)
+		}
+
+		if post != nil {
+			post.ID = rec.ID
+			ps.stamp(ctx, post)
+			validated.Post = post
 		}
-		posts = append(posts, post)
+	case len(rec.Revisions) == 0:
+		fields = append(fields, FieldError{"post", "cannot be empty without revisions"})
 	}
 
-	switch filter.Order {
-	case Ascending:
-		sort.Slice(posts, func(i, j int) bool {
-			return posts[i].CreatedAt.Before(posts[j].CreatedAt)
-		})
-	case Descending:
-		sort.Slice(posts, func(i, j int) bool {
-			return posts[i].CreatedAt.After(posts[j].CreatedAt)
-		})
+	if len(fields) != 0 {
+		return nil, &ValidationError{fields}
 	}
 
-	return posts, nil
+	return validated, nil
 }
 
-func (ps postService) Remove(ctx context.Context, id int64) (bool, error) {
-	post, err := ps.FindOne(ctx, id)
-	if err != nil {
-		return false, err
-	}
+func (ps postService) Export(ctx context.Context, full bool, fn func(*Record) error) error {
+	return ps.repo.Export(ctx, full, fn)
+}
 
-	_, err = ps.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
-		pipe.Del(ctx, fmt.Sprintf("post:%v", id))
-		pipe.SRem(ctx, fmt.Sprintf("names:%v", post.Name), id)
-		pipe.SRem(ctx, fmt.Sprintf("authors:%v", post.Author), id)
+//stamp sets the creation and update time of a new post. Clients can't backdate posts, only imports keep their timestamps.
+func (ps postService) stamp(ctx context.Context, post *Post) {
+	if !importing(ctx) || post.CreatedAt.IsZero() {
+		now := ps.opts.Clock()
+		post.CreatedAt, post.UpdatedAt = now, now
+	}
+}
 
-		return nil
-	})
+func (ps postService) FindOne(ctx context.Context, id int64) (*Post, error) {
+	return ps.repo.FindOne(ctx, id)
+}
 
-	if err != nil {
-		return false, err
+func (ps postService) FindByIDs(ctx context.Context, ids []int64) ([]*Post, []int64, error) {
+	if err := checkBatch(len(ids)); err != nil {
+		return nil, nil, err
 	}
 
-	return true, nil
+	return ps.repo.FindByIDs(ctx, ids)
 }
 
-func (ps postService) Count(ctx context.Context) (map[string]int, error) {
-	var (
-		authors []string
-		cursor  uint64
-		err     error
-	)
-
-	for {
-		var keys []string
-		keys, cursor, err = ps.db.Scan(ctx, cursor, "authors:*", 10).Result()
+func (ps postService) FindMany(ctx context.Context, filter *SearchFilter) ([]*Post, string, error) {
+	copied := *filter
+	copied.Name, copied.Author = normalizeFilter(filter.Name), normalizeFilter(filter.Author)
+	if len(filter.Tags) != 0 {
+		tags, err := normalizeTags(filter.Tags)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
+		copied.Tags = tags
+	}
 
-		for _, k := range keys {
-			authors = append(authors, strings.TrimPrefix(k, "authors:"))
-		}
+	return ps.repo.FindMany(ctx, &copied)
+}
 
-		if cursor == 0 {
-			break
-		}
+func (ps postService) Update(ctx context.Context, id int64, post *Post, version int64) (*Post, error) {
+	post, err := ValidatePost(post, ps.opts)
+	if err != nil {
+		return nil, err
 	}
 
-	authorRes := make(map[string]*redis.StringSliceCmd)
-	_, err = ps.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
-		for _, author := range authors {
-			authorRes[author] = pipe.SMembers(ctx, fmt.Sprintf("authors:%v", author))
-		}
+	return ps.repo.Update(ctx, id, post, version)
+}
 
-		return nil
-	})
+func (ps postService) Remove(ctx context.Context, id int64, version int64) (bool, error) {
+	return ps.repo.Remove(ctx, id, version)
+}
 
-	postsCount := make(map[string]int)
-	for author, res := range authorRes {
-		slice, err := res.Result()
-		if err != nil {
-			ps.logger.Errorf("Error while counting: %v", err)
-			continue
+func (ps postService) RemoveMany(ctx context.Context, ids []int64, atomic bool) ([]BatchResult, error) {
+	if err := checkBatch(len(ids)); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return nil, Errorf(ErrInvalid, "post %v is listed more than once", id)
 		}
+		seen[id] = true
+	}
+
+	return ps.repo.RemoveMany(ctx, ids, atomic)
+}
+
+func (ps postService) Restore(ctx context.Context, id int64) (*Post, error) {
+	return ps.repo.Restore(ctx, id)
+}
 
-		postsCount[author] = len(slice)
+func (ps postService) FindTrashed(ctx context.Context, filter *TrashFilter) ([]*Post, string, error) {
+	return ps.repo.FindTrashed(ctx, filter)
+}
+
+func (ps postService) Purge(ctx context.Context, before time.Time) (int64, error) {
+	return ps.repo.Purge(ctx, before)
+}
+
+func (ps postService) Revisions(ctx context.Context, id int64) ([]*Revision, error) {
+	return ps.repo.Revisions(ctx, id)
+}
+
+func (ps postService) Revision(ctx context.Context, id int64, n int64) (*Revision, error) {
+	return ps.repo.Revision(ctx, id, n)
+}
+
+func (ps postService) Revert(ctx context.Context, id int64, n int64, version int64) (*Post, error) {
+	rev, err := ps.repo.Revision(ctx, id, n)
+	if err != nil {
+		return nil, err
 	}
 
-	return postsCount, nil
+	reverted := copyPost(rev.Post)
+	reverted.DeletedAt = nil
+	return ps.Update(ctx, id, reverted, version)
+}
+
+func (ps postService) Count(ctx context.Context, filter *CountFilter) ([]AuthorCount, int64, error) {
+	copied := *filter
+	copied.Author = normalizeFilter(filter.Author)
+
+	return ps.repo.Count(ctx, &copied)
+}
+
+func (ps postService) Tags(ctx context.Context, filter *TagFilter) ([]TagCount, error) {
+	return ps.repo.Tags(ctx, filter)
 }
 
 func (ps postService) Logger() *zap.SugaredLogger {
 	return ps.logger
 }
 
-func toPost(id int64, res map[string]string) (*Post, error) {
-	unix, err := strconv.ParseInt(res["created_at"], 0, 64)
-	if err != nil {
-		return nil, err
+func (ps postService) Now() time.Time {
+	return ps.opts.Clock()
+}
+
+//checkCountFilter reports count filters that can't be applied.
+func checkCountFilter(filter *CountFilter) error {
+	if filter.Order == Relevance {
+		return Errorf(ErrInvalid, "authors can only be ordered by post count")
+	}
+
+	if filter.Match == MatchPrefix {
+		return Errorf(ErrInvalid, "authors can't be counted by prefix")
+	}
+
+	return nil
+}
+
+//checkTagFilter reports tag filters that can't be applied.
+func checkTagFilter(filter *TagFilter) error {
+	if filter.Order == Relevance {
+		return Errorf(ErrInvalid, "tags can only be ordered by post count")
 	}
 
-	return &Post{
-		ID:        id,
-		Name:      res["name"],
-		Author:    res["author"],
-		CreatedAt: time.Unix(unix, 0),
-	}, nil
+	return nil
+}
+
+//notFound returns an ErrNotFound error for a post ID.
+func notFound(id int64) error {
+	return Errorf(ErrNotFound, "post %v was not found", id)
+}
+
+//notTrashed returns an ErrConflict error for a post that can't be restored because it isn't in the trash.
+func notTrashed(id int64) error {
+	return Errorf(ErrConflict, "post %v isn't in the trash", id)
+}
+
+//purgedID returns an ErrConflict error for an imported post whose ID belonged to a purged post. Its history is kept, so the ID isn't reused.
+func purgedID(id int64) error {
+	return Errorf(ErrConflict, "post %v was purged, its ID can't be reused", id)
+}
+
+//revisionNotFound returns an ErrNotFound error for a revision number of a post.
+func revisionNotFound(id, n int64) error {
+	return Errorf(ErrNotFound, "revision %v of post %v was not found", n, id)
 }
@@ -2,421 +2,349 @@ package post
 
 import (
 	"context"
-	"errors"
-	"strconv"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/go-redis/redismock/v8"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 )
 
 func TestPostServiceLogger(t *testing.T) {
 	logger := zap.NewExample().Sugar()
-	ps := postService{nil, logger}
+	ps := postService{nil, logger, Options{}}
 
 	assert.Equal(t, ps.Logger(), logger)
 }
 
-func TestToPost(t *testing.T) {
-	tests := []map[string]string{
-		{
-			"name":       "test 1",
-			"author":     "vt",
-			"created_at": "12345678",
-		},
-		{
-			"name":       "test 2",
-			"author":     "robot",
-			"created_at": "0.1",
-		},
+func TestPostService(t *testing.T) {
+	ctx := context.Background()
+	ps := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{Clock: func() time.Time { return time.Unix(1, 0) }})
+
+	id, err := ps.Create(ctx, &Post{Name: "test", Author: "vt"})
+	if !assert.NoError(t, err) {
+		return
 	}
 
-	post, err := toPost(1, tests[0])
+	post, err := ps.FindOne(ctx, id)
 	if assert.NoError(t, err) {
-		unix, _ := strconv.ParseInt(tests[0]["created_at"], 10, 64)
-		ts := time.Unix(unix, 0)
+		assert.Equal(t, &Post{ID: id, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Version: 1}, post)
+	}
 
-		assert.Equal(t, post.Author, tests[0]["author"])
-		assert.Equal(t, post.Name, tests[0]["name"])
-		assert.Equal(t, post.CreatedAt, ts)
+	updated, err := ps.Update(ctx, id, &Post{Name: "test 2", Author: "vt"}, 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(2), updated.Version)
 	}
 
-	post, err = toPost(2, tests[1])
-	if assert.Error(t, err) {
-		var numErr *strconv.NumError
-		assert.ErrorAs(t, err, &numErr)
+	posts, next, err := ps.FindMany(ctx, &SearchFilter{Author: "vt"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*Post{updated}, posts)
+		assert.Empty(t, next)
 	}
-}
 
-func TestCount(t *testing.T) {
-	client, mock := redismock.NewClientMock()
-	logger := zap.NewExample().Sugar()
-	ps := NewService(client, logger)
+	count, total, err := ps.Count(ctx, &CountFilter{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []AuthorCount{{Author: "vt", Count: 1}}, count)
+		assert.Equal(t, int64(1), total)
+	}
 
-	mock.ExpectScan(0, "authors:*", 10).SetVal([]string{"vt", "robot"}, 0)
-	mock.ExpectSMembers("authors:vt").SetVal([]string{"1", "2"})
-	mock.ExpectSMembers("authors:robot").SetVal([]string{"3", "4", "5"})
+	//Filters are normalized like stored authors.
+	posts, _, err = ps.FindMany(ctx, &SearchFilter{Author: " vt "})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*Post{updated}, posts)
+	}
 
-	count, err := ps.Count(context.Background())
+	count, total, err = ps.Count(ctx, &CountFilter{Author: " VT ", Match: MatchCaseInsensitive})
 	if assert.NoError(t, err) {
-		assert.Equal(t, 2, count["vt"])
-		assert.Equal(t, 3, count["robot"])
+		assert.Equal(t, []AuthorCount{{Author: "VT", Count: 1}}, count)
+		assert.Equal(t, int64(1), total)
 	}
+
+	ok, err := ps.Remove(ctx, id, AnyVersion)
+	assert.True(t, ok)
+	assert.NoError(t, err)
 }
 
-func TestCreate(t *testing.T) {
-	client, mock := redismock.NewClientMock()
-	logger := zap.NewExample().Sugar()
-	ps := NewService(client, logger)
+func TestPostServiceBody(t *testing.T) {
+	ctx := context.Background()
+	ps := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{MaxBodySize: 4})
 
 	tests := []struct {
-		expected int64
-		err      bool
-		post     *Post
-		mock     func()
+		name string
+		post *Post
+		err  error
 	}{
 		{
-			expected: 1,
-			err:      false,
-			post:     &Post{Name: "t", Author: "t", CreatedAt: time.Unix(1, 0)},
-			mock: func() {
-				mock.ExpectIncr("next_post_id").SetVal(1)
-				mock.ExpectHSet("post:1", "name", "t", "author", "t", "created_at", int64(1)).SetVal(1)
-				mock.ExpectSAdd("names:t", int64(1)).SetVal(1)
-				mock.ExpectSAdd("authors:t", int64(1)).SetVal(1)
-			},
+			name: "Plain body.",
+			post: &Post{Name: "test", Author: "vt", Body: "text"},
+		},
+		{
+			name: "Markdown body.",
+			post: &Post{Name: "test", Author: "vt", Body: "*hi*", Format: FormatMarkdown},
 		},
 		{
-			expected: 0,
-			err:      true,
-			post:     &Post{Name: "t", Author: "t", CreatedAt: time.Unix(1, 0)},
-			mock: func() {
-				mock.ExpectIncr("next_post_id").SetVal(1)
-				mock.ExpectHSet("post:1", "name", "t", "author", "t", "created_at", int64(1)).SetVal(1)
-				mock.ExpectSAdd("names:t", int64(1)).SetErr(errors.New("fail"))
-				mock.ExpectSAdd("authors:t", int64(1)).SetVal(1)
-			},
+			name: "Body is too large.",
+			post: &Post{Name: "test", Author: "vt", Body: "texts"},
+			err:  ErrInvalid,
+		},
+		{
+			name: "Unknown format.",
+			post: &Post{Name: "test", Author: "vt", Format: "html"},
+			err:  ErrInvalid,
 		},
 	}
 
 	for _, test := range tests {
-		test.mock()
+		id, err := ps.Create(ctx, test.post)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
 
-		id, err := ps.Create(context.Background(), test.post)
-		if test.err {
-			assert.Error(t, err)
-		} else {
-			if assert.NoError(t, err) {
-				assert.Equal(t, test.expected, id)
-			}
+			_, err = ps.Update(ctx, 1, test.post, AnyVersion)
+			assert.ErrorIs(t, err, test.err, test.name)
+			continue
 		}
 
-		mock.ClearExpect()
+		if assert.NoError(t, err, test.name) {
+			post, _ := ps.FindOne(ctx, id)
+			assert.Equal(t, test.post.Body, post.Body, test.name)
+			assert.Equal(t, test.post.Format, post.Format, test.name)
+		}
 	}
 }
 
-func TestFindOne(t *testing.T) {
-	client, mock := redismock.NewClientMock()
-	logger := zap.NewExample().Sugar()
-	ps := NewService(client, logger)
+func TestPostServiceTags(t *testing.T) {
+	ctx := context.Background()
+	ps := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{})
 
 	tests := []struct {
-		expected *Post
-		id       int64
-		err      bool
-		mock     func()
+		name     string
+		tags     []string
+		expected []string
+		err      error
 	}{
 		{
-			expected: &Post{
-				ID:        1,
-				Name:      "test 1",
-				Author:    "vt",
-				CreatedAt: time.Unix(1, 0),
-			},
-			err: false,
-			id:  1,
-			mock: func() {
-				mock.ExpectExists("post:1").SetVal(1)
-				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
-					"name":       "test 1",
-					"author":     "vt",
-					"created_at": "1",
-				})
-			},
+			name:     "Normalized tags.",
+			tags:     []string{" Redis", "go", "GO"},
+			expected: []string{"go", "redis"},
+		},
+		{
+			name: "No tags.",
+			tags: []string{},
 		},
 		{
-			expected: nil,
-			err:      true,
-			id:       2,
-			mock: func() {
-				mock.ExpectExists("post:2").SetVal(0)
-			},
+			name: "Empty tag.",
+			tags: []string{"go", " "},
+			err:  ErrInvalid,
 		},
 		{
-			expected: nil,
-			err:      true,
-			id:       3,
-			mock: func() {
-				mock.ExpectExists("post:3").SetVal(1)
-				mock.ExpectHGetAll("post:3").SetErr(errors.New("fail"))
-			},
+			name: "Tag with a comma.",
+			tags: []string{"go,redis"},
+			err:  ErrInvalid,
 		},
 	}
 
 	for _, test := range tests {
-		test.mock()
+		post := &Post{Name: "test", Author: "vt", Tags: test.tags}
+		id, err := ps.Create(ctx, post)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+
+			_, _, err = ps.FindMany(ctx, &SearchFilter{Tags: test.tags})
+			assert.ErrorIs(t, err, test.err, test.name)
+			continue
+		}
 
-		post, err := ps.FindOne(context.Background(), test.id)
-		if test.err {
-			assert.Error(t, err)
-		} else {
-			if assert.NoError(t, err) {
-				assert.Equal(t, test.expected, post)
-			}
+		if assert.NoError(t, err, test.name) {
+			found, _ := ps.FindOne(ctx, id)
+			assert.Equal(t, test.expected, found.Tags, test.name)
 		}
+	}
 
-		mock.ClearExpect()
+	posts, _, err := ps.FindMany(ctx, &SearchFilter{Tags: []string{"REDIS "}})
+	if assert.NoError(t, err) {
+		assert.Len(t, posts, 1)
 	}
 }
 
-func TestRemove(t *testing.T) {
-	client, mock := redismock.NewClientMock()
-	logger := zap.NewExample().Sugar()
-	ps := NewService(client, logger)
+func TestRunPurger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var now time.Time
+	setNow := func(t time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = t
+	}
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	ps := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{Clock: clock})
+
+	//By the service clock post 1 was trashed long ago and post 2 has just been trashed, by the wall clock both were.
+	for _, deletedAt := range []time.Time{time.Unix(1000, 0), time.Unix(4000, 0)} {
+		setNow(deletedAt)
+		id, err := ps.Create(ctx, &Post{Name: "test", Author: "vt"})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		_, err = ps.Remove(ctx, id, AnyVersion)
+		assert.NoError(t, err)
+	}
+	setNow(time.Unix(5000, 0))
+
+	go RunPurger(ctx, ps, time.Hour, time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		posts, _, err := ps.FindTrashed(ctx, &TrashFilter{})
+		return err == nil && len(posts) == 1 && posts[0].ID == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestPostServiceRevert(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1, 0)
+	ps := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{Clock: func() time.Time { return now }})
+
+	id, err := ps.Create(ctx, &Post{Name: "test", Author: "vt", Tags: []string{"go"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	now = time.Unix(2, 0)
+	if _, err := ps.Update(ctx, id, &Post{Name: "test 2", Author: "vt"}, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	now = time.Unix(3, 0)
+	_, err = ps.Revert(ctx, id, 1, 1)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	reverted, err := ps.Revert(WithEditor(ctx, "admin"), id, 1, 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Post{ID: id, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(3, 0), Tags: []string{"go"}, Version: 3}, reverted)
+	}
+
+	rev, err := ps.Revision(ctx, id, 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ActionUpdate, rev.Action)
+		assert.Equal(t, "admin", rev.Editor)
+		assert.Equal(t, []string{"name", "tags"}, rev.Changed)
+	}
+
+	_, err = ps.Revert(ctx, id, 4, AnyVersion)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestPostServiceClock(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(10, 250000000)
+	ps := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{Clock: func() time.Time { return now }})
 
 	tests := []struct {
-		expected bool
-		id       int64
-		err      bool
-		mock     func()
+		name      string
+		ctx       context.Context
+		post      *Post
+		createdAt time.Time
+		updatedAt time.Time
 	}{
-		{
-			expected: true,
-			err:      false,
-			id:       1,
-			mock: func() {
-				mock.ExpectExists("post:1").SetVal(1)
-				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
-					"name":       "test 1",
-					"author":     "vt",
-					"created_at": "1",
-				})
-
-				mock.ExpectDel("post:1").SetVal(1)
-				mock.ExpectSRem("names:test 1", int64(1)).SetVal(1)
-				mock.ExpectSRem("authors:vt", int64(1)).SetVal(1)
-			},
-		},
-		{
-			expected: false,
-			err:      true,
-			id:       1,
-			mock: func() {
-				mock.ExpectExists("post:1").SetVal(1)
-				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
-					"name":       "test 1",
-					"author":     "vt",
-					"created_at": "1",
-				})
-
-				mock.ExpectDel("post:1").SetErr(errors.New("fail"))
-			},
-		},
-		{
-			expected: false,
-			err:      true,
-			id:       2,
-			mock: func() {
-				mock.ExpectExists("post:2").SetVal(0)
-			},
-		},
+		{"Client time is ignored.", ctx, &Post{CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(2, 0)}, now, now},
+		{"Import keeps both times.", WithImport(ctx), &Post{CreatedAt: time.Unix(1, 5000), UpdatedAt: time.Unix(2, 0)}, time.Unix(1, 5000), time.Unix(2, 0)},
+		{"Import without update time.", WithImport(ctx), &Post{CreatedAt: time.Unix(1, 0)}, time.Unix(1, 0), time.Unix(1, 0)},
+		{"Import without creation time.", WithImport(ctx), &Post{}, now, now},
 	}
 
 	for _, test := range tests {
-		test.mock()
+		t.Run(test.name, func(t *testing.T) {
+			test.post.Name, test.post.Author = "test", "vt"
+			id, err := ps.Create(test.ctx, test.post)
+			if !assert.NoError(t, err) {
+				return
+			}
 
-		removed, err := ps.Remove(context.Background(), test.id)
-		if test.err {
-			assert.Error(t, err)
-		} else {
+			post, err := ps.FindOne(ctx, id)
 			if assert.NoError(t, err) {
-				assert.Equal(t, test.expected, removed)
+				assert.Equal(t, test.createdAt, post.CreatedAt)
+				assert.Equal(t, test.updatedAt, post.UpdatedAt)
 			}
-		}
+		})
+	}
 
-		mock.ClearExpect()
+	id, err := ps.Create(ctx, &Post{Name: "test", Author: "vt"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	now = time.Unix(20, 0)
+	updated, err := ps.Update(ctx, id, &Post{Name: "test", Author: "vt", CreatedAt: time.Unix(30, 0)}, AnyVersion)
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Unix(10, 250000000), updated.CreatedAt)
+		assert.Equal(t, time.Unix(20, 0), updated.UpdatedAt)
 	}
 }
 
-func TestFindMany(t *testing.T) {
-	client, mock := redismock.NewClientMock()
-	logger := zap.NewExample().Sugar()
-	ps := NewService(client, logger)
+func TestPostServiceBatch(t *testing.T) {
+	ctx := context.Background()
+	ps := NewService(NewMemoryRepository(), zap.NewExample().Sugar(), Options{Clock: func() time.Time { return time.Unix(1, 0) }})
 
-	tests := []struct {
-		name     string
-		expected []*Post
-		filters  *SearchFilter
-		err      bool
-		mock     func()
-	}{
-		{
-			name: "Filter by names. Success.",
-			expected: []*Post{
-				{1, "found", "vt", time.Unix(2, 0)},
-				{2, "found", "vt", time.Unix(1, 0)},
-			},
-			err:     false,
-			filters: &SearchFilter{Name: "found"},
-			mock: func() {
-				mock.ExpectSMembers("names:found").SetVal([]string{"1", "2"})
-				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
-					"name":       "found",
-					"author":     "vt",
-					"created_at": "2",
-				})
-				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
-					"name":       "found",
-					"author":     "vt",
-					"created_at": "1",
-				})
-			},
-		},
-		{
-			name: "Filter by authors. Success.",
-			expected: []*Post{
-				{2, "test1", "vt", time.Unix(2, 0)},
-				{3, "test2", "vt", time.Unix(1, 0)},
-			},
-			err:     false,
-			filters: &SearchFilter{Author: "vt"},
-			mock: func() {
-				mock.ExpectSMembers("authors:vt").SetVal([]string{"2", "3"})
-				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
-					"name":       "test1",
-					"author":     "vt",
-					"created_at": "2",
-				})
-				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
-					"name":       "test2",
-					"author":     "vt",
-					"created_at": "1",
-				})
-			},
-		},
-		{
-			name: "Filter by both. Success",
-			expected: []*Post{
-				{3, "found", "vt", time.Unix(2, 0)},
-				{4, "found", "vt", time.Unix(1, 0)},
-			},
-			err:     false,
-			filters: &SearchFilter{Name: "found", Author: "vt"},
-			mock: func() {
-				mock.ExpectSInter("authors:vt", "names:found").SetVal([]string{"3", "4"})
-				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
-					"name":       "found",
-					"author":     "vt",
-					"created_at": "2",
-				})
-				mock.ExpectHGetAll("post:4").SetVal(map[string]string{
-					"name":       "found",
-					"author":     "vt",
-					"created_at": "1",
-				})
-			},
-		},
-		{
-			name: "No filters. Success.",
-			expected: []*Post{
-				{1, "test1", "vt", time.Unix(4, 0)},
-				{2, "test2", "vt", time.Unix(3, 0)},
-				{3, "found", "vt", time.Unix(2, 0)},
-				{4, "found", "vt", time.Unix(1, 0)},
-			},
-			err:     false,
-			filters: &SearchFilter{},
-			mock: func() {
-				mock.ExpectScan(0, "post:*", 10).SetVal([]string{"post:1", "post:2"}, 30)
-				mock.ExpectScan(30, "post:*", 10).SetVal([]string{"post:3", "post:4"}, 0)
-
-				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
-					"name":       "test1",
-					"author":     "vt",
-					"created_at": "4",
-				})
-				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
-					"name":       "test2",
-					"author":     "vt",
-					"created_at": "3",
-				})
-				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
-					"name":       "found",
-					"author":     "vt",
-					"created_at": "2",
-				})
-				mock.ExpectHGetAll("post:4").SetVal(map[string]string{
-					"name":       "found",
-					"author":     "vt",
-					"created_at": "1",
-				})
-			},
-		},
-		{
-			name: "Filter by names. Ascending order.",
-			expected: []*Post{
-				{1, "found", "vt", time.Unix(1, 0)},
-				{2, "found", "vt", time.Unix(2, 0)},
-			},
-			err:     false,
-			filters: &SearchFilter{Name: "found", Order: Ascending},
-			mock: func() {
-				mock.ExpectSMembers("names:found").SetVal([]string{"1", "2"})
-				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
-					"name":       "found",
-					"author":     "vt",
-					"created_at": "1",
-				})
-				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
-					"name":       "found",
-					"author":     "vt",
-					"created_at": "2",
-				})
-			},
-		},
-		{
-			name:     "No filters. Bad ID.",
-			expected: nil,
-			err:      true,
-			filters:  &SearchFilter{},
-			mock: func() {
-				mock.ExpectScan(0, "post:*", 10).SetVal([]string{"post:1", "post:2", "post:3", "post:bad"}, 0)
-			},
-		},
-		/*{
-			expected: []*Post{},
-			err:      false,
-			filters:  &SearchFilter{},
-			mock: func() {
-
-			},
-		},*/
+	posts := []*Post{{Name: "test 1", Author: "vt"}, {Name: "", Author: "vt"}, {Name: "test 3", Author: "vt"}}
+	results, err := ps.CreateMany(ctx, posts, true)
+	if assert.NoError(t, err) && assert.Len(t, results, 3) {
+		assert.ErrorIs(t, results[0].Err, ErrBatchAborted)
+		assert.ErrorIs(t, results[1].Err, ErrInvalid)
+		assert.ErrorIs(t, results[2].Err, ErrBatchAborted)
 	}
 
-	for _, test := range tests {
-		test.mock()
-
-		id, err := ps.FindMany(context.Background(), test.filters)
-		if test.err {
-			assert.Error(t, err, test.name)
-		} else {
-			if assert.NoError(t, err, test.name) {
-				assert.Equal(t, test.expected, id, test.name)
-			}
-		}
+	results, err = ps.CreateMany(ctx, posts, false)
+	if assert.NoError(t, err) && assert.Len(t, results, 3) {
+		assert.Equal(t, BatchResult{ID: 1}, results[0])
+		assert.ErrorIs(t, results[1].Err, ErrInvalid)
+		assert.Equal(t, BatchResult{ID: 2}, results[2])
+	}
+
+	post, err := ps.FindOne(ctx, 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Post{ID: 2, Name: "test 3", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Version: 1}, post)
+	}
 
-		mock.ClearExpect()
+	_, err = ps.CreateMany(ctx, make([]*Post, MaxBatchSize+1), false)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = ps.RemoveMany(ctx, []int64{1, 2, 1}, false)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	found, missing, err := ps.FindByIDs(ctx, []int64{2, 3})
+	if assert.NoError(t, err) && assert.Len(t, found, 1) {
+		assert.Equal(t, int64(2), found[0].ID)
+		assert.Equal(t, []int64{3}, missing)
+	}
+
+	_, _, err = ps.FindByIDs(ctx, make([]int64, MaxBatchSize+1))
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	results, err = ps.RemoveMany(ctx, []int64{1, 2}, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []BatchResult{{ID: 1}, {ID: 2}}, results)
+	}
+}
+
+func TestMemoryRepositoryWithClock(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	clocked := repo.(clocked).withClock(func() time.Time { return time.Unix(1, 0) })
+
+	id, err := clocked.Create(ctx, &Post{Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0)})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	//The copy shares posts with repo, but repo keeps its own clock.
+	updated, err := repo.Update(ctx, id, &Post{Name: "updated", Author: "vt"}, AnyVersion)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, time.Unix(1, 0), updated.UpdatedAt)
 	}
 }
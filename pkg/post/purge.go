@@ -0,0 +1,33 @@
+package post
+
+import (
+	"context"
+	"time"
+)
+
+//DefaultTrashRetention is how long trashed posts are kept before they're purged.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+//RunPurger permanently removes posts that have been in the trash longer than retention every interval until ctx is done.
+//Retention is measured with the service clock.
+func RunPurger(ctx context.Context, svc Service, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := svc.Purge(ctx, svc.Now().Add(-retention))
+			if err != nil {
+				svc.Logger().Errorf("Failed to purge the trash: %v", err)
+				continue
+			}
+
+			if purged != 0 {
+				svc.Logger().Infof("Purged %v posts from the trash", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,1817 @@
+package post
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//maxTxRetries limits how many times an optimistic transaction is retried when a watched key changes.
+const maxTxRetries = 5
+
+//redisRepository stores posts in Redis hashes and keeps name, author, time, term and tag indexes next to them.
+//Trashed posts keep their hashes with a deletion time, they're only indexed in the trash sorted set.
+//Every written state of a post is appended to its revisions list.
+type redisRepository struct {
+	db  *redis.Client
+	now func() time.Time
+}
+
+//NewRedisRepository creates a repository backed by a Redis database.
+func NewRedisRepository(db *redis.Client) Repository {
+	return redisRepository{db, time.Now}
+}
+
+func (rr redisRepository) withClock(now func() time.Time) Repository {
+	return redisRepository{rr.db, now}
+}
+
+//Create reserves an ID before the post is written, so the script gets every key it writes. The ID is skipped if the write fails.
+func (rr redisRepository) Create(ctx context.Context, post *Post) (int64, error) {
+	id, err := rr.db.Incr(ctx, "next_post_id").Result()
+	if err != nil {
+		return 0, storageError(err)
+	}
+
+	keys, args, err := rr.createArgs(ctx, &Record{ID: id, Post: post})
+	if err != nil {
+		return 0, err
+	}
+
+	id, err = createScript.Run(ctx, rr.db, keys, args...).Int64()
+	if err != nil {
+		return 0, storageError(err)
+	}
+
+	return id, nil
+}
+
+//CreateMany reserves IDs of all posts with a single INCRBY and writes every chunk of posts in a pipeline.
+//IDs of posts that fail to be written are skipped. An atomic batch is written in a single MULTI/EXEC transaction,
+//so other clients never see a part of it.
+func (rr redisRepository) CreateMany(ctx context.Context, posts []*Post, atomic bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(posts))
+	if len(posts) == 0 {
+		return results, nil
+	}
+
+	last, err := rr.db.IncrBy(ctx, "next_post_id", int64(len(posts))).Result()
+	if err != nil {
+		return nil, storageError(err)
+	}
+	records := make([]*Record, len(posts))
+	for i, post := range posts {
+		records[i] = &Record{ID: last - int64(len(posts)-i) + 1, Post: post}
+	}
+
+	return rr.createMany(ctx, records, atomic)
+}
+
+//Import writes records with their IDs like CreateMany writes posts with reserved IDs. Trashed and purged posts
+//are written by importRemovedScript, they aren't indexed.
+func (rr redisRepository) Import(ctx context.Context, records []*Record) ([]BatchResult, error) {
+	return rr.createMany(ctx, records, false)
+}
+
+//createMany writes records with IDs in pipelined chunks or in a single transaction if the batch is atomic.
+func (rr redisRepository) createMany(ctx context.Context, records []*Record, atomic bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(records))
+	if len(records) == 0 {
+		return results, nil
+	}
+
+	var err error
+	scripts := make([]*redis.Script, len(records))
+	keys := make([][]string, len(records))
+	args := make([][]interface{}, len(records))
+	for i, rec := range records {
+		scripts[i] = createScript
+		if rec.Post == nil || rec.Post.DeletedAt != nil {
+			scripts[i] = importRemovedScript
+			keys[i], args[i], err = rr.importRemovedArgs(ctx, rec)
+		} else {
+			keys[i], args[i], err = rr.createArgs(ctx, rec)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	loaded := make(map[*redis.Script]bool)
+	for _, script := range scripts {
+		if loaded[script] {
+			continue
+		}
+
+		if err := loadScript(ctx, rr.db, script); err != nil {
+			return nil, storageError(err)
+		}
+		loaded[script] = true
+	}
+
+	pipelined := rr.db.Pipelined
+	if atomic {
+		pipelined = rr.db.TxPipelined
+	}
+
+	forChunks(len(records), atomic, func(start, end int) {
+		cmds := make([]*redis.Cmd, 0, end-start)
+		_, err := pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := start; i < end; i++ {
+				cmds = append(cmds, scripts[i].EvalSha(ctx, pipe, keys[i], args[i]...))
+			}
+
+			return nil
+		})
+
+		//EXEC doesn't roll back scripts that ran if another one fails at runtime. The scripts check everything before
+		//they write, so only Redis failing a write, like running out of memory, leaves a part of an atomic batch written.
+		//The whole batch is failed then, as the client can't tell which posts were written.
+		if pipelineFailed(err, atomic) {
+			failBatch(results[start:end], storageError(err))
+			return
+		}
+
+		for i, cmd := range cmds {
+			res := &results[start+i]
+			if id, err := cmd.Int64(); err != nil {
+				res.Err = storageError(err)
+			} else if id == 0 {
+				res.Err = Errorf(ErrConflict, "post %v already exists", records[start+i].ID)
+			} else if id == -1 {
+				res.Err = purgedID(records[start+i].ID)
+			} else {
+				res.ID = id
+			}
+		}
+	})
+
+	return results, nil
+}
+
+//createArgs returns keys and arguments of createScript writing the post of a record with its history. The record ID
+//is reserved beforehand.
+func (rr redisRepository) createArgs(ctx context.Context, rec *Record) ([]string, []interface{}, error) {
+	post := rec.Post
+	keys := []string{
+		"next_post_id",
+		fmt.Sprintf("post:%v", rec.ID),
+		fmt.Sprintf("revisions:%v", rec.ID),
+		fmt.Sprintf("names:%v", post.Name),
+		fmt.Sprintf("authors:%v", post.Author),
+		"author_counts",
+		"tag_counts",
+		"timeline",
+		fmt.Sprintf("timeline:names:%v", post.Name),
+		fmt.Sprintf("timeline:authors:%v", post.Author),
+		fmt.Sprintf("ci:names:%v", matchKey(post.Name)),
+		fmt.Sprintf("ci:authors:%v", matchKey(post.Author)),
+		"lex:names",
+		"lex:authors",
+	}
+	history, err := encodeHistory(importedHistory(ctx, rec, rr.now()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	createdAt, updatedAt := storedTime(post.CreatedAt), storedTime(updateTime(post))
+	terms := tokenize(post.Name)
+	args := []interface{}{
+		rec.ID, post.Name, post.Author, formatUnix(createdAt), formatUnix(updatedAt), post.Body, post.Format, joinTags(post.Tags), history,
+		timeScore(createdAt), matchKey(post.Name), matchKey(post.Author), len(terms),
+	}
+	for _, t := range terms {
+		keys = append(keys, fmt.Sprintf("terms:%v", t.value))
+		args = append(args, t.count)
+	}
+
+	for _, tag := range post.Tags {
+		keys = append(keys, fmt.Sprintf("tags:%v", tag))
+		args = append(args, tag)
+	}
+
+	return keys, args, nil
+}
+
+//importRemovedArgs returns keys and arguments of importRemovedScript writing a trashed post of a record or only the history
+//of a purged one.
+func (rr redisRepository) importRemovedArgs(ctx context.Context, rec *Record) ([]string, []interface{}, error) {
+	history := importedHistory(ctx, rec, rr.now())
+	args := []interface{}{rec.ID, "", 0}
+	if rec.Post != nil {
+		post := importedPost(rec.ID, rec.Post, history[len(history)-1].Number)
+		deletedAt := post.DeletedAt.Unix()
+		args = []interface{}{
+			rec.ID, deletedAt, 9,
+			"name", post.Name, "author", post.Author, "created_at", formatUnix(post.CreatedAt), "updated_at", formatUnix(post.UpdatedAt),
+			"body", post.Body, "format", post.Format, "tags", joinTags(post.Tags), "deleted_at", deletedAt, "version", post.Version,
+		}
+	}
+
+	for _, rev := range history {
+		entry, err := encodeRevision(rev)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, entry)
+	}
+
+	return []string{"next_post_id", "trash", fmt.Sprintf("post:%v", rec.ID), fmt.Sprintf("revisions:%v", rec.ID)}, args, nil
+}
+
+//encodeHistory encodes revisions to createScript entries separated by line breaks, encoded revisions have none.
+func encodeHistory(revisions []*Revision) (string, error) {
+	entries := make([]string, len(revisions))
+	for i, rev := range revisions {
+		entry, err := encodeRevision(rev)
+		if err != nil {
+			return "", err
+		}
+		entries[i] = entry
+	}
+
+	return strings.Join(entries, "\n"), nil
+}
+
+//loadScript makes sure a script is cached before it's called with EVALSHA in a pipeline, where it can't fall back to EVAL.
+func loadScript(ctx context.Context, db *redis.Client, script *redis.Script) error {
+	exists, err := script.Exists(ctx, db).Result()
+	if err != nil {
+		return err
+	}
+
+	if len(exists) == 0 || !exists[0] {
+		return script.Load(ctx, db).Err()
+	}
+
+	return nil
+}
+
+func (rr redisRepository) FindOne(ctx context.Context, id int64) (*Post, error) {
+	key := fmt.Sprintf("post:%v", id)
+
+	exists, err := rr.db.Exists(ctx, key).Result()
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	if exists == 0 {
+		return nil, notFound(id)
+	}
+
+	res, err := rr.db.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	if _, ok := res["deleted_at"]; ok {
+		return nil, notFound(id)
+	}
+
+	return toPost(id, res)
+}
+
+//Export scans post hashes and reads every page of them in a pipeline, so only IDs of exported posts are kept in memory.
+//A full export reads histories with the hashes and then scans revision lists for purged posts.
+func (rr redisRepository) Export(ctx context.Context, full bool, fn func(*Record) error) error {
+	//SCAN may return a key more than once, a post must be exported once.
+	var fnErr error
+	exported := make(map[int64]bool)
+	exportPage := func(prefix string) func([]string) error {
+		return func(keys []string) error {
+			ids := make([]int64, 0, len(keys))
+			for _, key := range keys {
+				id, err := strconv.ParseInt(strings.TrimPrefix(key, prefix), 10, 64)
+				if err != nil {
+					return err
+				}
+
+				if !exported[id] {
+					exported[id] = true
+					ids = append(ids, id)
+				}
+			}
+
+			records, err := readRecords(ctx, rr.db, ids, full)
+			if err != nil {
+				return err
+			}
+
+			for _, rec := range records {
+				if rec == nil {
+					continue
+				}
+
+				if fnErr = fn(rec); fnErr != nil {
+					return fnErr
+				}
+			}
+
+			return nil
+		}
+	}
+
+	err := scanKeys(ctx, rr.db, "post:*", exportPage("post:"))
+	if err == nil && full {
+		err = scanKeys(ctx, rr.db, "revisions:*", exportPage("revisions:"))
+	}
+
+	//Errors of fn aren't storage errors.
+	if fnErr != nil {
+		return fnErr
+	}
+
+	return storageError(err)
+}
+
+//readRecords reads records of posts in a pipeline, with their histories if full is set. Records of missing posts are nil,
+//so are records of trashed posts unless full is set.
+func readRecords(ctx context.Context, db redis.Cmdable, ids []int64, full bool) ([]*Record, error) {
+	hashes := make([]*redis.StringStringMapCmd, len(ids))
+	histories := make([]*redis.StringSliceCmd, len(ids))
+	_, err := db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			hashes[i] = pipe.HGetAll(ctx, fmt.Sprintf("post:%v", id))
+			if full {
+				histories[i] = pipe.LRange(ctx, fmt.Sprintf("revisions:%v", id), 0, -1)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*Record, len(ids))
+	for i, id := range ids {
+		rec := &Record{ID: id}
+		if res := hashes[i].Val(); len(res) != 0 {
+			if _, ok := res["deleted_at"]; ok && !full {
+				continue
+			}
+
+			if rec.Post, err = toPost(id, res); err != nil {
+				return nil, err
+			}
+		}
+
+		if full {
+			for _, entry := range histories[i].Val() {
+				rev, err := decodeRevision(id, entry)
+				if err != nil {
+					return nil, err
+				}
+				rec.Revisions = append(rec.Revisions, rev)
+			}
+		}
+
+		if rec.Post != nil || len(rec.Revisions) != 0 {
+			records[i] = rec
+		}
+	}
+
+	return records, nil
+}
+
+//FindByIDs reads all posts in a single pipeline.
+func (rr redisRepository) FindByIDs(ctx context.Context, ids []int64) ([]*Post, []int64, error) {
+	read, err := readPosts(ctx, rr.db, ids)
+	if err != nil {
+		return nil, nil, storageError(err)
+	}
+
+	found := make(map[int64]*Post, len(ids))
+	for i, post := range read {
+		if post != nil {
+			found[ids[i]] = post
+		}
+	}
+
+	posts, missing := splitFound(ids, found)
+	return posts, missing, nil
+}
+
+func (rr redisRepository) FindMany(ctx context.Context, filter *SearchFilter) ([]*Post, string, error) {
+	after, err := pageStart(filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//One extra entry tells whether there's a next page.
+	var want int64
+	if filter.Limit > 0 {
+		want = filter.Limit + 1
+	}
+
+	var entries []cursor
+	switch {
+	case filter.Query != "":
+		entries, err = rr.searchTerms(ctx, filter, after, want)
+	case inexact(filter):
+		entries, err = rr.scanMatches(ctx, filter, after, want)
+	default:
+		entries, err = rr.scanTimeline(ctx, filter, after, want)
+	}
+
+	if err != nil {
+		return nil, "", storageError(err)
+	}
+
+	entries, next := page(entries, filter.Limit)
+
+	//Hashes are read whole unless bodies are omitted.
+	rawPosts := make([]*redis.StringStringMapCmd, 0, len(entries))
+	summaries := make([]*redis.SliceCmd, 0, len(entries))
+	_, err = rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, entry := range entries {
+			key := fmt.Sprintf("post:%v", entry.id)
+			if filter.OmitBody {
+				summaries = append(summaries, pipe.HMGet(ctx, key, summaryFields...))
+			} else {
+				rawPosts = append(rawPosts, pipe.HGetAll(ctx, key))
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", storageError(err)
+	}
+
+	posts := make([]*Post, 0, len(entries))
+	for i := range entries {
+		var m map[string]string
+		if filter.OmitBody {
+			m, err = summaryMap(summaries[i])
+		} else {
+			m, err = rawPosts[i].Result()
+		}
+
+		if err != nil {
+			return nil, "", storageError(err)
+		}
+
+		//Index points to a removed or trashed post.
+		if _, ok := m["deleted_at"]; ok || len(m) == 0 {
+			continue
+		}
+
+		post, err := toPost(entries[i].id, m)
+		if err != nil {
+			return nil, "", err
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, next, nil
+}
+
+//scanTimeline returns up to want (or all if want is 0) entries matching filter after a cursor.
+func (rr redisRepository) scanTimeline(ctx context.Context, filter *SearchFilter, after *cursor, want int64) ([]cursor, error) {
+	//Walk the time index of one filter and check membership in the other one if both are set.
+	var (
+		key    string
+		member string
+	)
+	switch {
+	case filter.Author != "" && filter.Name != "":
+		key = fmt.Sprintf("timeline:authors:%v", filter.Author)
+		member = fmt.Sprintf("names:%v", filter.Name)
+	case filter.Author != "":
+		key = fmt.Sprintf("timeline:authors:%v", filter.Author)
+	case filter.Name != "":
+		key = fmt.Sprintf("timeline:names:%v", filter.Name)
+	default:
+		key = "timeline"
+	}
+
+	order := filter.Order
+	opt := &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: want}
+	if !filter.CreatedAfter.IsZero() {
+		opt.Min = formatScore(filter.CreatedAfter)
+	}
+
+	if !filter.CreatedBefore.IsZero() {
+		opt.Max = "(" + formatScore(filter.CreatedBefore)
+	}
+
+	//Posts created at the same time as the cursor are skipped below.
+	if after != nil {
+		pos := after.createdAt
+		switch {
+		case order == Ascending && !pos.Before(filter.CreatedAfter):
+			opt.Min = formatScore(pos)
+		case order == Descending && (filter.CreatedBefore.IsZero() || pos.Before(filter.CreatedBefore)):
+			opt.Max = formatScore(pos)
+		}
+	}
+
+	entries := make([]cursor, 0, want)
+	for {
+		var res *redis.ZSliceCmd
+		if order == Ascending {
+			res = rr.db.ZRangeByScoreWithScores(ctx, key, opt)
+		} else {
+			res = rr.db.ZRevRangeByScoreWithScores(ctx, key, opt)
+		}
+
+		zs, err := res.Result()
+		if err != nil {
+			return nil, err
+		}
+
+		batch := make([]cursor, 0, len(zs))
+		for _, z := range zs {
+			id, err := strconv.ParseInt(fmt.Sprint(z.Member), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			entry := cursor{order: order, createdAt: scoreTime(z.Score), id: id}
+			if after != nil && !after.before(entry) {
+				continue
+			}
+			batch = append(batch, entry)
+		}
+
+		if member != "" && len(batch) != 0 {
+			batch, err = rr.filterMembers(ctx, member, batch)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(filter.Tags) != 0 && len(batch) != 0 {
+			batch, err = rr.filterTags(ctx, filter, batch)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, batch...)
+
+		if want == 0 || int64(len(entries)) >= want || int64(len(zs)) < want {
+			break
+		}
+		opt.Offset += int64(len(zs))
+	}
+
+	if want > 0 && int64(len(entries)) > want {
+		entries = entries[:want]
+	}
+
+	return entries, nil
+}
+
+//searchTerms ranks posts whose names contain any of filter's query terms, applies the rest of filter
+//and returns up to want (or all if want is 0) entries after a cursor.
+//
+//Relevance is a sum of TF-IDF weights of matching terms, so rare terms are worth more than common ones.
+func (rr redisRepository) searchTerms(ctx context.Context, filter *SearchFilter, after *cursor, want int64) ([]cursor, error) {
+	terms := tokenize(filter.Query)
+	if len(terms) == 0 {
+		return []cursor{}, nil
+	}
+
+	var total *redis.IntCmd
+	postings := make([]*redis.ZSliceCmd, 0, len(terms))
+	_, err := rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.ZCard(ctx, "timeline")
+		for _, t := range terms {
+			postings = append(postings, pipe.ZRangeWithScores(ctx, fmt.Sprintf("terms:%v", t.value), 0, -1))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	relevance := make(map[int64]float64)
+	for i, posting := range postings {
+		zs := posting.Val()
+		if len(zs) == 0 {
+			continue
+		}
+
+		idf := math.Log(1 + float64(total.Val())/float64(len(zs)))
+		for _, z := range zs {
+			id, err := strconv.ParseInt(fmt.Sprint(z.Member), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := relevance[id]; !ok {
+				ids = append(ids, id)
+			}
+			relevance[id] += z.Score * idf * float64(terms[i].count)
+		}
+	}
+
+	if inexact(filter) {
+		matched, err := rr.matchIDs(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		found := ids
+		ids = make([]int64, 0, len(found))
+		for _, id := range found {
+			if matched[id] {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	entries, err := rr.timeEntries(ctx, filter, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	entries = orderEntries(entries, relevance, after)
+	if want > 0 && int64(len(entries)) > want {
+		entries = entries[:want]
+	}
+
+	return entries, nil
+}
+
+//scanMatches returns up to want (or all if want is 0) entries after a cursor of posts whose names and authors
+//match filter's case-insensitive or prefix filters and the rest of filter.
+func (rr redisRepository) scanMatches(ctx context.Context, filter *SearchFilter, after *cursor, want int64) ([]cursor, error) {
+	matched, err := rr.matchIDs(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	//IDs are sorted to read creation times in a stable order, entries are ordered below.
+	ids := make([]int64, 0, len(matched))
+	for id := range matched {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	entries, err := rr.timeEntries(ctx, filter, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	entries = orderEntries(entries, nil, after)
+	if want > 0 && int64(len(entries)) > want {
+		entries = entries[:want]
+	}
+
+	return entries, nil
+}
+
+//inexact reports whether filter matches names or authors other than exactly.
+func inexact(filter *SearchFilter) bool {
+	return filter.Match != MatchExact && (filter.Name != "" || filter.Author != "")
+}
+
+//matchIDs returns IDs of posts whose names and authors match filter's case-insensitive or prefix filters.
+//Prefixes are looked up in lex sets of name and author keys and the sets of every found key are read.
+func (rr redisRepository) matchIDs(ctx context.Context, filter *SearchFilter) (map[int64]bool, error) {
+	var matched map[int64]bool
+	for _, f := range []struct{ field, value string }{{"names", filter.Name}, {"authors", filter.Author}} {
+		if f.value == "" {
+			continue
+		}
+
+		keys := []string{matchKey(f.value)}
+		if filter.Match == MatchPrefix {
+			//"\xff" never occurs in UTF-8, so it sorts after every key starting with the prefix.
+			var err error
+			keys, err = rr.db.ZRangeByLex(ctx, "lex:"+f.field, &redis.ZRangeBy{Min: "[" + keys[0], Max: "[" + keys[0] + "\xff"}).Result()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		sets := make([]*redis.StringSliceCmd, 0, len(keys))
+		_, err := rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				sets = append(sets, pipe.SMembers(ctx, fmt.Sprintf("ci:%v:%v", f.field, key)))
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		found := make(map[int64]bool)
+		for _, set := range sets {
+			for _, member := range set.Val() {
+				id, err := strconv.ParseInt(member, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+
+				if matched == nil || matched[id] {
+					found[id] = true
+				}
+			}
+		}
+		matched = found
+	}
+
+	return matched, nil
+}
+
+//timeEntries returns entries of posts with ids that are in the time index and match filter's time and tag filters.
+//Names and authors are checked against exact index sets, other matches must be applied to ids by the caller.
+func (rr redisRepository) timeEntries(ctx context.Context, filter *SearchFilter, ids []int64) ([]cursor, error) {
+	exact := filter.Match == MatchExact
+	var (
+		created = make([]*redis.FloatCmd, len(ids))
+		names   = make([]*redis.BoolCmd, len(ids))
+		authors = make([]*redis.BoolCmd, len(ids))
+	)
+	_, err := rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			created[i] = pipe.ZScore(ctx, "timeline", strconv.FormatInt(id, 10))
+			if exact && filter.Name != "" {
+				names[i] = pipe.SIsMember(ctx, fmt.Sprintf("names:%v", filter.Name), id)
+			}
+
+			if exact && filter.Author != "" {
+				authors[i] = pipe.SIsMember(ctx, fmt.Sprintf("authors:%v", filter.Author), id)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	entries := make([]cursor, 0, len(ids))
+	for i, id := range ids {
+		score, err := created[i].Result()
+		if errors.Is(err, redis.Nil) {
+			//Index points to a removed post.
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case names[i] != nil && !names[i].Val():
+			continue
+		case authors[i] != nil && !authors[i].Val():
+			continue
+		case !filter.CreatedAfter.IsZero() && scoreTime(score).Before(filter.CreatedAfter):
+			continue
+		case !filter.CreatedBefore.IsZero() && !scoreTime(score).Before(filter.CreatedBefore):
+			continue
+		}
+
+		entries = append(entries, cursor{order: filter.Order, createdAt: scoreTime(score), id: id})
+	}
+
+	if len(filter.Tags) != 0 && len(entries) != 0 {
+		entries, err = rr.filterTags(ctx, filter, entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+//filterMembers returns entries whose IDs belong to a set stored at key.
+func (rr redisRepository) filterMembers(ctx context.Context, key string, entries []cursor) ([]cursor, error) {
+	res := make([]*redis.BoolCmd, 0, len(entries))
+	_, err := rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, entry := range entries {
+			res = append(res, pipe.SIsMember(ctx, key, entry.id))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]cursor, 0, len(entries))
+	for i, r := range res {
+		if r.Val() {
+			filtered = append(filtered, entries[i])
+		}
+	}
+
+	return filtered, nil
+}
+
+//filterTags returns entries whose posts have all or any of filter's tags.
+func (rr redisRepository) filterTags(ctx context.Context, filter *SearchFilter, entries []cursor) ([]cursor, error) {
+	res := make([][]*redis.BoolCmd, len(entries))
+	_, err := rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, entry := range entries {
+			for _, tag := range filter.Tags {
+				res[i] = append(res[i], pipe.SIsMember(ctx, fmt.Sprintf("tags:%v", tag), entry.id))
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]cursor, 0, len(entries))
+	for i, cmds := range res {
+		found := 0
+		for _, cmd := range cmds {
+			if cmd.Val() {
+				found++
+			}
+		}
+
+		if (filter.AnyTag && found != 0) || found == len(filter.Tags) {
+			filtered = append(filtered, entries[i])
+		}
+	}
+
+	return filtered, nil
+}
+
+func (rr redisRepository) Update(ctx context.Context, id int64, post *Post, version int64) (*Post, error) {
+	key := fmt.Sprintf("post:%v", id)
+
+	var updated, previous *Post
+	txf := func(tx *redis.Tx) error {
+		res, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		if _, ok := res["deleted_at"]; ok || len(res) == 0 {
+			return notFound(id)
+		}
+
+		old, err := toPost(id, res)
+		if err != nil {
+			return err
+		}
+
+		if version != AnyVersion && version != old.Version {
+			return ErrVersionMismatch
+		}
+
+		now := rr.now()
+		current := &Post{
+			ID:        id,
+			Name:      post.Name,
+			Author:    post.Author,
+			CreatedAt: old.CreatedAt,
+			UpdatedAt: storedTime(now),
+			Body:      post.Body,
+			Format:    post.Format,
+			Tags:      post.Tags,
+			Version:   old.Version + 1,
+		}
+		rev, err := encodeRevision(newRevision(ctx, ActionUpdate, old, current, now))
+		if err != nil {
+			return err
+		}
+
+		//Name, author and tag index sets are only touched when the value actually changes.
+		//The whole transaction is discarded if the post was modified after WATCH.
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			z := &redis.Z{Score: timeScore(old.CreatedAt), Member: id}
+			pipe.HSet(ctx, key,
+				"name", post.Name, "author", post.Author, "updated_at", formatUnix(current.UpdatedAt),
+				"body", post.Body, "format", post.Format, "tags", joinTags(post.Tags),
+			)
+			pipe.HIncrBy(ctx, key, "version", 1)
+			if old.Name != post.Name {
+				pipe.SRem(ctx, fmt.Sprintf("names:%v", old.Name), id)
+				pipe.SAdd(ctx, fmt.Sprintf("names:%v", post.Name), id)
+				pipe.ZRem(ctx, fmt.Sprintf("timeline:names:%v", old.Name), id)
+				pipe.ZAdd(ctx, fmt.Sprintf("timeline:names:%v", post.Name), z)
+				for _, t := range tokenize(old.Name) {
+					pipe.ZRem(ctx, fmt.Sprintf("terms:%v", t.value), id)
+				}
+
+				for _, t := range tokenize(post.Name) {
+					pipe.ZAdd(ctx, fmt.Sprintf("terms:%v", t.value), &redis.Z{Score: float64(t.count), Member: id})
+				}
+			}
+
+			for _, f := range matchFields(old, current) {
+				if f.old != f.current {
+					pipe.SRem(ctx, fmt.Sprintf("ci:%v:%v", f.field, f.old), id)
+					pipe.SAdd(ctx, fmt.Sprintf("ci:%v:%v", f.field, f.current), id)
+					pipe.ZAdd(ctx, "lex:"+f.field, &redis.Z{Member: f.current})
+				}
+			}
+
+			if old.Author != post.Author {
+				pipe.SRem(ctx, fmt.Sprintf("authors:%v", old.Author), id)
+				pipe.SAdd(ctx, fmt.Sprintf("authors:%v", post.Author), id)
+				pipe.ZRem(ctx, fmt.Sprintf("timeline:authors:%v", old.Author), id)
+				pipe.ZAdd(ctx, fmt.Sprintf("timeline:authors:%v", post.Author), z)
+				pipe.ZIncrBy(ctx, "author_counts", -1, old.Author)
+				pipe.ZIncrBy(ctx, "author_counts", 1, post.Author)
+				pipe.ZRemRangeByScore(ctx, "author_counts", "-inf", "0")
+			}
+
+			if removed, added := diffTags(old.Tags, post.Tags); len(removed) != 0 || len(added) != 0 {
+				for _, tag := range removed {
+					pipe.SRem(ctx, fmt.Sprintf("tags:%v", tag), id)
+					pipe.ZIncrBy(ctx, "tag_counts", -1, tag)
+				}
+
+				for _, tag := range added {
+					pipe.SAdd(ctx, fmt.Sprintf("tags:%v", tag), id)
+					pipe.ZIncrBy(ctx, "tag_counts", 1, tag)
+				}
+				pipe.ZRemRangeByScore(ctx, "tag_counts", "-inf", "0")
+			}
+
+			pipe.RPush(ctx, fmt.Sprintf("revisions:%v", id), rev)
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		updated, previous = current, old
+		return nil
+	}
+
+	if err := rr.watch(ctx, txf, key); err != nil {
+		return nil, storageError(err)
+	}
+
+	//Keys are pruned after the update is committed. A stale lex entry only costs an empty set read
+	//and RebuildMatchIndexes removes it, so the update doesn't fail if pruning does.
+	for _, f := range matchFields(previous, updated) {
+		if f.old != f.current {
+			pruneKeyScript.Run(ctx, rr.db, []string{fmt.Sprintf("ci:%v:%v", f.field, f.old), "lex:" + f.field}, f.old)
+		}
+	}
+
+	return updated, nil
+}
+
+//matchField is a name or author key of a post before and after an update.
+type matchField struct {
+	field, old, current string
+}
+
+//matchFields returns name and author keys of two states of a post.
+func matchFields(old, current *Post) []matchField {
+	return []matchField{
+		{"names", matchKey(old.Name), matchKey(current.Name)},
+		{"authors", matchKey(old.Author), matchKey(current.Author)},
+	}
+}
+
+func (rr redisRepository) Remove(ctx context.Context, id int64, version int64) (bool, error) {
+	key := fmt.Sprintf("post:%v", id)
+
+	//Index keys depend on the stored post, the script checks the post hasn't changed since it was read.
+	for i := 0; i < maxTxRetries; i++ {
+		res, err := rr.db.HGetAll(ctx, key).Result()
+		if err != nil {
+			return false, storageError(err)
+		}
+
+		if len(res) == 0 {
+			return false, notFound(id)
+		}
+
+		post, err := toPost(id, res)
+		if err != nil {
+			return false, err
+		}
+
+		if post.DeletedAt != nil {
+			return false, notFound(id)
+		}
+
+		if version != AnyVersion && version != post.Version {
+			return false, ErrVersionMismatch
+		}
+
+		keys, args, err := rr.removeArgs(ctx, post)
+		if err != nil {
+			return false, err
+		}
+
+		removed, err := removeScript.Run(ctx, rr.db, keys, args...).Int64()
+		if err != nil {
+			return false, storageError(err)
+		}
+
+		switch removed {
+		case 0:
+			return false, notFound(id)
+		case 1:
+			return true, nil
+		}
+	}
+
+	return false, Errorf(ErrConflict, "post was changed concurrently, try again")
+}
+
+//RemoveMany reads every chunk of posts and trashes them in a pipeline. A post changed after it was read isn't trashed.
+//An atomic batch is read with WATCH and trashed in a single MULTI/EXEC transaction.
+func (rr redisRepository) RemoveMany(ctx context.Context, ids []int64, atomic bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ids))
+	if len(ids) == 0 {
+		return results, nil
+	}
+
+	if err := loadScript(ctx, rr.db, removeScript); err != nil {
+		return nil, storageError(err)
+	}
+
+	if atomic {
+		if err := rr.removeAtomic(ctx, ids, results); err != nil {
+			return nil, storageError(err)
+		}
+
+		return results, nil
+	}
+
+	forChunks(len(ids), false, func(start, end int) {
+		chunk := ids[start:end]
+		posts, err := readPosts(ctx, rr.db, chunk)
+		if err != nil {
+			failBatch(results[start:end], storageError(err))
+			return
+		}
+
+		cmds := make([]*redis.Cmd, len(chunk))
+		_, err = rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, post := range posts {
+				if post == nil {
+					continue
+				}
+
+				keys, args, err := rr.removeArgs(ctx, post)
+				if err != nil {
+					results[start+i].Err = err
+					continue
+				}
+				cmds[i] = removeScript.EvalSha(ctx, pipe, keys, args...)
+			}
+
+			return nil
+		})
+		if pipelineFailed(err, false) {
+			failBatch(results[start:end], storageError(err))
+			return
+		}
+
+		for i, id := range chunk {
+			res := &results[start+i]
+			switch {
+			case res.Err != nil:
+			case cmds[i] == nil:
+				res.Err = notFound(id)
+			case cmds[i].Err() != nil:
+				res.Err = storageError(cmds[i].Err())
+			default:
+				switch n, _ := cmds[i].Int64(); n {
+				case 1:
+					res.ID = id
+				case 0:
+					res.Err = notFound(id)
+				default:
+					res.Err = Errorf(ErrConflict, "post %v was changed concurrently, try again", id)
+				}
+			}
+		}
+	})
+
+	return results, nil
+}
+
+//removeAtomic trashes all posts in a transaction or none of them if any is missing.
+func (rr redisRepository) removeAtomic(ctx context.Context, ids []int64, results []BatchResult) error {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = fmt.Sprintf("post:%v", id)
+	}
+
+	txf := func(tx *redis.Tx) error {
+		posts, err := readPosts(ctx, tx, ids)
+		if err != nil {
+			return err
+		}
+
+		for i, post := range posts {
+			results[i] = BatchResult{}
+			if post == nil {
+				results[i].Err = notFound(ids[i])
+			}
+		}
+
+		if failed(results) {
+			failBatch(results, ErrBatchAborted)
+			return nil
+		}
+
+		scripts := make([][]string, len(posts))
+		args := make([][]interface{}, len(posts))
+		for i, post := range posts {
+			if scripts[i], args[i], err = rr.removeArgs(ctx, post); err != nil {
+				return err
+			}
+		}
+
+		//The transaction is discarded if any post was modified after WATCH. Like in createMany, EXEC doesn't roll back
+		//scripts that ran if another one fails at runtime, the batch fails as a whole then.
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := range posts {
+				removeScript.EvalSha(ctx, pipe, scripts[i], args[i]...)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for i, id := range ids {
+			results[i].ID = id
+		}
+
+		return nil
+	}
+
+	return rr.watch(ctx, txf, keys...)
+}
+
+//pipelineFailed reports whether a pipeline failed as a whole rather than in some of its commands. A transaction fails
+//as a whole if any command does, a pipeline fails if the connection does. Commands of a pipeline that failed on their own
+//have Redis error replies and are reported by their results.
+func pipelineFailed(err error, atomic bool) bool {
+	var replyErr redis.Error
+	return err != nil && (atomic || !errors.As(err, &replyErr))
+}
+
+//readPosts reads posts in a pipeline, missing and trashed posts are nil.
+func readPosts(ctx context.Context, db redis.Cmdable, ids []int64) ([]*Post, error) {
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	_, err := db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, fmt.Sprintf("post:%v", id))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	posts := make([]*Post, len(ids))
+	for i, cmd := range cmds {
+		res := cmd.Val()
+		if _, ok := res["deleted_at"]; ok || len(res) == 0 {
+			continue
+		}
+
+		if posts[i], err = toPost(ids[i], res); err != nil {
+			return nil, err
+		}
+	}
+
+	return posts, nil
+}
+
+//removeArgs returns keys and arguments of removeScript trashing a post read at its current version.
+func (rr redisRepository) removeArgs(ctx context.Context, post *Post) ([]string, []interface{}, error) {
+	now := rr.now()
+	deletedAt := time.Unix(now.Unix(), 0)
+	trashed := copyPost(post)
+	trashed.DeletedAt = &deletedAt
+	trashed.Version++
+	rev, err := encodeRevision(newRevision(ctx, ActionRemove, post, trashed, now))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, terms := indexKeys(post)
+	args := []interface{}{post.ID, post.Version, post.Author, deletedAt.Unix(), rev, matchKey(post.Name), matchKey(post.Author), len(terms)}
+	for _, tag := range post.Tags {
+		args = append(args, tag)
+	}
+
+	return keys, args, nil
+}
+
+func (rr redisRepository) Restore(ctx context.Context, id int64) (*Post, error) {
+	key := fmt.Sprintf("post:%v", id)
+
+	for i := 0; i < maxTxRetries; i++ {
+		res, err := rr.db.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, storageError(err)
+		}
+
+		if len(res) == 0 {
+			return nil, notFound(id)
+		}
+
+		post, err := toPost(id, res)
+		if err != nil {
+			return nil, err
+		}
+
+		if post.DeletedAt == nil {
+			return nil, notTrashed(id)
+		}
+
+		restored := copyPost(post)
+		restored.DeletedAt = nil
+		restored.Version++
+		rev, err := encodeRevision(newRevision(ctx, ActionRestore, post, restored, rr.now()))
+		if err != nil {
+			return nil, err
+		}
+
+		keys, terms := indexKeys(post)
+		args := []interface{}{id, post.Version, post.Author, timeScore(post.CreatedAt), rev, matchKey(post.Name), matchKey(post.Author), len(terms)}
+		for _, t := range terms {
+			args = append(args, t.count)
+		}
+
+		for _, tag := range post.Tags {
+			args = append(args, tag)
+		}
+
+		n, err := restoreScript.Run(ctx, rr.db, keys, args...).Int64()
+		if err != nil {
+			return nil, storageError(err)
+		}
+
+		switch n {
+		case 0:
+			return nil, notFound(id)
+		case -2:
+			return nil, notTrashed(id)
+		case 1:
+			return restored, nil
+		}
+	}
+
+	return nil, Errorf(ErrConflict, "post was changed concurrently, try again")
+}
+
+//indexKeys returns keys of a post and its indexes in the order of removeScript and restoreScript KEYS and the terms of its name.
+func indexKeys(post *Post) ([]string, []term) {
+	keys := []string{
+		fmt.Sprintf("post:%v", post.ID),
+		fmt.Sprintf("names:%v", post.Name),
+		fmt.Sprintf("authors:%v", post.Author),
+		"author_counts",
+		"tag_counts",
+		"trash",
+		fmt.Sprintf("revisions:%v", post.ID),
+		fmt.Sprintf("ci:names:%v", matchKey(post.Name)),
+		fmt.Sprintf("ci:authors:%v", matchKey(post.Author)),
+		"lex:names",
+		"lex:authors",
+		"timeline",
+		fmt.Sprintf("timeline:names:%v", post.Name),
+		fmt.Sprintf("timeline:authors:%v", post.Author),
+	}
+
+	terms := tokenize(post.Name)
+	for _, t := range terms {
+		keys = append(keys, fmt.Sprintf("terms:%v", t.value))
+	}
+
+	for _, tag := range post.Tags {
+		keys = append(keys, fmt.Sprintf("tags:%v", tag))
+	}
+
+	return keys, terms
+}
+
+func (rr redisRepository) FindTrashed(ctx context.Context, filter *TrashFilter) ([]*Post, string, error) {
+	after, err := trashStart(filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//One extra entry tells whether there's a next page. Entries deleted at the same second as the cursor are skipped below.
+	opt := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+	if after != nil {
+		opt.Max = formatScore(after.createdAt)
+	}
+
+	var entries []cursor
+	for {
+		if filter.Limit > 0 {
+			opt.Count = filter.Limit + 1
+		}
+
+		zs, err := rr.db.ZRevRangeByScoreWithScores(ctx, "trash", opt).Result()
+		if err != nil {
+			return nil, "", storageError(err)
+		}
+
+		for _, z := range zs {
+			id, err := strconv.ParseInt(fmt.Sprint(z.Member), 10, 64)
+			if err != nil {
+				return nil, "", err
+			}
+
+			entry := cursor{order: Descending, createdAt: scoreTime(z.Score), id: id}
+			if after == nil || after.before(entry) {
+				entries = append(entries, entry)
+			}
+		}
+
+		if filter.Limit == 0 || int64(len(entries)) > filter.Limit || int64(len(zs)) < opt.Count {
+			break
+		}
+		opt.Offset += int64(len(zs))
+	}
+
+	entries, next := page(entries, filter.Limit)
+
+	rawPosts := make([]*redis.StringStringMapCmd, 0, len(entries))
+	_, err = rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, entry := range entries {
+			rawPosts = append(rawPosts, pipe.HGetAll(ctx, fmt.Sprintf("post:%v", entry.id)))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", storageError(err)
+	}
+
+	posts := make([]*Post, 0, len(entries))
+	for i, cmd := range rawPosts {
+		//Trash entry points to a purged post.
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+
+		post, err := toPost(entries[i].id, cmd.Val())
+		if err != nil {
+			return nil, "", err
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, next, nil
+}
+
+func (rr redisRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	//Deletion time is stored in whole seconds, so trash entries are compared with the bound as is.
+	opt := &redis.ZRangeBy{Min: "-inf", Max: "(" + formatScore(before), Count: 100}
+
+	var purged int64
+	for {
+		zs, err := rr.db.ZRangeByScoreWithScores(ctx, "trash", opt).Result()
+		if err != nil {
+			return purged, storageError(err)
+		}
+
+		rawPosts := make([]*redis.StringStringMapCmd, len(zs))
+		_, err = rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, z := range zs {
+				rawPosts[i] = pipe.HGetAll(ctx, fmt.Sprintf("post:%v", z.Member))
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return purged, storageError(err)
+		}
+
+		for i, z := range zs {
+			id := fmt.Sprint(z.Member)
+			keys := []string{"post:" + id, "trash", "revisions:" + id}
+			args, err := rr.purgeArgs(ctx, id, int64(z.Score), rawPosts[i].Val())
+			if err != nil {
+				return purged, err
+			}
+
+			n, err := purgeScript.Run(ctx, rr.db, keys, args...).Int64()
+			if err != nil {
+				return purged, storageError(err)
+			}
+
+			switch n {
+			case 1:
+				purged++
+			case -1:
+				opt.Offset++
+			}
+		}
+
+		//Dropped entries are removed from the trash, so the next batch starts after the kept ones.
+		if int64(len(zs)) < opt.Count {
+			return purged, nil
+		}
+	}
+}
+
+//purgeArgs returns arguments of purgeScript purging a post with a trash entry read at the version of its hash.
+//The hash is empty if the entry points to a missing post, the script drops such entries.
+func (rr redisRepository) purgeArgs(ctx context.Context, id string, deletedAt int64, hash map[string]string) ([]interface{}, error) {
+	if len(hash) == 0 {
+		return []interface{}{id, deletedAt, 0, ""}, nil
+	}
+
+	postID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("trash has invalid member %q", id)
+	}
+
+	post, err := toPost(postID, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	final := copyPost(post)
+	final.Version++
+	rev, err := encodeRevision(newRevision(ctx, ActionPurge, post, final, rr.now()))
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{id, deletedAt, post.Version, rev}, nil
+}
+
+func (rr redisRepository) Revisions(ctx context.Context, id int64) ([]*Revision, error) {
+	entries, err := rr.db.LRange(ctx, fmt.Sprintf("revisions:%v", id), 0, -1).Result()
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	//Posts created before revisions were recorded exist without a history.
+	if len(entries) == 0 {
+		if err := rr.checkExists(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	revisions := make([]*Revision, 0, len(entries))
+	for _, entry := range entries {
+		rev, err := decodeRevision(id, entry)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, nil
+}
+
+func (rr redisRepository) Revision(ctx context.Context, id int64, n int64) (*Revision, error) {
+	key := fmt.Sprintf("revisions:%v", id)
+
+	//Revisions are numbered consecutively, but the history of older posts doesn't start with the first one.
+	first, err := rr.db.LIndex(ctx, key, 0).Result()
+	if errors.Is(err, redis.Nil) {
+		if err := rr.checkExists(ctx, id); err != nil {
+			return nil, err
+		}
+
+		return nil, revisionNotFound(id, n)
+	}
+
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	rev, err := decodeRevision(id, first)
+	if err != nil {
+		return nil, err
+	}
+
+	if n == rev.Number {
+		return rev, nil
+	}
+
+	if n < rev.Number {
+		return nil, revisionNotFound(id, n)
+	}
+
+	entry, err := rr.db.LIndex(ctx, key, n-rev.Number).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, revisionNotFound(id, n)
+	}
+
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	return decodeRevision(id, entry)
+}
+
+//checkExists returns an ErrNotFound error if there's no post hash with the ID, trashed or not.
+func (rr redisRepository) checkExists(ctx context.Context, id int64) error {
+	exists, err := rr.db.Exists(ctx, fmt.Sprintf("post:%v", id)).Result()
+	if err != nil {
+		return storageError(err)
+	}
+
+	if exists == 0 {
+		return notFound(id)
+	}
+
+	return nil
+}
+
+//watch runs txf in an optimistic transaction watching keys and retries it if any of the keys were changed concurrently.
+func (rr redisRepository) watch(ctx context.Context, txf func(*redis.Tx) error, keys ...string) error {
+	for i := 0; i < maxTxRetries; i++ {
+		err := rr.db.Watch(ctx, txf, keys...)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+
+		return err
+	}
+
+	return Errorf(ErrConflict, "post was changed concurrently, try again")
+}
+
+func (rr redisRepository) Count(ctx context.Context, filter *CountFilter) ([]AuthorCount, int64, error) {
+	if err := checkCountFilter(filter); err != nil {
+		return nil, 0, err
+	}
+
+	//Counters are kept by exact author, posts of every spelling of an author are in its ci index.
+	if filter.Author != "" && filter.Match == MatchCaseInsensitive {
+		n, err := rr.db.SCard(ctx, fmt.Sprintf("ci:authors:%v", matchKey(filter.Author))).Result()
+		if err != nil {
+			return nil, 0, storageError(err)
+		}
+
+		if n == 0 {
+			return []AuthorCount{}, 0, nil
+		}
+
+		return []AuthorCount{{Author: filter.Author, Count: n}}, n, nil
+	}
+
+	if filter.Author != "" {
+		n, err := rr.db.ZScore(ctx, "author_counts", filter.Author).Result()
+		if errors.Is(err, redis.Nil) {
+			return []AuthorCount{}, 0, nil
+		}
+
+		if err != nil {
+			return nil, 0, storageError(err)
+		}
+
+		return []AuthorCount{{Author: filter.Author, Count: int64(n)}}, int64(n), nil
+	}
+
+	var (
+		total  *redis.IntCmd
+		counts *redis.ZSliceCmd
+	)
+	_, err := rr.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.ZCard(ctx, "timeline")
+		if filter.Order == Ascending {
+			counts = pipe.ZRangeWithScores(ctx, "author_counts", 0, filter.Limit-1)
+		} else {
+			counts = pipe.ZRevRangeWithScores(ctx, "author_counts", 0, filter.Limit-1)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, 0, storageError(err)
+	}
+
+	authors := make([]AuthorCount, 0, len(counts.Val()))
+	for _, z := range counts.Val() {
+		authors = append(authors, AuthorCount{Author: fmt.Sprint(z.Member), Count: int64(z.Score)})
+	}
+
+	return authors, total.Val(), nil
+}
+
+func (rr redisRepository) Tags(ctx context.Context, filter *TagFilter) ([]TagCount, error) {
+	if err := checkTagFilter(filter); err != nil {
+		return nil, err
+	}
+
+	var counts *redis.ZSliceCmd
+	if filter.Order == Ascending {
+		counts = rr.db.ZRangeWithScores(ctx, "tag_counts", 0, filter.Limit-1)
+	} else {
+		counts = rr.db.ZRevRangeWithScores(ctx, "tag_counts", 0, filter.Limit-1)
+	}
+
+	zs, err := counts.Result()
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	tags := make([]TagCount, 0, len(zs))
+	for _, z := range zs {
+		tags = append(tags, TagCount{Tag: fmt.Sprint(z.Member), Count: int64(z.Score)})
+	}
+
+	return tags, nil
+}
+
+//formatScore formats t as a time index score bound. Stored times are at or after t exactly when their scores are at or after the bound.
+func formatScore(t time.Time) string {
+	return strconv.FormatFloat(timeScore(ceilMicro(t)), 'f', -1, 64)
+}
+
+func toPost(id int64, res map[string]string) (*Post, error) {
+	createdAt, err := parseUnix(res["created_at"])
+	if err != nil {
+		return nil, err
+	}
+
+	//Posts written before updated_at was introduced were last updated when they were created as far as we know.
+	updatedAt := createdAt
+	if v, ok := res["updated_at"]; ok {
+		updatedAt, err = parseUnix(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//Posts created before versioning was introduced don't have a version field.
+	var version int64
+	if v, ok := res["version"]; ok {
+		version, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var deletedAt *time.Time
+	if v, ok := res["deleted_at"]; ok {
+		unix, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		t := time.Unix(unix, 0)
+		deletedAt = &t
+	}
+
+	return &Post{
+		ID:        id,
+		Name:      res["name"],
+		Author:    res["author"],
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+		Body:      res["body"],
+		Format:    res["format"],
+		Tags:      splitTags(res["tags"]),
+		DeletedAt: deletedAt,
+		Version:   version,
+	}, nil
+}
+
+//summaryFields are post hash fields read when a body isn't needed.
+var summaryFields = []string{"name", "author", "created_at", "updated_at", "format", "tags", "deleted_at", "version"}
+
+//summaryMap turns the result of HMGET of summaryFields into a map of existing fields.
+func summaryMap(cmd *redis.SliceCmd) (map[string]string, error) {
+	vals, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, len(vals))
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			m[summaryFields[i]] = s
+		}
+	}
+
+	return m, nil
+}
+
+//storedRevision is a revision encoded to a revisions list entry. Times are Unix seconds formatted by formatUnix
+//and the post ID is the list's.
+type storedRevision struct {
+	Number    int64       `json:"number"`
+	Action    string      `json:"action"`
+	Editor    string      `json:"editor"`
+	RevisedAt json.Number `json:"revised_at"`
+	Changed   []string    `json:"changed,omitempty"`
+	Name      string      `json:"name"`
+	Author    string      `json:"author"`
+	CreatedAt json.Number `json:"created_at"`
+	UpdatedAt json.Number `json:"updated_at,omitempty"`
+	Body      string      `json:"body,omitempty"`
+	Format    string      `json:"format,omitempty"`
+	Tags      []string    `json:"tags,omitempty"`
+	DeletedAt int64       `json:"deleted_at,omitempty"`
+}
+
+//encodeRevision returns a revisions list entry of rev.
+func encodeRevision(rev *Revision) (string, error) {
+	post := rev.Post
+	stored := storedRevision{
+		Number:    rev.Number,
+		Action:    rev.Action,
+		Editor:    rev.Editor,
+		RevisedAt: json.Number(formatUnix(rev.CreatedAt)),
+		Changed:   rev.Changed,
+		Name:      post.Name,
+		Author:    post.Author,
+		CreatedAt: json.Number(formatUnix(post.CreatedAt)),
+		UpdatedAt: json.Number(formatUnix(updateTime(post))),
+		Body:      post.Body,
+		Format:    post.Format,
+		Tags:      post.Tags,
+	}
+	if post.DeletedAt != nil {
+		stored.DeletedAt = post.DeletedAt.Unix()
+	}
+
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
+
+//decodeRevision parses a revisions list entry of a post written by encodeRevision.
+func decodeRevision(id int64, entry string) (*Revision, error) {
+	var stored storedRevision
+	if err := json.Unmarshal([]byte(entry), &stored); err != nil {
+		return nil, fmt.Errorf("revision of post %v: %w", id, err)
+	}
+
+	//Revisions written before updated_at was introduced don't have it.
+	if stored.UpdatedAt == "" {
+		stored.UpdatedAt = stored.CreatedAt
+	}
+
+	var times [3]time.Time
+	for i, v := range []json.Number{stored.RevisedAt, stored.CreatedAt, stored.UpdatedAt} {
+		t, err := parseUnix(v.String())
+		if err != nil {
+			return nil, fmt.Errorf("revision of post %v: %w", id, err)
+		}
+		times[i] = t
+	}
+
+	post := &Post{
+		ID:        id,
+		Name:      stored.Name,
+		Author:    stored.Author,
+		CreatedAt: times[1],
+		UpdatedAt: times[2],
+		Body:      stored.Body,
+		Format:    stored.Format,
+		Tags:      stored.Tags,
+		Version:   stored.Number,
+	}
+	if stored.DeletedAt != 0 {
+		deletedAt := time.Unix(stored.DeletedAt, 0)
+		post.DeletedAt = &deletedAt
+	}
+
+	return &Revision{
+		Number:    stored.Number,
+		Action:    stored.Action,
+		Editor:    stored.Editor,
+		CreatedAt: times[0],
+		Changed:   stored.Changed,
+		Post:      post,
+	}, nil
+}
@@ -0,0 +1,1858 @@
+package post
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+var errFail = errors.New("fail")
+
+//errOOM is an error reply of a Redis command, it fails the command rather than the connection.
+var errOOM error = replyError("OOM command not allowed when used memory > 'maxmemory'")
+
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+
+func (replyError) RedisError() {}
+
+func TestToPost(t *testing.T) {
+	tests := []map[string]string{
+		{
+			"name":       "test 1",
+			"author":     "vt",
+			"created_at": "12345678",
+		},
+		{
+			"name":       "test 2",
+			"author":     "robot",
+			"created_at": "0.1",
+			"updated_at": "2.000000005",
+		},
+		{
+			"name":       "test 3",
+			"author":     "robot",
+			"created_at": "1e3",
+		},
+	}
+
+	post, err := toPost(1, tests[0])
+	if assert.NoError(t, err) {
+		unix, _ := strconv.ParseInt(tests[0]["created_at"], 10, 64)
+		ts := time.Unix(unix, 0)
+
+		assert.Equal(t, post.Author, tests[0]["author"])
+		assert.Equal(t, post.Name, tests[0]["name"])
+		assert.Equal(t, post.CreatedAt, ts)
+		assert.Equal(t, post.UpdatedAt, ts)
+	}
+
+	//Times of posts are stored with fractions of a second, so 0.1 is no longer a parse error.
+	post, err = toPost(2, tests[1])
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Unix(0, 100000000), post.CreatedAt)
+		assert.Equal(t, time.Unix(2, 5), post.UpdatedAt)
+	}
+
+	_, err = toPost(3, tests[2])
+	if assert.Error(t, err) {
+		var numErr *strconv.NumError
+		assert.ErrorAs(t, err, &numErr)
+	}
+
+	//Creation and update times have fractions, deletion times are whole seconds.
+	invalid := []struct {
+		name string
+		hash map[string]string
+	}{
+		{name: "Missing created_at.", hash: map[string]string{"name": "test", "author": "vt"}},
+		{name: "Empty fraction.", hash: map[string]string{"created_at": "1."}},
+		{name: "Fraction isn't a number.", hash: map[string]string{"created_at": "1.x"}},
+		{name: "Fraction is longer than nanoseconds.", hash: map[string]string{"created_at": "1.0000000001"}},
+		{name: "Invalid updated_at.", hash: map[string]string{"created_at": "1", "updated_at": "bad"}},
+		{name: "Invalid version.", hash: map[string]string{"created_at": "1", "version": "1.5"}},
+		{name: "Fractional deleted_at.", hash: map[string]string{"created_at": "1", "deleted_at": "0.1"}},
+	}
+
+	for _, test := range invalid {
+		post, err := toPost(4, test.hash)
+		assert.Nil(t, post, test.name)
+
+		var numErr *strconv.NumError
+		assert.ErrorAs(t, err, &numErr, test.name)
+	}
+}
+
+func TestCount(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client)
+
+	tests := []struct {
+		name     string
+		filter   *CountFilter
+		expected []AuthorCount
+		total    int64
+		err      error
+		mock     func()
+	}{
+		{
+			name:     "Most posts first.",
+			filter:   &CountFilter{},
+			expected: []AuthorCount{{Author: "robot", Count: 3}, {Author: "vt", Count: 2}},
+			total:    5,
+			mock: func() {
+				mock.ExpectZCard("timeline").SetVal(5)
+				mock.ExpectZRevRangeWithScores("author_counts", 0, -1).SetVal([]redis.Z{
+					{Score: 3, Member: "robot"},
+					{Score: 2, Member: "vt"},
+				})
+			},
+		},
+		{
+			name:     "Fewest posts first.",
+			filter:   &CountFilter{Order: Ascending, Limit: 1},
+			expected: []AuthorCount{{Author: "vt", Count: 2}},
+			total:    5,
+			mock: func() {
+				mock.ExpectZCard("timeline").SetVal(5)
+				mock.ExpectZRangeWithScores("author_counts", 0, 0).SetVal([]redis.Z{{Score: 2, Member: "vt"}})
+			},
+		},
+		{
+			name:     "Single author.",
+			filter:   &CountFilter{Author: "vt"},
+			expected: []AuthorCount{{Author: "vt", Count: 2}},
+			total:    2,
+			mock: func() {
+				mock.ExpectZScore("author_counts", "vt").SetVal(2)
+			},
+		},
+		{
+			name:     "Unknown author.",
+			filter:   &CountFilter{Author: "nobody"},
+			expected: []AuthorCount{},
+			mock: func() {
+				mock.ExpectZScore("author_counts", "nobody").RedisNil()
+			},
+		},
+		{
+			name:     "Author of any case.",
+			filter:   &CountFilter{Author: "VT", Match: MatchCaseInsensitive},
+			expected: []AuthorCount{{Author: "VT", Count: 2}},
+			total:    2,
+			mock: func() {
+				mock.ExpectSCard("ci:authors:vt").SetVal(2)
+			},
+		},
+		{
+			name:   "Database error.",
+			filter: &CountFilter{},
+			err:    errFail,
+			mock: func() {
+				mock.ExpectZCard("timeline").SetErr(errFail)
+				mock.ExpectZRevRangeWithScores("author_counts", 0, -1).SetErr(errFail)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		count, total, err := rr.Count(context.Background(), test.filter)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, count, test.name)
+			assert.Equal(t, test.total, total, test.name)
+		}
+
+		mock.ClearExpect()
+	}
+}
+
+func TestTags(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client)
+
+	tests := []struct {
+		name     string
+		filter   *TagFilter
+		expected []TagCount
+		err      error
+		mock     func()
+	}{
+		{
+			name:     "Most posts first.",
+			filter:   &TagFilter{},
+			expected: []TagCount{{Tag: "go", Count: 3}, {Tag: "sql", Count: 1}},
+			mock: func() {
+				mock.ExpectZRevRangeWithScores("tag_counts", 0, -1).SetVal([]redis.Z{
+					{Score: 3, Member: "go"},
+					{Score: 1, Member: "sql"},
+				})
+			},
+		},
+		{
+			name:     "Fewest posts first.",
+			filter:   &TagFilter{Order: Ascending, Limit: 1},
+			expected: []TagCount{{Tag: "sql", Count: 1}},
+			mock: func() {
+				mock.ExpectZRangeWithScores("tag_counts", 0, 0).SetVal([]redis.Z{{Score: 1, Member: "sql"}})
+			},
+		},
+		{
+			name:   "Relevance order.",
+			filter: &TagFilter{Order: Relevance},
+			err:    ErrInvalid,
+			mock:   func() {},
+		},
+		{
+			name:   "Database error.",
+			filter: &TagFilter{},
+			err:    errFail,
+			mock: func() {
+				mock.ExpectZRevRangeWithScores("tag_counts", 0, -1).SetErr(errFail)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		tags, err := rr.Tags(context.Background(), test.filter)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, tags, test.name)
+		}
+
+		mock.ClearExpect()
+	}
+}
+
+func TestCreate(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := redisRepository{client, func() time.Time { return time.Unix(100, 0) }}
+
+	keys := func(id int64, name string, terms ...string) []string {
+		keys := []string{"next_post_id", fmt.Sprintf("post:%v", id), fmt.Sprintf("revisions:%v", id), "names:" + name, "authors:vt", "author_counts", "tag_counts",
+			"timeline", "timeline:names:" + name, "timeline:authors:vt", "ci:names:" + name, "ci:authors:vt", "lex:names", "lex:authors"}
+		for _, t := range terms {
+			keys = append(keys, "terms:"+t)
+		}
+
+		return keys
+	}
+	tests := []struct {
+		name     string
+		expected int64
+		err      error
+		post     *Post
+		mock     func()
+	}{
+		{
+			name:     "Success.",
+			expected: 1,
+			post:     &Post{Name: "test 1", Author: "vt", CreatedAt: time.Unix(1, 500000000), Body: "*hi*", Format: FormatMarkdown, Tags: []string{"go", "redis"}},
+			mock: func() {
+				mock.ExpectIncr("next_post_id").SetVal(1)
+				keys := append(keys(1, "test 1", "test", "1"), "tags:go", "tags:redis")
+				mock.ExpectEvalSha(createScript.Hash(), keys, int64(1), "test 1", "vt", "1.5", "1.5", "*hi*", "markdown", "go,redis",
+					`{"number":1,"action":"create","editor":"vt","revised_at":100,"changed":["name","author","created_at","body","format","tags"],"name":"test 1","author":"vt","created_at":1.5,"updated_at":1.5,"body":"*hi*","format":"markdown","tags":["go","redis"]}`,
+					1.5, "test 1", "vt", 2, 1, 1, "go", "redis").SetVal(int64(1))
+			},
+		},
+		{
+			name:     "Name without terms.",
+			expected: 2,
+			post:     &Post{Name: "the", Author: "vt", CreatedAt: time.Unix(1, 0)},
+			mock: func() {
+				mock.ExpectIncr("next_post_id").SetVal(2)
+				mock.ExpectEvalSha(createScript.Hash(), keys(2, "the"), int64(2), "the", "vt", "1", "1", "", "", "",
+					`{"number":1,"action":"create","editor":"vt","revised_at":100,"changed":["name","author","created_at"],"name":"the","author":"vt","created_at":1,"updated_at":1}`,
+					float64(1), "the", "vt", 0).SetVal(int64(2))
+			},
+		},
+		{
+			name: "Database error.",
+			err:  errFail,
+			post: &Post{Name: "test 1", Author: "vt", CreatedAt: time.Unix(1, 0)},
+			mock: func() {
+				mock.ExpectIncr("next_post_id").SetVal(3)
+				mock.ExpectEvalSha(createScript.Hash(), keys(3, "test 1", "test", "1"), int64(3), "test 1", "vt", "1", "1", "", "", "",
+					`{"number":1,"action":"create","editor":"vt","revised_at":100,"changed":["name","author","created_at"],"name":"test 1","author":"vt","created_at":1,"updated_at":1}`,
+					float64(1), "test 1", "vt", 2, 1, 1).SetErr(errFail)
+			},
+		},
+		{
+			name: "ID reservation error.",
+			err:  errFail,
+			post: &Post{Name: "test 1", Author: "vt", CreatedAt: time.Unix(1, 0)},
+			mock: func() {
+				mock.ExpectIncr("next_post_id").SetErr(errFail)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		id, err := rr.Create(context.Background(), test.post)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else {
+			if assert.NoError(t, err, test.name) {
+				assert.Equal(t, test.expected, id, test.name)
+			}
+		}
+
+		mock.ClearExpect()
+	}
+}
+
+func TestFindOne(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client)
+
+	tests := []struct {
+		expected *Post
+		id       int64
+		err      error
+		mock     func()
+	}{
+		{
+			expected: &Post{
+				ID:        1,
+				Name:      "test 1",
+				Author:    "vt",
+				CreatedAt: time.Unix(1, 0),
+				UpdatedAt: time.Unix(2, 500000000),
+			},
+			id: 1,
+			mock: func() {
+				mock.ExpectExists("post:1").SetVal(1)
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "test 1",
+					"author":     "vt",
+					"created_at": "1",
+					"updated_at": "2.5",
+				})
+			},
+		},
+		{
+			expected: nil,
+			err:      ErrNotFound,
+			id:       2,
+			mock: func() {
+				mock.ExpectExists("post:2").SetVal(0)
+			},
+		},
+		{
+			expected: nil,
+			err:      errFail,
+			id:       3,
+			mock: func() {
+				mock.ExpectExists("post:3").SetVal(1)
+				mock.ExpectHGetAll("post:3").SetErr(errFail)
+			},
+		},
+		{
+			expected: nil,
+			err:      ErrUnavailable,
+			id:       4,
+			mock: func() {
+				mock.ExpectExists("post:4").SetErr(io.EOF)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		post, err := rr.FindOne(context.Background(), test.id)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err)
+		} else {
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.expected, post)
+			}
+		}
+
+		mock.ClearExpect()
+	}
+}
+
+func TestFindByIDs(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client)
+
+	tests := []struct {
+		name     string
+		expected []*Post
+		missing  []int64
+		err      error
+		mock     func()
+	}{
+		{
+			name:     "Found in request order.",
+			expected: []*Post{{ID: 3, Name: "test 3", Author: "vt", CreatedAt: time.Unix(3, 0), UpdatedAt: time.Unix(3, 0)}, {ID: 1, Name: "test 1", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0)}},
+			missing:  []int64{2, 4},
+			mock: func() {
+				mock.ExpectHGetAll("post:3").SetVal(map[string]string{"name": "test 3", "author": "vt", "created_at": "3"})
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{"name": "test 2", "author": "vt", "created_at": "2", "deleted_at": "5"})
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{"name": "test 1", "author": "vt", "created_at": "1"})
+				mock.ExpectHGetAll("post:4").SetVal(map[string]string{})
+			},
+		},
+		{
+			name: "Database error.",
+			err:  errFail,
+			mock: func() {
+				mock.ExpectHGetAll("post:3").SetErr(errFail)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		posts, missing, err := rr.FindByIDs(context.Background(), []int64{3, 2, 1, 4})
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, posts, test.name)
+			assert.Equal(t, test.missing, missing, test.name)
+		}
+
+		mock.ClearExpect()
+	}
+}
+
+func TestRemove(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := redisRepository{client, func() time.Time { return time.Unix(100, 0) }}
+
+	stored := map[string]string{
+		"name":       "test 1",
+		"author":     "vt",
+		"created_at": "1",
+		"tags":       "go",
+		"version":    "3",
+	}
+	removed := `{"number":4,"action":"remove","editor":"vt","revised_at":100,"changed":["deleted_at"],"name":"test 1","author":"vt","created_at":1,"updated_at":1,"tags":["go"],"deleted_at":100}`
+	keys := []string{"post:1", "names:test 1", "authors:vt", "author_counts", "tag_counts", "trash", "revisions:1", "ci:names:test 1", "ci:authors:vt", "lex:names", "lex:authors", "timeline", "timeline:names:test 1", "timeline:authors:vt", "terms:test", "terms:1", "tags:go"}
+
+	tests := []struct {
+		name     string
+		expected bool
+		id       int64
+		version  int64
+		err      error
+		mock     func()
+	}{
+		{
+			name:     "Unconditional remove. Success.",
+			expected: true,
+			id:       1,
+			version:  AnyVersion,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(stored)
+				mock.ExpectEvalSha(removeScript.Hash(), keys, int64(1), int64(3), "vt", int64(100), removed, "test 1", "vt", 2, "go").SetVal(int64(1))
+			},
+		},
+		{
+			name:     "Matching version. Success.",
+			expected: true,
+			id:       1,
+			version:  3,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(stored)
+				mock.ExpectEvalSha(removeScript.Hash(), keys, int64(1), int64(3), "vt", int64(100), removed, "test 1", "vt", 2, "go").SetVal(int64(1))
+			},
+		},
+		{
+			name:     "Changed before the script ran. Retried.",
+			expected: true,
+			id:       1,
+			version:  AnyVersion,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "test 1",
+					"author":     "vt",
+					"created_at": "1",
+					"tags":       "go",
+					"version":    "2",
+				})
+				mock.ExpectEvalSha(removeScript.Hash(), keys, int64(1), int64(2), "vt", int64(100), strings.Replace(removed, `"number":4`, `"number":3`, 1), "test 1", "vt", 2, "go").SetVal(int64(-1))
+				mock.ExpectHGetAll("post:1").SetVal(stored)
+				mock.ExpectEvalSha(removeScript.Hash(), keys, int64(1), int64(3), "vt", int64(100), removed, "test 1", "vt", 2, "go").SetVal(int64(1))
+			},
+		},
+		{
+			name:    "Removed concurrently.",
+			id:      1,
+			version: AnyVersion,
+			err:     ErrNotFound,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(stored)
+				mock.ExpectEvalSha(removeScript.Hash(), keys, int64(1), int64(3), "vt", int64(100), removed, "test 1", "vt", 2, "go").SetVal(int64(0))
+			},
+		},
+		{
+			name:    "Already in the trash.",
+			id:      1,
+			version: AnyVersion,
+			err:     ErrNotFound,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "test 1",
+					"author":     "vt",
+					"created_at": "1",
+					"deleted_at": "50",
+					"version":    "4",
+				})
+			},
+		},
+		{
+			name:    "Changed on every try.",
+			id:      1,
+			version: AnyVersion,
+			err:     ErrConflict,
+			mock: func() {
+				for i := 0; i < maxTxRetries; i++ {
+					mock.ExpectHGetAll("post:1").SetVal(stored)
+					mock.ExpectEvalSha(removeScript.Hash(), keys, int64(1), int64(3), "vt", int64(100), removed, "test 1", "vt", 2, "go").SetVal(int64(-1))
+				}
+			},
+		},
+		{
+			name:    "Stale version.",
+			id:      1,
+			version: 2,
+			err:     ErrVersionMismatch,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(stored)
+			},
+		},
+		{
+			name:    "Database error.",
+			id:      1,
+			version: AnyVersion,
+			err:     errFail,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetErr(errFail)
+			},
+		},
+		{
+			name:    "Script error.",
+			id:      1,
+			version: AnyVersion,
+			err:     errFail,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(stored)
+				mock.ExpectEvalSha(removeScript.Hash(), keys, int64(1), int64(3), "vt", int64(100), removed, "test 1", "vt", 2, "go").SetErr(errFail)
+			},
+		},
+		{
+			name:    "Post doesn't exist.",
+			id:      2,
+			version: AnyVersion,
+			err:     ErrNotFound,
+			mock: func() {
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		removed, err := rr.Remove(context.Background(), test.id, test.version)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else {
+			if assert.NoError(t, err, test.name) {
+				assert.Equal(t, test.expected, removed, test.name)
+			}
+		}
+
+		assert.NoError(t, mock.ExpectationsWereMet(), test.name)
+		mock.ClearExpect()
+	}
+}
+
+func TestCreateMany(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := redisRepository{client, func() time.Time { return time.Unix(100, 0) }}
+
+	posts := []*Post{{Name: "the", Author: "vt", CreatedAt: time.Unix(1, 0)}, {Name: "a", Author: "vt", CreatedAt: time.Unix(2, 0)}}
+	keys := func(id int64, name string) []string {
+		return []string{"next_post_id", fmt.Sprintf("post:%v", id), fmt.Sprintf("revisions:%v", id), "names:" + name, "authors:vt", "author_counts", "tag_counts",
+			"timeline", "timeline:names:" + name, "timeline:authors:vt", "ci:names:" + name, "ci:authors:vt", "lex:names", "lex:authors"}
+	}
+	expectCreate := func(id int64, name string, createdAt int64) *redismock.ExpectedCmd {
+		rev := fmt.Sprintf(`{"number":1,"action":"create","editor":"vt","revised_at":100,"changed":["name","author","created_at"],"name":"%v","author":"vt","created_at":%v,"updated_at":%v}`, name, createdAt, createdAt)
+		return mock.ExpectEvalSha(createScript.Hash(), keys(id, name), id, name, "vt", strconv.FormatInt(createdAt, 10), strconv.FormatInt(createdAt, 10), "", "", "", rev, float64(createdAt), name, "vt", 0)
+	}
+
+	tests := []struct {
+		name     string
+		expected []BatchResult
+		atomic   bool
+		err      error
+		mock     func()
+	}{
+		{
+			name:     "IDs are reserved. Success.",
+			expected: []BatchResult{{ID: 5}, {ID: 6}},
+			mock: func() {
+				mock.ExpectIncrBy("next_post_id", 2).SetVal(6)
+				mock.ExpectScriptExists(createScript.Hash()).SetVal([]bool{true})
+				expectCreate(5, "the", 1).SetVal(int64(5))
+				expectCreate(6, "a", 2).SetVal(int64(6))
+			},
+		},
+		{
+			name:     "Pipeline error.",
+			expected: []BatchResult{{Err: errFail}, {Err: errFail}},
+			mock: func() {
+				mock.ExpectIncrBy("next_post_id", 2).SetVal(6)
+				mock.ExpectScriptExists(createScript.Hash()).SetVal([]bool{true})
+				expectCreate(5, "the", 1).SetErr(errFail)
+			},
+		},
+		{
+			name:     "Atomic. Script error fails the batch.",
+			expected: []BatchResult{{Err: errOOM}, {Err: errOOM}},
+			atomic:   true,
+			mock: func() {
+				mock.ExpectIncrBy("next_post_id", 2).SetVal(6)
+				mock.ExpectScriptExists(createScript.Hash()).SetVal([]bool{true})
+				mock.ExpectTxPipeline()
+				expectCreate(5, "the", 1).SetErr(errOOM)
+			},
+		},
+		{
+			name:     "Atomic. Success.",
+			expected: []BatchResult{{ID: 5}, {ID: 6}},
+			atomic:   true,
+			mock: func() {
+				mock.ExpectIncrBy("next_post_id", 2).SetVal(6)
+				mock.ExpectScriptExists(createScript.Hash()).SetVal([]bool{true})
+				mock.ExpectTxPipeline()
+				expectCreate(5, "the", 1).SetVal(int64(5))
+				expectCreate(6, "a", 2).SetVal(int64(6))
+				mock.ExpectTxPipelineExec()
+			},
+		},
+		{
+			name: "Database error.",
+			err:  errFail,
+			mock: func() {
+				mock.ExpectIncrBy("next_post_id", 2).SetErr(errFail)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		results, err := rr.CreateMany(context.Background(), posts, test.atomic)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else if assert.NoError(t, err, test.name) && assert.Len(t, results, len(test.expected), test.name) {
+			for i, res := range results {
+				assert.Equal(t, test.expected[i].ID, res.ID, test.name)
+				assert.ErrorIs(t, res.Err, test.expected[i].Err, test.name)
+			}
+		}
+
+		assert.NoError(t, mock.ExpectationsWereMet(), test.name)
+		mock.ClearExpect()
+	}
+}
+
+func TestPipelineFailed(t *testing.T) {
+	assert.False(t, pipelineFailed(nil, false))
+	assert.False(t, pipelineFailed(nil, true))
+	//A command error doesn't fail the other commands of a pipeline.
+	assert.False(t, pipelineFailed(errOOM, false))
+	assert.True(t, pipelineFailed(errOOM, true))
+	assert.True(t, pipelineFailed(errFail, false))
+	assert.True(t, pipelineFailed(errFail, true))
+}
+
+func TestImport(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := redisRepository{client, func() time.Time { return time.Unix(100, 0) }}
+
+	deletedAt := time.Unix(5, 0)
+	created := &Revision{Number: 1, Action: ActionCreate, Editor: "vt", CreatedAt: time.Unix(3, 0), Changed: []string{"name", "author", "created_at"},
+		Post: &Post{Name: "gone", Author: "vt", CreatedAt: time.Unix(3, 0)}}
+	removed := &Revision{Number: 2, Action: ActionRemove, Editor: "vt", CreatedAt: time.Unix(5, 0), Changed: []string{"deleted_at"},
+		Post: &Post{Name: "gone", Author: "vt", CreatedAt: time.Unix(3, 0), DeletedAt: &deletedAt}}
+	records := []*Record{
+		{ID: 7, Post: &Post{Name: "the", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(2, 0)}},
+		{ID: 3, Post: &Post{Name: "a", Author: "vt", CreatedAt: time.Unix(2, 0)}},
+		{ID: 9, Post: &Post{Name: "gone", Author: "vt", CreatedAt: time.Unix(3, 0), DeletedAt: &deletedAt}, Revisions: []*Revision{created, removed}},
+		{ID: 8, Revisions: []*Revision{created}},
+	}
+	keys := func(id int64, name string) []string {
+		return []string{"next_post_id", fmt.Sprintf("post:%v", id), fmt.Sprintf("revisions:%v", id), "names:" + name, "authors:vt", "author_counts", "tag_counts",
+			"timeline", "timeline:names:" + name, "timeline:authors:vt", "ci:names:" + name, "ci:authors:vt", "lex:names", "lex:authors"}
+	}
+	createdEntry := `{"number":1,"action":"create","editor":"vt","revised_at":3,"changed":["name","author","created_at"],"name":"gone","author":"vt","created_at":3,"updated_at":3}`
+	removedEntry := `{"number":2,"action":"remove","editor":"vt","revised_at":5,"changed":["deleted_at"],"name":"gone","author":"vt","created_at":3,"updated_at":3,"deleted_at":5}`
+
+	mock.ExpectScriptExists(createScript.Hash()).SetVal([]bool{true})
+	mock.ExpectScriptExists(importRemovedScript.Hash()).SetVal([]bool{true})
+	mock.ExpectEvalSha(createScript.Hash(), keys(7, "the"), int64(7), "the", "vt", "1", "2", "", "", "",
+		`{"number":1,"action":"create","editor":"vt","revised_at":100,"changed":["name","author","created_at"],"name":"the","author":"vt","created_at":1,"updated_at":2}`,
+		float64(1), "the", "vt", 0).SetVal(int64(7))
+	mock.ExpectEvalSha(createScript.Hash(), keys(3, "a"), int64(3), "a", "vt", "2", "2", "", "", "",
+		`{"number":1,"action":"create","editor":"vt","revised_at":100,"changed":["name","author","created_at"],"name":"a","author":"vt","created_at":2,"updated_at":2}`,
+		float64(2), "a", "vt", 0).SetVal(int64(0))
+	mock.ExpectEvalSha(importRemovedScript.Hash(), []string{"next_post_id", "trash", "post:9", "revisions:9"}, int64(9), int64(5), 9,
+		"name", "gone", "author", "vt", "created_at", "3", "updated_at", "3", "body", "", "format", "", "tags", "", "deleted_at", int64(5), "version", int64(2),
+		createdEntry, removedEntry).SetVal(int64(9))
+	mock.ExpectEvalSha(importRemovedScript.Hash(), []string{"next_post_id", "trash", "post:8", "revisions:8"}, int64(8), "", 0, createdEntry).SetVal(int64(-1))
+
+	results, err := rr.Import(context.Background(), records)
+	if assert.NoError(t, err) && assert.Len(t, results, 4) {
+		assert.Equal(t, BatchResult{ID: 7}, results[0])
+		assert.ErrorIs(t, results[1].Err, ErrConflict)
+		assert.Equal(t, BatchResult{ID: 9}, results[2])
+		assert.ErrorIs(t, results[3].Err, ErrConflict)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	//A history is written by createScript as entries separated by line breaks.
+	records = []*Record{{ID: 9, Post: &Post{Name: "gone", Author: "vt", CreatedAt: time.Unix(3, 0)}, Revisions: []*Revision{created, created}}}
+	mock.ExpectScriptExists(createScript.Hash()).SetVal([]bool{true})
+	mock.ExpectEvalSha(createScript.Hash(), append(keys(9, "gone"), "terms:gone"), int64(9), "gone", "vt", "3", "3", "", "", "",
+		createdEntry+"\n"+createdEntry, float64(3), "gone", "vt", 1, 1).SetVal(int64(9))
+
+	results, err = rr.Import(context.Background(), records)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []BatchResult{{ID: 9}}, results)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExport(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client)
+
+	mock.ExpectScan(0, "post:*", 100).SetVal([]string{"post:1", "post:2"}, 5)
+	mock.ExpectHGetAll("post:1").SetVal(map[string]string{"name": "test 1", "author": "vt", "created_at": "1"})
+	mock.ExpectHGetAll("post:2").SetVal(map[string]string{"name": "test 2", "author": "vt", "created_at": "2", "deleted_at": "3"})
+	mock.ExpectScan(5, "post:*", 100).SetVal([]string{"post:1", "post:3"}, 0)
+	mock.ExpectHGetAll("post:3").SetVal(map[string]string{"name": "test 3", "author": "vt", "created_at": "3"})
+
+	var exported []*Record
+	err := rr.Export(context.Background(), false, func(rec *Record) error {
+		exported = append(exported, rec)
+		return nil
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*Record{
+			{ID: 1, Post: &Post{ID: 1, Name: "test 1", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0)}},
+			{ID: 3, Post: &Post{ID: 3, Name: "test 3", Author: "vt", CreatedAt: time.Unix(3, 0), UpdatedAt: time.Unix(3, 0)}},
+		}, exported)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectScan(0, "post:*", 100).SetErr(errFail)
+	err = rr.Export(context.Background(), false, func(*Record) error { return nil })
+	assert.ErrorIs(t, err, errFail)
+	mock.ClearExpect()
+
+	//A full export includes trashed posts, histories and purged posts found by their revision lists.
+	created := `{"number":1,"action":"create","editor":"vt","revised_at":1,"name":"test 1","author":"vt","created_at":1}`
+	mock.ExpectScan(0, "post:*", 100).SetVal([]string{"post:1", "post:2"}, 0)
+	mock.ExpectHGetAll("post:1").SetVal(map[string]string{"name": "test 1", "author": "vt", "created_at": "1", "version": "1"})
+	mock.ExpectLRange("revisions:1", 0, -1).SetVal([]string{created})
+	mock.ExpectHGetAll("post:2").SetVal(map[string]string{"name": "test 2", "author": "vt", "created_at": "2", "deleted_at": "3"})
+	mock.ExpectLRange("revisions:2", 0, -1).SetVal([]string{})
+	mock.ExpectScan(0, "revisions:*", 100).SetVal([]string{"revisions:1", "revisions:4"}, 0)
+	mock.ExpectHGetAll("post:4").SetVal(map[string]string{})
+	mock.ExpectLRange("revisions:4", 0, -1).SetVal([]string{created})
+
+	exported = nil
+	err = rr.Export(context.Background(), true, func(rec *Record) error {
+		exported = append(exported, rec)
+		return nil
+	})
+	if assert.NoError(t, err) && assert.Len(t, exported, 3) {
+		deletedAt := time.Unix(3, 0)
+		assert.Equal(t, &Post{ID: 1, Name: "test 1", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Version: 1}, exported[0].Post)
+		if assert.Len(t, exported[0].Revisions, 1) {
+			assert.Equal(t, ActionCreate, exported[0].Revisions[0].Action)
+		}
+		assert.Equal(t, &Post{ID: 2, Name: "test 2", Author: "vt", CreatedAt: time.Unix(2, 0), UpdatedAt: time.Unix(2, 0), DeletedAt: &deletedAt}, exported[1].Post)
+		assert.Empty(t, exported[1].Revisions)
+		assert.Equal(t, int64(4), exported[2].ID)
+		assert.Nil(t, exported[2].Post)
+		if assert.Len(t, exported[2].Revisions, 1) {
+			assert.Equal(t, int64(4), exported[2].Revisions[0].Post.ID)
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRemoveMany(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := redisRepository{client, func() time.Time { return time.Unix(100, 0) }}
+
+	stored := map[string]string{
+		"name":       "test 1",
+		"author":     "vt",
+		"created_at": "1",
+		"tags":       "go",
+		"version":    "3",
+	}
+	removed := `{"number":4,"action":"remove","editor":"vt","revised_at":100,"changed":["deleted_at"],"name":"test 1","author":"vt","created_at":1,"updated_at":1,"tags":["go"],"deleted_at":100}`
+	keys := []string{"post:1", "names:test 1", "authors:vt", "author_counts", "tag_counts", "trash", "revisions:1", "ci:names:test 1", "ci:authors:vt", "lex:names", "lex:authors", "timeline", "timeline:names:test 1", "timeline:authors:vt", "terms:test", "terms:1", "tags:go"}
+	expectRemove := func() *redismock.ExpectedCmd {
+		return mock.ExpectEvalSha(removeScript.Hash(), keys, int64(1), int64(3), "vt", int64(100), removed, "test 1", "vt", 2, "go")
+	}
+
+	tests := []struct {
+		name     string
+		expected []BatchResult
+		atomic   bool
+		err      error
+		mock     func()
+	}{
+		{
+			name:     "Missing post. Partial failure.",
+			expected: []BatchResult{{ID: 1}, {Err: ErrNotFound}},
+			mock: func() {
+				mock.ExpectScriptExists(removeScript.Hash()).SetVal([]bool{true})
+				mock.ExpectHGetAll("post:1").SetVal(stored)
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{})
+				expectRemove().SetVal(int64(1))
+			},
+		},
+		{
+			name:     "Changed after it was read. Partial failure.",
+			expected: []BatchResult{{Err: ErrConflict}, {Err: ErrNotFound}},
+			mock: func() {
+				mock.ExpectScriptExists(removeScript.Hash()).SetVal([]bool{true})
+				mock.ExpectHGetAll("post:1").SetVal(stored)
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{"name": "test 2", "deleted_at": "50"})
+				expectRemove().SetVal(int64(-1))
+			},
+		},
+		{
+			name:     "Atomic. Missing post aborts the batch.",
+			expected: []BatchResult{{Err: ErrBatchAborted}, {Err: ErrNotFound}},
+			atomic:   true,
+			mock: func() {
+				mock.ExpectScriptExists(removeScript.Hash()).SetVal([]bool{true})
+				mock.ExpectWatch("post:1", "post:2")
+				mock.ExpectHGetAll("post:1").SetVal(stored)
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{})
+			},
+		},
+		{
+			name:     "Database error.",
+			expected: []BatchResult{{Err: errFail}, {Err: errFail}},
+			mock: func() {
+				mock.ExpectScriptExists(removeScript.Hash()).SetVal([]bool{true})
+				mock.ExpectHGetAll("post:1").SetErr(errFail)
+			},
+		},
+		{
+			name: "Script check error.",
+			err:  errFail,
+			mock: func() {
+				mock.ExpectScriptExists(removeScript.Hash()).SetErr(errFail)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		results, err := rr.RemoveMany(context.Background(), []int64{1, 2}, test.atomic)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else if assert.NoError(t, err, test.name) && assert.Len(t, results, len(test.expected), test.name) {
+			for i, res := range results {
+				assert.Equal(t, test.expected[i].ID, res.ID, test.name)
+				assert.ErrorIs(t, res.Err, test.expected[i].Err, test.name)
+			}
+		}
+
+		assert.NoError(t, mock.ExpectationsWereMet(), test.name)
+		mock.ClearExpect()
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := redisRepository{client, func() time.Time { return time.Unix(100, 0) }}
+
+	tests := []struct {
+		name     string
+		expected *Post
+		id       int64
+		post     *Post
+		version  int64
+		editor   string
+		err      bool
+		mock     func()
+	}{
+		{
+			name:     "Rename and change author, creation time is kept. Success.",
+			expected: &Post{ID: 1, Name: "new", Author: "robot", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(100, 0), Version: 1},
+			id:       1,
+			post:     &Post{Name: "new", Author: "robot", CreatedAt: time.Unix(2, 0)},
+			version:  AnyVersion,
+			mock: func() {
+				mock.ExpectWatch("post:1")
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "old",
+					"author":     "vt",
+					"created_at": "1",
+				})
+				mock.ExpectTxPipeline()
+				mock.ExpectHSet("post:1", "name", "new", "author", "robot", "updated_at", "100", "body", "", "format", "", "tags", "").SetVal(0)
+				mock.ExpectHIncrBy("post:1", "version", 1).SetVal(1)
+				mock.ExpectSRem("names:old", int64(1)).SetVal(1)
+				mock.ExpectSAdd("names:new", int64(1)).SetVal(1)
+				mock.ExpectZRem("timeline:names:old", int64(1)).SetVal(1)
+				mock.ExpectZAdd("timeline:names:new", &redis.Z{Score: 1, Member: int64(1)}).SetVal(1)
+				mock.ExpectZRem("terms:old", int64(1)).SetVal(1)
+				mock.ExpectZAdd("terms:new", &redis.Z{Score: 1, Member: int64(1)}).SetVal(1)
+				mock.ExpectSRem("ci:names:old", int64(1)).SetVal(1)
+				mock.ExpectSAdd("ci:names:new", int64(1)).SetVal(1)
+				mock.ExpectZAdd("lex:names", &redis.Z{Member: "new"}).SetVal(1)
+				mock.ExpectSRem("ci:authors:vt", int64(1)).SetVal(1)
+				mock.ExpectSAdd("ci:authors:robot", int64(1)).SetVal(1)
+				mock.ExpectZAdd("lex:authors", &redis.Z{Member: "robot"}).SetVal(1)
+				mock.ExpectSRem("authors:vt", int64(1)).SetVal(1)
+				mock.ExpectSAdd("authors:robot", int64(1)).SetVal(1)
+				mock.ExpectZRem("timeline:authors:vt", int64(1)).SetVal(1)
+				mock.ExpectZAdd("timeline:authors:robot", &redis.Z{Score: 1, Member: int64(1)}).SetVal(1)
+				mock.ExpectZIncrBy("author_counts", -1, "vt").SetVal(0)
+				mock.ExpectZIncrBy("author_counts", 1, "robot").SetVal(1)
+				mock.ExpectZRemRangeByScore("author_counts", "-inf", "0").SetVal(1)
+				mock.ExpectRPush("revisions:1", `{"number":1,"action":"update","editor":"robot","revised_at":100,"changed":["name","author"],"name":"new","author":"robot","created_at":1,"updated_at":100}`).SetVal(1)
+				mock.ExpectTxPipelineExec()
+				mock.ExpectEvalSha(pruneKeyScript.Hash(), []string{"ci:names:old", "lex:names"}, "old").SetVal(int64(1))
+				mock.ExpectEvalSha(pruneKeyScript.Hash(), []string{"ci:authors:vt", "lex:authors"}, "vt").SetVal(int64(0))
+			},
+		},
+		{
+			name:     "Change tags. Success.",
+			expected: &Post{ID: 1, Name: "old", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(100, 0), Tags: []string{"go", "sql"}, Version: 2},
+			id:       1,
+			post:     &Post{Name: "old", Author: "vt", CreatedAt: time.Unix(1, 0), Tags: []string{"go", "sql"}},
+			version:  AnyVersion,
+			mock: func() {
+				mock.ExpectWatch("post:1")
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "old",
+					"author":     "vt",
+					"created_at": "1",
+					"tags":       "go,redis",
+					"version":    "1",
+				})
+				mock.ExpectTxPipeline()
+				mock.ExpectHSet("post:1", "name", "old", "author", "vt", "updated_at", "100", "body", "", "format", "", "tags", "go,sql").SetVal(0)
+				mock.ExpectHIncrBy("post:1", "version", 1).SetVal(2)
+				mock.ExpectSRem("tags:redis", int64(1)).SetVal(1)
+				mock.ExpectZIncrBy("tag_counts", -1, "redis").SetVal(0)
+				mock.ExpectSAdd("tags:sql", int64(1)).SetVal(1)
+				mock.ExpectZIncrBy("tag_counts", 1, "sql").SetVal(1)
+				mock.ExpectZRemRangeByScore("tag_counts", "-inf", "0").SetVal(1)
+				mock.ExpectRPush("revisions:1", `{"number":2,"action":"update","editor":"vt","revised_at":100,"changed":["tags"],"name":"old","author":"vt","created_at":1,"updated_at":100,"tags":["go","sql"]}`).SetVal(2)
+				mock.ExpectTxPipelineExec()
+			},
+		},
+		{
+			name:     "Same name and author with matching version by another editor. Indexes untouched.",
+			expected: &Post{ID: 1, Name: "old", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(100, 0), Version: 5},
+			id:       1,
+			post:     &Post{Name: "old", Author: "vt", CreatedAt: time.Unix(2, 0)},
+			version:  4,
+			editor:   "admin",
+			mock: func() {
+				mock.ExpectWatch("post:1")
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "old",
+					"author":     "vt",
+					"created_at": "1",
+					"version":    "4",
+				})
+				mock.ExpectTxPipeline()
+				mock.ExpectHSet("post:1", "name", "old", "author", "vt", "updated_at", "100", "body", "", "format", "", "tags", "").SetVal(0)
+				mock.ExpectHIncrBy("post:1", "version", 1).SetVal(5)
+				mock.ExpectRPush("revisions:1", `{"number":5,"action":"update","editor":"admin","revised_at":100,"name":"old","author":"vt","created_at":1,"updated_at":100}`).SetVal(5)
+				mock.ExpectTxPipelineExec()
+			},
+		},
+		{
+			name:    "Stale version.",
+			id:      1,
+			post:    &Post{Name: "new", Author: "vt", CreatedAt: time.Unix(2, 0)},
+			version: 3,
+			err:     true,
+			mock: func() {
+				mock.ExpectWatch("post:1")
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "old",
+					"author":     "vt",
+					"created_at": "1",
+					"version":    "4",
+				})
+			},
+		},
+		{
+			name:    "Post doesn't exist.",
+			id:      2,
+			post:    &Post{Name: "new", Author: "robot", CreatedAt: time.Unix(2, 0)},
+			version: AnyVersion,
+			err:     true,
+			mock: func() {
+				mock.ExpectWatch("post:2")
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{})
+			},
+		},
+		{
+			name:    "Database error.",
+			id:      3,
+			post:    &Post{Name: "new", Author: "robot", CreatedAt: time.Unix(2, 0)},
+			version: AnyVersion,
+			err:     true,
+			mock: func() {
+				mock.ExpectWatch("post:3")
+				mock.ExpectHGetAll("post:3").SetErr(errors.New("fail"))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		ctx := context.Background()
+		if test.editor != "" {
+			ctx = WithEditor(ctx, test.editor)
+		}
+
+		post, err := rr.Update(ctx, test.id, test.post, test.version)
+		if test.err {
+			assert.Error(t, err, test.name)
+		} else {
+			if assert.NoError(t, err, test.name) {
+				assert.Equal(t, test.expected, post, test.name)
+			}
+		}
+
+		mock.ClearExpect()
+	}
+}
+
+func TestFindMany(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client)
+
+	all := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+	tests := []struct {
+		name         string
+		expected     []*Post
+		expectedNext string
+		filters      *SearchFilter
+		err          bool
+		mock         func()
+	}{
+		{
+			name: "Filter by names. Success.",
+			expected: []*Post{
+				{ID: 1, Name: "found", Author: "vt", CreatedAt: time.Unix(2, 0), UpdatedAt: time.Unix(2, 0)},
+				{ID: 2, Name: "found", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0)},
+			},
+			err:     false,
+			filters: &SearchFilter{Name: "found"},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline:names:found", all).SetVal([]redis.Z{
+					{Score: 2, Member: "1"},
+					{Score: 1, Member: "2"},
+				})
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "found",
+					"author":     "vt",
+					"created_at": "2",
+				})
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
+					"name":       "found",
+					"author":     "vt",
+					"created_at": "1",
+				})
+			},
+		},
+		{
+			name: "Filter by authors. Success.",
+			expected: []*Post{
+				{ID: 2, Name: "test1", Author: "vt", CreatedAt: time.Unix(2, 0), UpdatedAt: time.Unix(2, 0)},
+				{ID: 3, Name: "test2", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0)},
+			},
+			err:     false,
+			filters: &SearchFilter{Author: "vt"},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline:authors:vt", all).SetVal([]redis.Z{
+					{Score: 2, Member: "2"},
+					{Score: 1, Member: "3"},
+				})
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
+					"name":       "test1",
+					"author":     "vt",
+					"created_at": "2",
+				})
+				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
+					"name":       "test2",
+					"author":     "vt",
+					"created_at": "1",
+				})
+			},
+		},
+		{
+			name: "Filter by both. Success",
+			expected: []*Post{
+				{ID: 3, Name: "found", Author: "vt", CreatedAt: time.Unix(2, 0), UpdatedAt: time.Unix(2, 0)},
+			},
+			err:     false,
+			filters: &SearchFilter{Name: "found", Author: "vt"},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline:authors:vt", all).SetVal([]redis.Z{
+					{Score: 2, Member: "3"},
+					{Score: 1, Member: "4"},
+				})
+				mock.ExpectSIsMember("names:found", int64(3)).SetVal(true)
+				mock.ExpectSIsMember("names:found", int64(4)).SetVal(false)
+				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
+					"name":       "found",
+					"author":     "vt",
+					"created_at": "2",
+				})
+			},
+		},
+		{
+			name: "Filter by any tag. Success",
+			expected: []*Post{
+				{ID: 4, Name: "found", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Tags: []string{"sql"}},
+			},
+			err:     false,
+			filters: &SearchFilter{Tags: []string{"go", "sql"}, AnyTag: true},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline", all).SetVal([]redis.Z{
+					{Score: 2, Member: "3"},
+					{Score: 1, Member: "4"},
+				})
+				mock.ExpectSIsMember("tags:go", int64(3)).SetVal(false)
+				mock.ExpectSIsMember("tags:sql", int64(3)).SetVal(false)
+				mock.ExpectSIsMember("tags:go", int64(4)).SetVal(false)
+				mock.ExpectSIsMember("tags:sql", int64(4)).SetVal(true)
+				mock.ExpectHGetAll("post:4").SetVal(map[string]string{
+					"name":       "found",
+					"author":     "vt",
+					"created_at": "1",
+					"tags":       "sql",
+				})
+			},
+		},
+		{
+			name: "No filters. Success.",
+			expected: []*Post{
+				{ID: 1, Name: "test1", Author: "vt", CreatedAt: time.Unix(4, 0), UpdatedAt: time.Unix(4, 0)},
+				{ID: 2, Name: "test2", Author: "vt", CreatedAt: time.Unix(3, 0), UpdatedAt: time.Unix(3, 0)},
+			},
+			err:     false,
+			filters: &SearchFilter{},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline", all).SetVal([]redis.Z{
+					{Score: 4, Member: "1"},
+					{Score: 3, Member: "2"},
+				})
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "test1",
+					"author":     "vt",
+					"created_at": "4",
+				})
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
+					"name":       "test2",
+					"author":     "vt",
+					"created_at": "3",
+				})
+			},
+		},
+		{
+			name: "Filter by names. Ascending order.",
+			expected: []*Post{
+				{ID: 1, Name: "found", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0)},
+				{ID: 2, Name: "found", Author: "vt", CreatedAt: time.Unix(2, 0), UpdatedAt: time.Unix(2, 0)},
+			},
+			err:     false,
+			filters: &SearchFilter{Name: "found", Order: Ascending},
+			mock: func() {
+				mock.ExpectZRangeByScoreWithScores("timeline:names:found", all).SetVal([]redis.Z{
+					{Score: 1, Member: "1"},
+					{Score: 2, Member: "2"},
+				})
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "found",
+					"author":     "vt",
+					"created_at": "1",
+				})
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
+					"name":       "found",
+					"author":     "vt",
+					"created_at": "2",
+				})
+			},
+		},
+		{
+			name: "Filter by authors. First page.",
+			expected: []*Post{
+				{ID: 3, Name: "test3", Author: "vt", CreatedAt: time.Unix(2, 0), UpdatedAt: time.Unix(2, 0)},
+				{ID: 2, Name: "test2", Author: "vt", CreatedAt: time.Unix(2, 0), UpdatedAt: time.Unix(2, 0)},
+			},
+			expectedNext: cursor{order: Descending, createdAt: time.Unix(2, 0), id: 2}.encode(),
+			filters:      &SearchFilter{Author: "vt", Limit: 2},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline:authors:vt", &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: 3}).SetVal([]redis.Z{
+					{Score: 2, Member: "3"},
+					{Score: 2, Member: "2"},
+					{Score: 1, Member: "1"},
+				})
+				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
+					"name":       "test3",
+					"author":     "vt",
+					"created_at": "2",
+				})
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
+					"name":       "test2",
+					"author":     "vt",
+					"created_at": "2",
+				})
+			},
+		},
+		{
+			name: "Filter by authors. Last page.",
+			expected: []*Post{
+				{ID: 1, Name: "test1", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0)},
+			},
+			filters: &SearchFilter{Author: "vt", Limit: 2, Cursor: cursor{order: Descending, createdAt: time.Unix(2, 0), id: 2}.encode()},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline:authors:vt", &redis.ZRangeBy{Min: "-inf", Max: "2", Count: 3}).SetVal([]redis.Z{
+					{Score: 2, Member: "3"},
+					{Score: 2, Member: "2"},
+					{Score: 1, Member: "1"},
+				})
+				mock.ExpectZRevRangeByScoreWithScores("timeline:authors:vt", &redis.ZRangeBy{Min: "-inf", Max: "2", Offset: 3, Count: 3}).SetVal([]redis.Z{})
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "test1",
+					"author":     "vt",
+					"created_at": "1",
+				})
+			},
+		},
+		{
+			name: "Filter by authors within a time range.",
+			expected: []*Post{
+				{ID: 2, Name: "test2", Author: "vt", CreatedAt: time.Unix(20, 0), UpdatedAt: time.Unix(20, 0)},
+			},
+			filters: &SearchFilter{Author: "vt", CreatedAfter: time.Unix(10, 0), CreatedBefore: time.Unix(30, 500000000)},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline:authors:vt", &redis.ZRangeBy{Min: "10", Max: "(30.5"}).SetVal([]redis.Z{
+					{Score: 20, Member: "2"},
+				})
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
+					"name":       "test2",
+					"author":     "vt",
+					"created_at": "20",
+				})
+			},
+		},
+		{
+			name: "Fractional time bound.",
+			expected: []*Post{
+				{ID: 3, Name: "test3", Author: "vt", CreatedAt: time.Unix(10, 750000000), UpdatedAt: time.Unix(10, 750000000)},
+			},
+			filters: &SearchFilter{Order: Ascending, CreatedAfter: time.Unix(10, 500000000)},
+			mock: func() {
+				mock.ExpectZRangeByScoreWithScores("timeline", &redis.ZRangeBy{Min: "10.5", Max: "+inf"}).SetVal([]redis.Z{
+					{Score: 10.75, Member: "3"},
+				})
+				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
+					"name":       "test3",
+					"author":     "vt",
+					"created_at": "10.75",
+				})
+			},
+		},
+		{
+			name: "Time range and cursor. Ascending order.",
+			expected: []*Post{
+				{ID: 3, Name: "test3", Author: "vt", CreatedAt: time.Unix(20, 0), UpdatedAt: time.Unix(20, 0)},
+			},
+			filters: &SearchFilter{Order: Ascending, Limit: 1, CreatedAfter: time.Unix(10, 0), Cursor: cursor{order: Ascending, createdAt: time.Unix(15, 0), id: 2}.encode()},
+			mock: func() {
+				mock.ExpectZRangeByScoreWithScores("timeline", &redis.ZRangeBy{Min: "15", Max: "+inf", Count: 2}).SetVal([]redis.Z{
+					{Score: 20, Member: "3"},
+				})
+				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
+					"name":       "test3",
+					"author":     "vt",
+					"created_at": "20",
+				})
+			},
+		},
+		{
+			name: "Full-text search. First page.",
+			expected: []*Post{
+				{ID: 2, Name: "Intro to Golang", Author: "vt", CreatedAt: time.Unix(3, 0), UpdatedAt: time.Unix(3, 0)},
+			},
+			expectedNext: cursor{order: Relevance, offset: 0}.encode(),
+			filters:      &SearchFilter{Query: "golang intro", Order: Relevance, Limit: 1},
+			mock: func() {
+				mock.ExpectZCard("timeline").SetVal(10)
+				mock.ExpectZRangeWithScores("terms:golang", 0, -1).SetVal([]redis.Z{
+					{Score: 1, Member: "1"},
+					{Score: 1, Member: "2"},
+				})
+				mock.ExpectZRangeWithScores("terms:intro", 0, -1).SetVal([]redis.Z{
+					{Score: 1, Member: "2"},
+				})
+				mock.ExpectZScore("timeline", "1").SetVal(5)
+				mock.ExpectZScore("timeline", "2").SetVal(3)
+				mock.ExpectHGetAll("post:2").SetVal(map[string]string{
+					"name":       "Intro to Golang",
+					"author":     "vt",
+					"created_at": "3",
+				})
+			},
+		},
+		{
+			name: "Full-text search. Last page.",
+			expected: []*Post{
+				{ID: 1, Name: "Golang", Author: "vt", CreatedAt: time.Unix(5, 0), UpdatedAt: time.Unix(5, 0)},
+			},
+			filters: &SearchFilter{Query: "golang intro", Order: Relevance, Limit: 1, Cursor: cursor{order: Relevance, offset: 0}.encode()},
+			mock: func() {
+				mock.ExpectZCard("timeline").SetVal(10)
+				mock.ExpectZRangeWithScores("terms:golang", 0, -1).SetVal([]redis.Z{
+					{Score: 1, Member: "1"},
+					{Score: 1, Member: "2"},
+				})
+				mock.ExpectZRangeWithScores("terms:intro", 0, -1).SetVal([]redis.Z{
+					{Score: 1, Member: "2"},
+				})
+				mock.ExpectZScore("timeline", "1").SetVal(5)
+				mock.ExpectZScore("timeline", "2").SetVal(3)
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "Golang",
+					"author":     "vt",
+					"created_at": "5",
+				})
+			},
+		},
+		{
+			name: "Full-text search with author and time filters. Descending order.",
+			expected: []*Post{
+				{ID: 3, Name: "Golang posts", Author: "vt", CreatedAt: time.Unix(4, 0), UpdatedAt: time.Unix(4, 0)},
+			},
+			filters: &SearchFilter{Query: "golang", Author: "vt", CreatedBefore: time.Unix(5, 0)},
+			mock: func() {
+				mock.ExpectZCard("timeline").SetVal(10)
+				mock.ExpectZRangeWithScores("terms:golang", 0, -1).SetVal([]redis.Z{
+					{Score: 1, Member: "1"},
+					{Score: 1, Member: "2"},
+					{Score: 1, Member: "3"},
+				})
+				mock.ExpectZScore("timeline", "1").SetVal(5)
+				mock.ExpectSIsMember("authors:vt", int64(1)).SetVal(true)
+				mock.ExpectZScore("timeline", "2").SetVal(3)
+				mock.ExpectSIsMember("authors:vt", int64(2)).SetVal(false)
+				mock.ExpectZScore("timeline", "3").SetVal(4)
+				mock.ExpectSIsMember("authors:vt", int64(3)).SetVal(true)
+				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
+					"name":       "Golang posts",
+					"author":     "vt",
+					"created_at": "4",
+				})
+			},
+		},
+		{
+			name: "Author and name prefixes. First page.",
+			expected: []*Post{
+				{ID: 1, Name: "Go", Author: "VT", CreatedAt: time.Unix(5, 0), UpdatedAt: time.Unix(5, 0)},
+			},
+			expectedNext: cursor{order: Descending, createdAt: time.Unix(5, 0), id: 1}.encode(),
+			filters:      &SearchFilter{Name: "Go", Author: " vt", Match: MatchPrefix, Limit: 1},
+			mock: func() {
+				mock.ExpectZRangeByLex("lex:names", &redis.ZRangeBy{Min: "[go", Max: "[go\xff"}).SetVal([]string{"go", "golang"})
+				mock.ExpectSMembers("ci:names:go").SetVal([]string{"1"})
+				mock.ExpectSMembers("ci:names:golang").SetVal([]string{"2", "3"})
+				mock.ExpectZRangeByLex("lex:authors", &redis.ZRangeBy{Min: "[vt", Max: "[vt\xff"}).SetVal([]string{"vt", "vtgare"})
+				mock.ExpectSMembers("ci:authors:vt").SetVal([]string{"1", "3"})
+				mock.ExpectSMembers("ci:authors:vtgare").SetVal([]string{"4"})
+				mock.ExpectZScore("timeline", "1").SetVal(5)
+				mock.ExpectZScore("timeline", "3").SetVal(4)
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{
+					"name":       "Go",
+					"author":     "VT",
+					"created_at": "5",
+				})
+			},
+		},
+		{
+			name: "Full-text search with a case-insensitive name.",
+			expected: []*Post{
+				{ID: 3, Name: "Golang  Posts", Author: "vt", CreatedAt: time.Unix(4, 0), UpdatedAt: time.Unix(4, 0)},
+			},
+			filters: &SearchFilter{Query: "golang", Name: "golang posts", Match: MatchCaseInsensitive},
+			mock: func() {
+				mock.ExpectZCard("timeline").SetVal(10)
+				mock.ExpectZRangeWithScores("terms:golang", 0, -1).SetVal([]redis.Z{
+					{Score: 1, Member: "1"},
+					{Score: 1, Member: "3"},
+				})
+				mock.ExpectSMembers("ci:names:golang posts").SetVal([]string{"3", "4"})
+				mock.ExpectZScore("timeline", "3").SetVal(4)
+				mock.ExpectHGetAll("post:3").SetVal(map[string]string{
+					"name":       "Golang  Posts",
+					"author":     "vt",
+					"created_at": "4",
+				})
+			},
+		},
+		{
+			name:     "Full-text search. Only stopwords.",
+			expected: []*Post{},
+			filters:  &SearchFilter{Query: "the", Order: Relevance},
+			mock:     func() {},
+		},
+		{
+			name:    "Relevance order without a query.",
+			err:     true,
+			filters: &SearchFilter{Order: Relevance},
+			mock:    func() {},
+		},
+		{
+			name:     "Index points to a removed post.",
+			expected: []*Post{},
+			filters:  &SearchFilter{Name: "found"},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline:names:found", all).SetVal([]redis.Z{
+					{Score: 1, Member: "1"},
+				})
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{})
+			},
+		},
+		{
+			name:    "Cursor of another order.",
+			err:     true,
+			filters: &SearchFilter{Name: "found", Order: Ascending, Cursor: cursor{order: Descending, createdAt: time.Unix(2, 0), id: 2}.encode()},
+			mock:    func() {},
+		},
+		{
+			name:    "Malformed cursor.",
+			err:     true,
+			filters: &SearchFilter{Name: "found", Cursor: "not a cursor"},
+			mock:    func() {},
+		},
+		{
+			name:    "Bad ID.",
+			err:     true,
+			filters: &SearchFilter{},
+			mock: func() {
+				mock.ExpectZRevRangeByScoreWithScores("timeline", all).SetVal([]redis.Z{
+					{Score: 1, Member: "bad"},
+				})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		posts, next, err := rr.FindMany(context.Background(), test.filters)
+		if test.err {
+			assert.Error(t, err, test.name)
+		} else {
+			if assert.NoError(t, err, test.name) {
+				assert.Equal(t, test.expected, posts, test.name)
+				assert.Equal(t, test.expectedNext, next, test.name)
+			}
+		}
+
+		mock.ClearExpect()
+	}
+}
+
+func TestBackfillIndexes(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+
+	mock.ExpectScan(0, "post:*", 100).SetVal([]string{"post:1"}, 0)
+	mock.ExpectHMGet("post:1", "name", "author", "created_at", "tags", "deleted_at").SetVal([]interface{}{"test", "vt", "5", "go", nil})
+	mock.ExpectZAdd("timeline", &redis.Z{Score: 5, Member: int64(1)}).SetVal(1)
+	mock.ExpectZAdd("timeline:names:test", &redis.Z{Score: 5, Member: int64(1)}).SetVal(1)
+	mock.ExpectZAdd("timeline:authors:vt", &redis.Z{Score: 5, Member: int64(1)}).SetVal(1)
+	mock.ExpectZAdd("terms:test", &redis.Z{Score: 1, Member: int64(1)}).SetVal(1)
+	mock.ExpectSAdd("tags:go", int64(1)).SetVal(1)
+	mock.ExpectSAdd("ci:names:test", int64(1)).SetVal(1)
+	mock.ExpectSAdd("ci:authors:vt", int64(1)).SetVal(1)
+	mock.ExpectZAdd("lex:names", &redis.Z{Member: "test"}).SetVal(1)
+	mock.ExpectZAdd("lex:authors", &redis.Z{Member: "vt"}).SetVal(1)
+	mock.ExpectTxPipeline()
+	mock.ExpectDel("author_counts").SetVal(1)
+	mock.ExpectZAdd("author_counts", &redis.Z{Score: 1, Member: "vt"}).SetVal(1)
+	mock.ExpectDel("tag_counts").SetVal(1)
+	mock.ExpectZAdd("tag_counts", &redis.Z{Score: 1, Member: "go"}).SetVal(1)
+	mock.ExpectTxPipelineExec()
+
+	indexed, err := BackfillIndexes(context.Background(), client)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, indexed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestRebuildMatchIndexes(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+
+	mock.ExpectScan(0, "ci:*", 100).SetVal([]string{"ci:names:old", "ci:authors:vt"}, 0)
+	mock.ExpectDel("ci:names:old", "ci:authors:vt").SetVal(2)
+	mock.ExpectScan(0, "lex:*", 100).SetVal([]string{"lex:names", "lex:authors"}, 0)
+	mock.ExpectDel("lex:names", "lex:authors").SetVal(2)
+
+	//Post 2 is in the trash.
+	mock.ExpectScan(0, "post:*", 100).SetVal([]string{"post:1", "post:2"}, 0)
+	mock.ExpectHMGet("post:1", "name", "author", "deleted_at").SetVal([]interface{}{"My  Post", "VT", nil})
+	mock.ExpectHMGet("post:2", "name", "author", "deleted_at").SetVal([]interface{}{"old", "vt", "9"})
+	mock.ExpectSAdd("ci:names:my post", int64(1)).SetVal(1)
+	mock.ExpectSAdd("ci:authors:vt", int64(1)).SetVal(1)
+	mock.ExpectZAdd("lex:names", &redis.Z{Member: "my post"}).SetVal(1)
+	mock.ExpectZAdd("lex:authors", &redis.Z{Member: "vt"}).SetVal(1)
+
+	indexed, err := RebuildMatchIndexes(context.Background(), client)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, indexed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestRestore(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := redisRepository{client, func() time.Time { return time.Unix(200, 0) }}
+
+	trashed := map[string]string{
+		"name":       "test 1",
+		"author":     "vt",
+		"created_at": "1",
+		"tags":       "go",
+		"deleted_at": "100",
+		"version":    "4",
+	}
+	restored := `{"number":5,"action":"restore","editor":"vt","revised_at":200,"changed":["deleted_at"],"name":"test 1","author":"vt","created_at":1,"updated_at":1,"tags":["go"]}`
+	keys := []string{"post:1", "names:test 1", "authors:vt", "author_counts", "tag_counts", "trash", "revisions:1", "ci:names:test 1", "ci:authors:vt", "lex:names", "lex:authors", "timeline", "timeline:names:test 1", "timeline:authors:vt", "terms:test", "terms:1", "tags:go"}
+
+	tests := []struct {
+		name     string
+		expected *Post
+		err      error
+		mock     func()
+	}{
+		{
+			name:     "Success.",
+			expected: &Post{ID: 1, Name: "test 1", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Tags: []string{"go"}, Version: 5},
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(trashed)
+				mock.ExpectEvalSha(restoreScript.Hash(), keys, int64(1), int64(4), "vt", float64(1), restored, "test 1", "vt", 2, 1, 1, "go").SetVal(int64(1))
+			},
+		},
+		{
+			name:     "Changed before the script ran. Retried.",
+			expected: &Post{ID: 1, Name: "test 1", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Tags: []string{"go"}, Version: 5},
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(trashed)
+				mock.ExpectEvalSha(restoreScript.Hash(), keys, int64(1), int64(4), "vt", float64(1), restored, "test 1", "vt", 2, 1, 1, "go").SetVal(int64(-1))
+				mock.ExpectHGetAll("post:1").SetVal(trashed)
+				mock.ExpectEvalSha(restoreScript.Hash(), keys, int64(1), int64(4), "vt", float64(1), restored, "test 1", "vt", 2, 1, 1, "go").SetVal(int64(1))
+			},
+		},
+		{
+			name: "Not in the trash.",
+			err:  ErrConflict,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{"name": "test 1", "author": "vt", "created_at": "1", "version": "1"})
+			},
+		},
+		{
+			name: "Post doesn't exist.",
+			err:  ErrNotFound,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetVal(map[string]string{})
+			},
+		},
+		{
+			name: "Database error.",
+			err:  errFail,
+			mock: func() {
+				mock.ExpectHGetAll("post:1").SetErr(errFail)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		post, err := rr.Restore(context.Background(), 1)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, post, test.name)
+		}
+
+		mock.ClearExpect()
+	}
+}
+
+func TestFindTrashed(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client)
+
+	deletedAt := time.Unix(100, 0)
+	mock.ExpectZRevRangeByScoreWithScores("trash", &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: 2}).SetVal([]redis.Z{
+		{Score: 100, Member: "2"},
+		{Score: 100, Member: "1"},
+	})
+	mock.ExpectHGetAll("post:2").SetVal(map[string]string{
+		"name":       "test",
+		"author":     "vt",
+		"created_at": "1",
+		"deleted_at": "100",
+		"version":    "2",
+	})
+
+	posts, next, err := rr.FindTrashed(context.Background(), &TrashFilter{Limit: 1})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*Post{{ID: 2, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), DeletedAt: &deletedAt, Version: 2}}, posts)
+		assert.Equal(t, cursor{order: Descending, createdAt: time.Unix(100, 0), id: 2}.encode(), next)
+	}
+
+	//The next page skips entries removed at the cursor's second up to the cursor, post 1 has been purged since.
+	mock.ExpectZRevRangeByScoreWithScores("trash", &redis.ZRangeBy{Min: "-inf", Max: "100", Count: 2}).SetVal([]redis.Z{
+		{Score: 100, Member: "2"},
+		{Score: 100, Member: "1"},
+	})
+	mock.ExpectZRevRangeByScoreWithScores("trash", &redis.ZRangeBy{Min: "-inf", Max: "100", Offset: 2, Count: 2}).SetVal([]redis.Z{})
+	mock.ExpectHGetAll("post:1").SetVal(map[string]string{})
+
+	posts, next, err = rr.FindTrashed(context.Background(), &TrashFilter{Limit: 1, Cursor: next})
+	if assert.NoError(t, err) {
+		assert.Empty(t, posts)
+		assert.Equal(t, "", next)
+	}
+
+	_, _, err = rr.FindTrashed(context.Background(), &TrashFilter{Cursor: cursor{order: Ascending}.encode()})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurge(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client).(clocked).withClock(func() time.Time { return time.Unix(200, 0) })
+
+	//Post 2 was restored and trashed again after its hash was read, post 3 is gone.
+	mock.ExpectZRangeByScoreWithScores("trash", &redis.ZRangeBy{Min: "-inf", Max: "(100", Count: 100}).SetVal([]redis.Z{
+		{Score: 50, Member: "1"},
+		{Score: 60, Member: "2"},
+		{Score: 70, Member: "3"},
+	})
+	mock.ExpectHGetAll("post:1").SetVal(map[string]string{"name": "test", "author": "vt", "created_at": "1", "updated_at": "1", "deleted_at": "50", "version": "3"})
+	mock.ExpectHGetAll("post:2").SetVal(map[string]string{"name": "test", "author": "vt", "created_at": "1", "updated_at": "1", "deleted_at": "60", "version": "5"})
+	mock.ExpectHGetAll("post:3").SetVal(map[string]string{})
+
+	//The history of a purged post is kept and ends with the purge.
+	purged1 := `{"number":4,"action":"purge","editor":"vt","revised_at":200,"name":"test","author":"vt","created_at":1,"updated_at":1,"deleted_at":50}`
+	purged2 := `{"number":6,"action":"purge","editor":"vt","revised_at":200,"name":"test","author":"vt","created_at":1,"updated_at":1,"deleted_at":60}`
+	mock.ExpectEvalSha(purgeScript.Hash(), []string{"post:1", "trash", "revisions:1"}, "1", int64(50), int64(3), purged1).SetVal(int64(1))
+	mock.ExpectEvalSha(purgeScript.Hash(), []string{"post:2", "trash", "revisions:2"}, "2", int64(60), int64(5), purged2).SetVal(int64(-1))
+	mock.ExpectEvalSha(purgeScript.Hash(), []string{"post:3", "trash", "revisions:3"}, "3", int64(70), 0, "").SetVal(int64(0))
+
+	purged, err := rr.Purge(context.Background(), time.Unix(100, 0))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), purged)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	mock.ExpectZRangeByScoreWithScores("trash", &redis.ZRangeBy{Min: "-inf", Max: "(100", Count: 100}).SetErr(errFail)
+	_, err = rr.Purge(context.Background(), time.Unix(100, 0))
+	assert.ErrorIs(t, err, errFail)
+}
+
+func TestRevisions(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client)
+
+	deletedAt := time.Unix(100, 0)
+	mock.ExpectLRange("revisions:1", 0, -1).SetVal([]string{
+		`{"number":1,"action":"create","editor":"vt","revised_at":50,"changed":["name","author","created_at"],"name":"test","author":"vt","created_at":1}`,
+		`{"number":2,"action":"remove","editor":"admin","revised_at":100,"changed":["deleted_at"],"name":"test","author":"vt","created_at":1,"updated_at":1,"deleted_at":100}`,
+	})
+
+	revisions, err := rr.Revisions(context.Background(), 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*Revision{
+			{
+				Number:    1,
+				Action:    ActionCreate,
+				Editor:    "vt",
+				CreatedAt: time.Unix(50, 0),
+				Changed:   []string{"name", "author", "created_at"},
+				Post:      &Post{ID: 1, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Version: 1},
+			},
+			{
+				Number:    2,
+				Action:    ActionRemove,
+				Editor:    "admin",
+				CreatedAt: time.Unix(100, 0),
+				Changed:   []string{"deleted_at"},
+				Post:      &Post{ID: 1, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), DeletedAt: &deletedAt, Version: 2},
+			},
+		}, revisions)
+	}
+
+	//Posts created before revisions were recorded have an empty history.
+	mock.ExpectLRange("revisions:2", 0, -1).SetVal([]string{})
+	mock.ExpectExists("post:2").SetVal(1)
+	revisions, err = rr.Revisions(context.Background(), 2)
+	if assert.NoError(t, err) {
+		assert.Empty(t, revisions)
+	}
+
+	mock.ExpectLRange("revisions:3", 0, -1).SetVal([]string{})
+	mock.ExpectExists("post:3").SetVal(0)
+	_, err = rr.Revisions(context.Background(), 3)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	mock.ExpectLRange("revisions:1", 0, -1).SetErr(errFail)
+	_, err = rr.Revisions(context.Background(), 1)
+	assert.ErrorIs(t, err, errFail)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevision(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	rr := NewRedisRepository(client)
+
+	//The history of post 1 starts with its third version.
+	first := `{"number":3,"action":"update","editor":"vt","revised_at":50,"changed":["name"],"name":"test","author":"vt","created_at":1}`
+	tests := []struct {
+		name     string
+		id       int64
+		n        int64
+		expected *Revision
+		err      error
+		mock     func()
+	}{
+		{
+			name: "First stored revision.",
+			id:   1,
+			n:    3,
+			expected: &Revision{
+				Number:    3,
+				Action:    ActionUpdate,
+				Editor:    "vt",
+				CreatedAt: time.Unix(50, 0),
+				Changed:   []string{"name"},
+				Post:      &Post{ID: 1, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Version: 3},
+			},
+			mock: func() {
+				mock.ExpectLIndex("revisions:1", 0).SetVal(first)
+			},
+		},
+		{
+			name: "Later revision.",
+			id:   1,
+			n:    5,
+			expected: &Revision{
+				Number:    5,
+				Action:    ActionUpdate,
+				Editor:    "admin",
+				CreatedAt: time.Unix(70, 0),
+				Changed:   []string{"body"},
+				Post:      &Post{ID: 1, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: time.Unix(1, 0), Body: "hi", Version: 5},
+			},
+			mock: func() {
+				mock.ExpectLIndex("revisions:1", 0).SetVal(first)
+				mock.ExpectLIndex("revisions:1", 2).SetVal(`{"number":5,"action":"update","editor":"admin","revised_at":70,"changed":["body"],"name":"test","author":"vt","created_at":1,"body":"hi"}`)
+			},
+		},
+		{
+			name: "Revision before the history.",
+			id:   1,
+			n:    2,
+			err:  ErrNotFound,
+			mock: func() {
+				mock.ExpectLIndex("revisions:1", 0).SetVal(first)
+			},
+		},
+		{
+			name: "Revision after the last one.",
+			id:   1,
+			n:    9,
+			err:  ErrNotFound,
+			mock: func() {
+				mock.ExpectLIndex("revisions:1", 0).SetVal(first)
+				mock.ExpectLIndex("revisions:1", 6).RedisNil()
+			},
+		},
+		{
+			name: "Post doesn't exist.",
+			id:   2,
+			n:    1,
+			err:  ErrNotFound,
+			mock: func() {
+				mock.ExpectLIndex("revisions:2", 0).RedisNil()
+				mock.ExpectExists("post:2").SetVal(0)
+			},
+		},
+		{
+			name: "Database error.",
+			id:   1,
+			n:    1,
+			err:  errFail,
+			mock: func() {
+				mock.ExpectLIndex("revisions:1", 0).SetErr(errFail)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.mock()
+
+		rev, err := rr.Revision(context.Background(), test.id, test.n)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+		} else if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, rev, test.name)
+		}
+
+		mock.ClearExpect()
+	}
+}
@@ -0,0 +1,43 @@
+package post
+
+import (
+	"bytes"
+	"html"
+	"strings"
+
+	"github.com/yuin/goldmark"
+)
+
+//markdown renders CommonMark. Raw HTML is omitted and links with dangerous schemes such as javascript: are dropped.
+var markdown = goldmark.New()
+
+//RenderHTML renders a post body to HTML that's safe to embed in a page.
+//Markdown is rendered without raw HTML, plain text is escaped and split into paragraphs at blank lines.
+func RenderHTML(post *Post) (string, error) {
+	if post.Format == FormatMarkdown {
+		var buf bytes.Buffer
+		if err := markdown.Convert([]byte(post.Body), &buf); err != nil {
+			return "", err
+		}
+
+		return buf.String(), nil
+	}
+
+	var buf strings.Builder
+	body := strings.ReplaceAll(post.Body, "\r\n", "\n")
+	for _, paragraph := range strings.Split(body, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		lines := strings.Split(paragraph, "\n")
+		for i, line := range lines {
+			lines[i] = html.EscapeString(line)
+		}
+
+		buf.WriteString("<p>" + strings.Join(lines, "<br>\n") + "</p>\n")
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,48 @@
+package post
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		post     *Post
+		expected string
+	}{
+		{
+			name:     "Plain text.",
+			post:     &Post{Body: "first line\nsecond <line>\n\n\n& another paragraph\n"},
+			expected: "<p>first line<br>\nsecond &lt;line&gt;</p>\n<p>&amp; another paragraph</p>\n",
+		},
+		{
+			name:     "Empty body.",
+			post:     &Post{Format: FormatPlain},
+			expected: "",
+		},
+		{
+			name:     "Markdown.",
+			post:     &Post{Body: "# Title\n\n*emphasis* and [a link](https://example.com)", Format: FormatMarkdown},
+			expected: "<h1>Title</h1>\n<p><em>emphasis</em> and <a href=\"https://example.com\">a link</a></p>\n",
+		},
+		{
+			name:     "Markdown with raw HTML.",
+			post:     &Post{Body: "<script>alert(1)</script>\n\ntext <img src=x onerror=alert(1)>", Format: FormatMarkdown},
+			expected: "<!-- raw HTML omitted -->\n<p>text <!-- raw HTML omitted --></p>\n",
+		},
+		{
+			name:     "Markdown with a dangerous link.",
+			post:     &Post{Body: "[click](javascript:alert(1))", Format: FormatMarkdown},
+			expected: "<p><a href=\"\">click</a></p>\n",
+		},
+	}
+
+	for _, test := range tests {
+		rendered, err := RenderHTML(test.post)
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, rendered, test.name)
+		}
+	}
+}
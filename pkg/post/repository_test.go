@@ -0,0 +1,964 @@
+package post
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//testRepositories creates empty repositories of every backend. All of them must pass the same tests.
+//TestRepositories sets their clocks to testNow.
+var testRepositories = map[string]func(*testing.T) Repository{
+	"memory": func(*testing.T) Repository { return NewMemoryRepository() },
+	"sqlite": newTestSQLRepository,
+}
+
+//testNow is the time repositories under test stamp updates with.
+var testNow = time.Unix(100, 0)
+
+func TestRepositories(t *testing.T) {
+	tests := []struct {
+		name string
+		test func(*testing.T, Repository)
+	}{
+		{"Create", testCreate},
+		{"FindOne", testFindOne},
+		{"FindByIDs", testFindByIDs},
+		{"FindMany", testFindMany},
+		{"FractionalTimes", testFractionalTimes},
+		{"Match", testMatch},
+		{"Update", testUpdate},
+		{"Remove", testRemove},
+		{"Batch", testBatch},
+		{"ImportExport", testImportExport},
+		{"Count", testCount},
+		{"Body", testBody},
+		{"Tags", testTags},
+		{"Trash", testTrash},
+		{"Revisions", testRevisions},
+	}
+
+	for backend, newRepo := range testRepositories {
+		for _, test := range tests {
+			t.Run(backend+"/"+test.name, func(t *testing.T) {
+				repo := newRepo(t).(clocked).withClock(func() time.Time { return testNow })
+				test.test(t, repo)
+			})
+		}
+	}
+}
+
+//seedRepository adds posts 1-5 to repo.
+func seedRepository(t *testing.T, repo Repository) {
+	posts := []*Post{
+		{Name: "test 1", Author: "vt", CreatedAt: time.Unix(10, 0)},
+		{Name: "test 2", Author: "vt", CreatedAt: time.Unix(20, 0)},
+		{Name: "robots", Author: "robot", CreatedAt: time.Unix(20, 0)},
+		{Name: "test 1", Author: "robot", CreatedAt: time.Unix(30, 0)},
+		{Name: "testing robot", Author: "vt", CreatedAt: time.Unix(40, 0)},
+	}
+
+	for _, post := range posts {
+		if _, err := repo.Create(context.Background(), post); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testCreate(t *testing.T, repo Repository) {
+
+	for i := int64(1); i <= 3; i++ {
+		id, err := repo.Create(context.Background(), &Post{Name: "test", Author: "vt", CreatedAt: time.Unix(1, 500500)})
+		if assert.NoError(t, err) {
+			assert.Equal(t, i, id)
+		}
+	}
+
+	//Times are stored with microsecond precision.
+	post, err := repo.FindOne(context.Background(), 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Post{ID: 3, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 500000), UpdatedAt: time.Unix(1, 500000), Version: 1}, post)
+	}
+}
+
+func testFindOne(t *testing.T, repo Repository) {
+	seedRepository(t, repo)
+
+	post, err := repo.FindOne(context.Background(), 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Post{ID: 2, Name: "test 2", Author: "vt", CreatedAt: time.Unix(20, 0), UpdatedAt: time.Unix(20, 0), Version: 1}, post)
+	}
+
+	//Returned posts are copies.
+	post.Name = "changed"
+	post, _ = repo.FindOne(context.Background(), 2)
+	assert.Equal(t, "test 2", post.Name)
+
+	_, err = repo.FindOne(context.Background(), 6)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func testFindByIDs(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	seedRepository(t, repo)
+
+	if _, err := repo.Remove(ctx, 2, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	posts, missing, err := repo.FindByIDs(ctx, []int64{4, 2, 1, 10, 4})
+	if assert.NoError(t, err) && assert.Len(t, posts, 3) {
+		assert.Equal(t, &Post{ID: 4, Name: "test 1", Author: "robot", CreatedAt: time.Unix(30, 0), UpdatedAt: time.Unix(30, 0), Version: 1}, posts[0])
+		assert.Equal(t, int64(1), posts[1].ID)
+		assert.Equal(t, int64(4), posts[2].ID)
+		assert.Equal(t, []int64{2, 10}, missing)
+	}
+
+	posts, missing, err = repo.FindByIDs(ctx, nil)
+	if assert.NoError(t, err) {
+		assert.Empty(t, posts)
+		assert.Empty(t, missing)
+	}
+}
+
+func testFindMany(t *testing.T, repo Repository) {
+	seedRepository(t, repo)
+
+	tests := []struct {
+		name     string
+		filter   *SearchFilter
+		expected []int64
+		next     string
+		err      error
+	}{
+		{
+			name:     "Descending by default.",
+			filter:   &SearchFilter{},
+			expected: []int64{5, 4, 3, 2, 1},
+		},
+		{
+			name:     "Ascending, ties are broken by ID.",
+			filter:   &SearchFilter{Order: Ascending},
+			expected: []int64{1, 2, 3, 4, 5},
+		},
+		{
+			name:     "Name and author.",
+			filter:   &SearchFilter{Name: "test 1", Author: "robot"},
+			expected: []int64{4},
+		},
+		{
+			name:     "Created in [20, 40).",
+			filter:   &SearchFilter{CreatedAfter: time.Unix(20, 0), CreatedBefore: time.Unix(40, 0)},
+			expected: []int64{4, 3, 2},
+		},
+		{
+			name:     "First page.",
+			filter:   &SearchFilter{Limit: 2},
+			expected: []int64{5, 4},
+			next:     cursor{order: Descending, createdAt: time.Unix(30, 0), id: 4}.encode(),
+		},
+		{
+			name:     "Second page.",
+			filter:   &SearchFilter{Limit: 2, Cursor: cursor{order: Descending, createdAt: time.Unix(30, 0), id: 4}.encode()},
+			expected: []int64{3, 2},
+			next:     cursor{order: Descending, createdAt: time.Unix(20, 0), id: 2}.encode(),
+		},
+		{
+			name:     "Last page.",
+			filter:   &SearchFilter{Limit: 2, Cursor: cursor{order: Descending, createdAt: time.Unix(20, 0), id: 2}.encode()},
+			expected: []int64{1},
+		},
+		{
+			name:     "Full-text search ranks rare terms higher.",
+			filter:   &SearchFilter{Query: "robot test", Order: Relevance},
+			expected: []int64{5, 3, 4, 2, 1},
+		},
+		{
+			name:     "Full-text search second page.",
+			filter:   &SearchFilter{Query: "robot test", Order: Relevance, Limit: 2, Cursor: cursor{order: Relevance, offset: 1}.encode()},
+			expected: []int64{4, 2},
+			next:     cursor{order: Relevance, offset: 3}.encode(),
+		},
+		{
+			name:     "Full-text search with an author filter.",
+			filter:   &SearchFilter{Query: "robots", Author: "robot"},
+			expected: []int64{3},
+		},
+		{
+			name:   "Relevance without a query.",
+			filter: &SearchFilter{Order: Relevance},
+			err:    ErrInvalid,
+		},
+		{
+			name:   "Cursor of another order.",
+			filter: &SearchFilter{Order: Ascending, Cursor: cursor{order: Descending, createdAt: time.Unix(30, 0), id: 4}.encode()},
+			err:    ErrInvalidCursor,
+		},
+	}
+
+	for _, test := range tests {
+		posts, next, err := repo.FindMany(context.Background(), test.filter)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+			continue
+		}
+
+		if assert.NoError(t, err, test.name) {
+			ids := make([]int64, 0, len(posts))
+			for _, post := range posts {
+				ids = append(ids, post.ID)
+			}
+
+			assert.Equal(t, test.expected, ids, test.name)
+			assert.Equal(t, test.next, next, test.name)
+		}
+	}
+}
+
+func testFractionalTimes(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	for _, nsec := range []int64{250000000, 500000000, 750000000} {
+		if _, err := repo.Create(ctx, &Post{Name: "test", Author: "vt", CreatedAt: time.Unix(10, nsec)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   *SearchFilter
+		expected []int64
+	}{
+		{
+			name:     "Created at or after 10.5.",
+			filter:   &SearchFilter{Order: Ascending, CreatedAfter: time.Unix(10, 500000000)},
+			expected: []int64{2, 3},
+		},
+		{
+			name:     "Created before 10.5.",
+			filter:   &SearchFilter{Order: Ascending, CreatedBefore: time.Unix(10, 500000000)},
+			expected: []int64{1},
+		},
+		{
+			name:     "Created in [10.3, 10.7).",
+			filter:   &SearchFilter{Order: Ascending, CreatedAfter: time.Unix(10, 300000000), CreatedBefore: time.Unix(10, 700000000)},
+			expected: []int64{2},
+		},
+		{
+			name:     "Bounds finer than a microsecond.",
+			filter:   &SearchFilter{Order: Ascending, CreatedAfter: time.Unix(10, 500000001), CreatedBefore: time.Unix(10, 750000001)},
+			expected: []int64{3},
+		},
+	}
+
+	for _, test := range tests {
+		posts, _, err := repo.FindMany(ctx, test.filter)
+		if assert.NoError(t, err, test.name) {
+			ids := make([]int64, 0, len(posts))
+			for _, post := range posts {
+				ids = append(ids, post.ID)
+			}
+
+			assert.Equal(t, test.expected, ids, test.name)
+		}
+	}
+
+	//Cursors keep sub-second times, so pages within a second neither repeat nor skip posts.
+	filter := &SearchFilter{Limit: 1}
+	var ids []int64
+	for {
+		posts, next, err := repo.FindMany(ctx, filter)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+		}
+
+		if next == "" {
+			break
+		}
+		filter.Cursor = next
+	}
+
+	assert.Equal(t, []int64{3, 2, 1}, ids)
+}
+
+func testMatch(t *testing.T, repo Repository) {
+	posts := []*Post{
+		{Name: "Intro to Go", Author: "VT", CreatedAt: time.Unix(10, 0)},
+		{Name: "intro  to go", Author: "vt", CreatedAt: time.Unix(20, 0)},
+		{Name: "Introduction", Author: "robot", CreatedAt: time.Unix(30, 0)},
+		{Name: "50%_off", Author: "vt", CreatedAt: time.Unix(40, 0)},
+		{Name: "500 posts", Author: "vt", CreatedAt: time.Unix(50, 0)},
+	}
+
+	for _, post := range posts {
+		if _, err := repo.Create(context.Background(), post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := func(filter *SearchFilter) []int64 {
+		posts, _, err := repo.FindMany(context.Background(), filter)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(posts))
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+		}
+
+		return ids
+	}
+
+	tests := []struct {
+		name     string
+		filter   *SearchFilter
+		expected []int64
+	}{
+		{
+			name:     "Exact by default.",
+			filter:   &SearchFilter{Name: "Intro to Go"},
+			expected: []int64{1},
+		},
+		{
+			name:     "Case and whitespace are ignored.",
+			filter:   &SearchFilter{Name: " INTRO to  Go", Match: MatchCaseInsensitive},
+			expected: []int64{2, 1},
+		},
+		{
+			name:     "Case-insensitive author.",
+			filter:   &SearchFilter{Author: "Vt", Match: MatchCaseInsensitive, Order: Ascending},
+			expected: []int64{1, 2, 4, 5},
+		},
+		{
+			name:     "Name prefix.",
+			filter:   &SearchFilter{Name: "intro", Match: MatchPrefix},
+			expected: []int64{3, 2, 1},
+		},
+		{
+			name:     "Name and author prefixes.",
+			filter:   &SearchFilter{Name: "Intro", Author: "v", Match: MatchPrefix},
+			expected: []int64{2, 1},
+		},
+		{
+			name:     "Wildcards in a prefix are literal.",
+			filter:   &SearchFilter{Name: "50%", Match: MatchPrefix},
+			expected: []int64{4},
+		},
+		{
+			name:     "Prefix with a query.",
+			filter:   &SearchFilter{Name: "intro to", Query: "go", Match: MatchPrefix},
+			expected: []int64{2, 1},
+		},
+		{
+			name:     "Prefix with a time range.",
+			filter:   &SearchFilter{Name: "intro", Match: MatchPrefix, CreatedAfter: time.Unix(15, 0), CreatedBefore: time.Unix(30, 0)},
+			expected: []int64{2},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, find(test.filter), test.name)
+	}
+
+	//Renamed and trashed posts stop matching.
+	_, err := repo.Update(context.Background(), 3, &Post{Name: "Outro", Author: "robot"}, AnyVersion)
+	assert.NoError(t, err)
+	_, err = repo.Remove(context.Background(), 2, AnyVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, find(&SearchFilter{Name: "intro", Match: MatchPrefix}))
+	assert.Equal(t, []int64{3}, find(&SearchFilter{Name: "OUTRO", Match: MatchCaseInsensitive}))
+}
+
+func testUpdate(t *testing.T, repo Repository) {
+	seedRepository(t, repo)
+
+	//Creation time can't be changed.
+	updated, err := repo.Update(context.Background(), 1, &Post{Name: "test 3", Author: "robot", CreatedAt: time.Unix(50, 0)}, 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Post{ID: 1, Name: "test 3", Author: "robot", CreatedAt: time.Unix(10, 0), UpdatedAt: testNow, Version: 2}, updated)
+	}
+
+	posts, _, err := repo.FindMany(context.Background(), &SearchFilter{Author: "robot", Order: Ascending, Limit: 1})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*Post{updated}, posts)
+	}
+
+	_, err = repo.Update(context.Background(), 1, &Post{Name: "test 4"}, 1)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	_, err = repo.Update(context.Background(), 6, &Post{Name: "test 4"}, AnyVersion)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func testRemove(t *testing.T, repo Repository) {
+	seedRepository(t, repo)
+
+	_, err := repo.Remove(context.Background(), 1, 2)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	ok, err := repo.Remove(context.Background(), 1, 1)
+	if assert.NoError(t, err) {
+		assert.True(t, ok)
+	}
+
+	_, err = repo.Remove(context.Background(), 1, AnyVersion)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	count, total, err := repo.Count(context.Background(), &CountFilter{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []AuthorCount{{Author: "vt", Count: 2}, {Author: "robot", Count: 2}}, count)
+		assert.Equal(t, int64(4), total)
+	}
+}
+
+func testBatch(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	seedRepository(t, repo)
+
+	posts := []*Post{{Name: "batch 1", Author: "vt", CreatedAt: time.Unix(50, 0)}, {Name: "batch 2", Author: "robot", CreatedAt: time.Unix(60, 0)}}
+	results, err := repo.CreateMany(ctx, posts, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []BatchResult{{ID: 6}, {ID: 7}}, results)
+	}
+
+	found, _, err := repo.FindMany(ctx, &SearchFilter{Query: "batch"})
+	if assert.NoError(t, err) && assert.Len(t, found, 2) {
+		assert.Equal(t, int64(7), found[0].ID)
+		assert.Equal(t, int64(1), found[0].Version)
+	}
+
+	results, err = repo.RemoveMany(ctx, []int64{6, 10, 7}, true)
+	if assert.NoError(t, err) && assert.Len(t, results, 3) {
+		assert.ErrorIs(t, results[0].Err, ErrBatchAborted)
+		assert.ErrorIs(t, results[1].Err, ErrNotFound)
+		assert.ErrorIs(t, results[2].Err, ErrBatchAborted)
+	}
+
+	_, err = repo.FindOne(ctx, 6)
+	assert.NoError(t, err)
+
+	results, err = repo.RemoveMany(ctx, []int64{6, 10, 7}, false)
+	if assert.NoError(t, err) && assert.Len(t, results, 3) {
+		assert.Equal(t, int64(6), results[0].ID)
+		assert.ErrorIs(t, results[1].Err, ErrNotFound)
+		assert.Equal(t, int64(7), results[2].ID)
+	}
+
+	count, total, err := repo.Count(ctx, &CountFilter{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []AuthorCount{{Author: "vt", Count: 3}, {Author: "robot", Count: 2}}, count)
+		assert.Equal(t, int64(5), total)
+	}
+
+	trashed, _, err := repo.FindTrashed(ctx, &TrashFilter{})
+	if assert.NoError(t, err) {
+		assert.Len(t, trashed, 2)
+	}
+}
+
+func testImportExport(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	seedRepository(t, repo)
+
+	if _, err := repo.Remove(ctx, 2, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	records := []*Record{
+		{ID: 10, Post: &Post{Name: "imported", Author: "vt", CreatedAt: time.Unix(5, 100000), UpdatedAt: time.Unix(8, 0), Tags: []string{"go"}}},
+		{ID: 2, Post: &Post{Name: "taken", Author: "vt", CreatedAt: time.Unix(5, 0)}},
+		{ID: 8, Post: &Post{Name: "imported too", Author: "robot", CreatedAt: time.Unix(6, 0)}},
+	}
+	results, err := repo.Import(ctx, records)
+	if assert.NoError(t, err) && assert.Len(t, results, 3) {
+		assert.Equal(t, int64(10), results[0].ID)
+		assert.ErrorIs(t, results[1].Err, ErrConflict)
+		assert.Equal(t, int64(8), results[2].ID)
+	}
+
+	post, err := repo.FindOne(ctx, 10)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Post{ID: 10, Name: "imported", Author: "vt", CreatedAt: time.Unix(5, 100000), UpdatedAt: time.Unix(8, 0), Tags: []string{"go"}, Version: 1}, post)
+	}
+
+	found, _, err := repo.FindMany(ctx, &SearchFilter{Query: "imported", Order: Ascending})
+	if assert.NoError(t, err) && assert.Len(t, found, 2) {
+		assert.Equal(t, int64(10), found[0].ID)
+		assert.Equal(t, int64(8), found[1].ID)
+	}
+
+	id, err := repo.Create(ctx, &Post{Name: "test", Author: "vt", CreatedAt: time.Unix(50, 0)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(11), id)
+	}
+
+	//Records of live exports have no history.
+	var exported []int64
+	err = repo.Export(ctx, false, func(rec *Record) error {
+		assert.Equal(t, rec.ID, rec.Post.ID)
+		assert.Nil(t, rec.Revisions)
+		exported = append(exported, rec.ID)
+		return nil
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int64{1, 3, 4, 5, 8, 10, 11}, exported)
+	}
+
+	err = repo.Export(ctx, false, func(*Record) error { return errFail })
+	assert.ErrorIs(t, err, errFail)
+
+	count, total, err := repo.Count(ctx, &CountFilter{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []AuthorCount{{Author: "vt", Count: 4}, {Author: "robot", Count: 3}}, count)
+		assert.Equal(t, int64(7), total)
+	}
+}
+
+//TestFullExport copies a repository of every backend to an empty one of the same backend with a full export.
+func TestFullExport(t *testing.T) {
+	for backend, newRepo := range testRepositories {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			clock := func() time.Time { return testNow }
+			source := newRepo(t).(clocked).withClock(clock)
+			target := newRepo(t).(clocked).withClock(clock)
+			seedRepository(t, source)
+
+			//Post 1 is updated, 2 is purged and 3 is trashed.
+			if _, err := source.Update(ctx, 1, &Post{Name: "updated", Author: "vt", Tags: []string{"go"}}, AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := source.Remove(ctx, 2, AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := source.Purge(ctx, testNow.Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := source.Remove(ctx, 3, AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+
+			var records []*Record
+			err := source.Export(ctx, true, func(rec *Record) error {
+				records = append(records, rec)
+				return nil
+			})
+			if !assert.NoError(t, err) || !assert.Len(t, records, 5) {
+				return
+			}
+			assert.Nil(t, records[1].Post)
+			assert.NotNil(t, records[2].Post.DeletedAt)
+
+			results, err := target.Import(ctx, records)
+			if assert.NoError(t, err) {
+				for i, res := range results {
+					assert.NoError(t, res.Err)
+					assert.Equal(t, records[i].ID, res.ID)
+				}
+			}
+
+			for _, rec := range records {
+				revisions, err := target.Revisions(ctx, rec.ID)
+				if assert.NoError(t, err) {
+					assert.Equal(t, rec.Revisions, revisions)
+				}
+			}
+
+			post, err := target.FindOne(ctx, 1)
+			if assert.NoError(t, err) {
+				assert.Equal(t, &Post{ID: 1, Name: "updated", Author: "vt", CreatedAt: time.Unix(10, 0), UpdatedAt: testNow, Tags: []string{"go"}, Version: 2}, post)
+			}
+
+			_, err = target.FindOne(ctx, 2)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			count, total, err := target.Count(ctx, &CountFilter{})
+			if assert.NoError(t, err) {
+				assert.Equal(t, []AuthorCount{{Author: "vt", Count: 2}, {Author: "robot", Count: 1}}, count)
+				assert.Equal(t, int64(3), total)
+			}
+
+			//The trashed post can be restored to its indexes.
+			trashed, _, err := target.FindTrashed(ctx, &TrashFilter{})
+			if assert.NoError(t, err) && assert.Len(t, trashed, 1) {
+				assert.Equal(t, int64(3), trashed[0].ID)
+				assert.Equal(t, int64(2), trashed[0].Version)
+			}
+
+			if _, err := target.Restore(ctx, 3); assert.NoError(t, err) {
+				found, _, err := target.FindMany(ctx, &SearchFilter{Name: "robots"})
+				if assert.NoError(t, err) && assert.Len(t, found, 1) {
+					assert.Equal(t, int64(3), found[0].ID)
+				}
+			}
+
+			//Purged IDs are neither imported again nor reused.
+			results, err = target.Import(ctx, records)
+			if assert.NoError(t, err) {
+				for _, res := range results {
+					assert.ErrorIs(t, res.Err, ErrConflict)
+				}
+			}
+
+			id, err := target.Create(ctx, &Post{Name: "new", Author: "vt", CreatedAt: testNow})
+			if assert.NoError(t, err) {
+				assert.Equal(t, int64(6), id)
+			}
+		})
+	}
+}
+
+func testCount(t *testing.T, repo Repository) {
+	seedRepository(t, repo)
+
+	tests := []struct {
+		name     string
+		filter   *CountFilter
+		expected []AuthorCount
+		total    int64
+		err      error
+	}{
+		{
+			name:     "Most posts first.",
+			filter:   &CountFilter{},
+			expected: []AuthorCount{{Author: "vt", Count: 3}, {Author: "robot", Count: 2}},
+			total:    5,
+		},
+		{
+			name:     "Fewest posts first.",
+			filter:   &CountFilter{Order: Ascending},
+			expected: []AuthorCount{{Author: "robot", Count: 2}, {Author: "vt", Count: 3}},
+			total:    5,
+		},
+		{
+			name:     "Top author.",
+			filter:   &CountFilter{Limit: 1},
+			expected: []AuthorCount{{Author: "vt", Count: 3}},
+			total:    5,
+		},
+		{
+			name:     "Single author.",
+			filter:   &CountFilter{Author: "robot"},
+			expected: []AuthorCount{{Author: "robot", Count: 2}},
+			total:    2,
+		},
+		{
+			name:     "Unknown author.",
+			filter:   &CountFilter{Author: "nobody"},
+			expected: []AuthorCount{},
+		},
+		{
+			name:     "Author of any case.",
+			filter:   &CountFilter{Author: "VT", Match: MatchCaseInsensitive},
+			expected: []AuthorCount{{Author: "VT", Count: 3}},
+			total:    3,
+		},
+		{
+			name:     "Exact author.",
+			filter:   &CountFilter{Author: "VT"},
+			expected: []AuthorCount{},
+		},
+		{
+			name:     "Unknown author of any case.",
+			filter:   &CountFilter{Author: "nobody", Match: MatchCaseInsensitive},
+			expected: []AuthorCount{},
+		},
+		{
+			name:   "Relevance order.",
+			filter: &CountFilter{Order: Relevance},
+			err:    ErrInvalid,
+		},
+		{
+			name:   "Author prefix.",
+			filter: &CountFilter{Author: "v", Match: MatchPrefix},
+			err:    ErrInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		count, total, err := repo.Count(context.Background(), test.filter)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+			continue
+		}
+
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, count, test.name)
+			assert.Equal(t, test.total, total, test.name)
+		}
+	}
+}
+
+func testBody(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id, err := repo.Create(ctx, &Post{Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), Body: "*text*", Format: FormatMarkdown})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	post, err := repo.FindOne(ctx, id)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "*text*", post.Body)
+		assert.Equal(t, FormatMarkdown, post.Format)
+	}
+
+	updated, err := repo.Update(ctx, id, &Post{Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), Body: "text"}, AnyVersion)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "text", updated.Body)
+		assert.Equal(t, "", updated.Format)
+	}
+
+	for _, filter := range []*SearchFilter{{OmitBody: true}, {OmitBody: true, Query: "test"}} {
+		posts, _, err := repo.FindMany(ctx, filter)
+		if assert.NoError(t, err) && assert.Len(t, posts, 1) {
+			assert.Equal(t, &Post{ID: id, Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0), UpdatedAt: testNow, Version: 2}, posts[0])
+		}
+	}
+
+	posts, _, err := repo.FindMany(ctx, &SearchFilter{})
+	if assert.NoError(t, err) && assert.Len(t, posts, 1) {
+		assert.Equal(t, "text", posts[0].Body)
+	}
+}
+
+func testTags(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	posts := []*Post{
+		{Name: "test 1", Author: "vt", CreatedAt: time.Unix(10, 0), Tags: []string{"go", "redis"}},
+		{Name: "test 2", Author: "vt", CreatedAt: time.Unix(20, 0), Tags: []string{"go"}},
+		{Name: "test 3", Author: "robot", CreatedAt: time.Unix(30, 0), Tags: []string{"sql"}},
+		{Name: "test 4", Author: "robot", CreatedAt: time.Unix(40, 0)},
+	}
+
+	for _, post := range posts {
+		if _, err := repo.Create(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post, err := repo.FindOne(ctx, 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"go", "redis"}, post.Tags)
+	}
+
+	searches := []struct {
+		name     string
+		filter   *SearchFilter
+		expected []int64
+	}{
+		{"All tags.", &SearchFilter{Tags: []string{"go", "redis"}}, []int64{1}},
+		{"Any tag.", &SearchFilter{Tags: []string{"redis", "sql"}, AnyTag: true}, []int64{3, 1}},
+		{"Tag and author.", &SearchFilter{Tags: []string{"go"}, Author: "vt", Order: Ascending}, []int64{1, 2}},
+		{"Tag and query.", &SearchFilter{Tags: []string{"go"}, Query: "test"}, []int64{2, 1}},
+		{"Unknown tag.", &SearchFilter{Tags: []string{"rust"}}, []int64{}},
+	}
+
+	for _, search := range searches {
+		found, _, err := repo.FindMany(ctx, search.filter)
+		if assert.NoError(t, err, search.name) {
+			ids := make([]int64, 0, len(found))
+			for _, post := range found {
+				ids = append(ids, post.ID)
+			}
+			assert.Equal(t, search.expected, ids, search.name)
+		}
+	}
+
+	tags, err := repo.Tags(ctx, &TagFilter{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []TagCount{{Tag: "go", Count: 2}, {Tag: "sql", Count: 1}, {Tag: "redis", Count: 1}}, tags)
+	}
+
+	//Post 2 moves from go to sql, post 3 is removed.
+	_, err = repo.Update(ctx, 2, &Post{Name: "test 2", Author: "vt", CreatedAt: time.Unix(20, 0), Tags: []string{"sql"}}, AnyVersion)
+	assert.NoError(t, err)
+	_, err = repo.Remove(ctx, 3, AnyVersion)
+	assert.NoError(t, err)
+
+	tags, err = repo.Tags(ctx, &TagFilter{Order: Ascending, Limit: 2})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []TagCount{{Tag: "go", Count: 1}, {Tag: "redis", Count: 1}}, tags)
+	}
+
+	found, _, err := repo.FindMany(ctx, &SearchFilter{Tags: []string{"sql"}})
+	if assert.NoError(t, err) && assert.Len(t, found, 1) {
+		assert.Equal(t, int64(2), found[0].ID)
+	}
+
+	_, err = repo.Tags(ctx, &TagFilter{Order: Relevance})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func testTrash(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	seedRepository(t, repo)
+
+	for _, id := range []int64{1, 3, 5} {
+		if _, err := repo.Remove(ctx, id, AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := repo.FindOne(ctx, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.Update(ctx, 1, &Post{Name: "test 1", Author: "vt"}, AnyVersion)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	found, _, err := repo.FindMany(ctx, &SearchFilter{Query: "test"})
+	if assert.NoError(t, err) && assert.Len(t, found, 2) {
+		assert.Equal(t, int64(4), found[0].ID)
+		assert.Equal(t, int64(2), found[1].ID)
+	}
+
+	//Posts removed at the same second are ordered by ID.
+	trashed, next, err := repo.FindTrashed(ctx, &TrashFilter{Limit: 2})
+	if assert.NoError(t, err) && assert.Len(t, trashed, 2) {
+		assert.Equal(t, int64(5), trashed[0].ID)
+		assert.Equal(t, int64(3), trashed[1].ID)
+		assert.NotNil(t, trashed[0].DeletedAt)
+	}
+
+	trashed, next, err = repo.FindTrashed(ctx, &TrashFilter{Limit: 2, Cursor: next})
+	if assert.NoError(t, err) && assert.Len(t, trashed, 1) {
+		assert.Equal(t, int64(1), trashed[0].ID)
+		assert.Equal(t, "", next)
+	}
+
+	restored, err := repo.Restore(ctx, 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Post{ID: 3, Name: "robots", Author: "robot", CreatedAt: time.Unix(20, 0), UpdatedAt: time.Unix(20, 0), Version: 3}, restored)
+	}
+
+	_, err = repo.Restore(ctx, 3)
+	assert.ErrorIs(t, err, ErrConflict)
+	_, err = repo.Restore(ctx, 10)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	found, _, err = repo.FindMany(ctx, &SearchFilter{Query: "robots", Author: "robot"})
+	if assert.NoError(t, err) && assert.Len(t, found, 1) {
+		assert.Equal(t, int64(3), found[0].ID)
+	}
+
+	purged, err := repo.Purge(ctx, testNow.Add(-time.Hour))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), purged)
+	}
+
+	purged, err = repo.Purge(ctx, testNow.Add(time.Hour))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(2), purged)
+	}
+
+	trashed, _, err = repo.FindTrashed(ctx, &TrashFilter{})
+	if assert.NoError(t, err) {
+		assert.Len(t, trashed, 0)
+	}
+
+	_, err = repo.Restore(ctx, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	count, total, err := repo.Count(ctx, &CountFilter{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []AuthorCount{{Author: "robot", Count: 2}, {Author: "vt", Count: 1}}, count)
+		assert.Equal(t, int64(3), total)
+	}
+}
+
+func testRevisions(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	seedRepository(t, repo)
+
+	if _, err := repo.Update(WithEditor(ctx, "admin"), 1, &Post{Name: "test one", Author: "vt", CreatedAt: time.Unix(10, 0), Tags: []string{"go"}}, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Remove(ctx, 1, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Restore(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := repo.Revisions(ctx, 1)
+	if assert.NoError(t, err) && assert.Len(t, revisions, 4) {
+		expected := []struct {
+			action  string
+			editor  string
+			changed []string
+		}{
+			{ActionCreate, "vt", []string{"name", "author", "created_at"}},
+			{ActionUpdate, "admin", []string{"name", "tags"}},
+			{ActionRemove, "vt", []string{"deleted_at"}},
+			{ActionRestore, "vt", []string{"deleted_at"}},
+		}
+
+		for i, rev := range revisions {
+			assert.Equal(t, int64(i+1), rev.Number)
+			assert.Equal(t, expected[i].action, rev.Action)
+			assert.Equal(t, expected[i].editor, rev.Editor)
+			assert.Equal(t, expected[i].changed, rev.Changed)
+			assert.False(t, rev.CreatedAt.IsZero())
+		}
+
+		assert.Equal(t, &Post{ID: 1, Name: "test one", Author: "vt", CreatedAt: time.Unix(10, 0), UpdatedAt: testNow, Tags: []string{"go"}, Version: 2}, revisions[1].Post)
+		assert.NotNil(t, revisions[2].Post.DeletedAt)
+		assert.Nil(t, revisions[3].Post.DeletedAt)
+
+		rev, err := repo.Revision(ctx, 1, 2)
+		if assert.NoError(t, err) {
+			assert.Equal(t, revisions[1], rev)
+		}
+	}
+
+	_, err = repo.Revision(ctx, 1, 5)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.Revision(ctx, 10, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.Revisions(ctx, 10)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	revisions, err = repo.Revisions(ctx, 2)
+	if assert.NoError(t, err) {
+		assert.Len(t, revisions, 1)
+	}
+
+	//Purged posts keep their history, it ends with the purge.
+	if _, err := repo.Remove(ctx, 2, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Purge(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.FindOne(ctx, 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	revisions, err = repo.Revisions(ctx, 2)
+	if assert.NoError(t, err) && assert.Len(t, revisions, 3) {
+		assert.Equal(t, []string{ActionCreate, ActionRemove, ActionPurge}, []string{revisions[0].Action, revisions[1].Action, revisions[2].Action})
+		assert.Equal(t, int64(3), revisions[2].Number)
+		assert.Equal(t, "vt", revisions[2].Editor)
+		assert.Empty(t, revisions[2].Changed)
+		assert.NotNil(t, revisions[2].Post.DeletedAt)
+		assert.Equal(t, int64(3), revisions[2].Post.Version)
+	}
+
+	rev, err := repo.Revision(ctx, 2, 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ActionPurge, rev.Action)
+	}
+
+	//IDs of purged posts aren't reused.
+	results, err := repo.Import(ctx, []*Record{{ID: 2, Post: &Post{Name: "test", Author: "vt", CreatedAt: time.Unix(1, 0)}}})
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.ErrorIs(t, results[0].Err, ErrConflict)
+	}
+}
@@ -0,0 +1,95 @@
+package post
+
+import (
+	"context"
+	"time"
+)
+
+type editorKey struct{}
+
+//WithEditor returns a context that attributes revisions written with it to editor.
+func WithEditor(ctx context.Context, editor string) context.Context {
+	return context.WithValue(ctx, editorKey{}, editor)
+}
+
+//newRevision describes a write that turned old into current. Old is an empty post for created posts.
+func newRevision(ctx context.Context, action string, old, current *Post, at time.Time) *Revision {
+	editor, _ := ctx.Value(editorKey{}).(string)
+	if editor == "" {
+		editor = current.Author
+	}
+
+	return &Revision{
+		Number:    current.Version,
+		Action:    action,
+		Editor:    editor,
+		CreatedAt: storedTime(at),
+		Changed:   changedFields(old, current),
+		Post:      copyPost(current),
+	}
+}
+
+//changedFields returns JSON names of post fields that differ between old and updated.
+func changedFields(old, updated *Post) []string {
+	var changed []string
+	if old.Name != updated.Name {
+		changed = append(changed, "name")
+	}
+	if old.Author != updated.Author {
+		changed = append(changed, "author")
+	}
+	if old.CreatedAt.Unix() != updated.CreatedAt.Unix() {
+		changed = append(changed, "created_at")
+	}
+	if old.Body != updated.Body {
+		changed = append(changed, "body")
+	}
+	if old.Format != updated.Format {
+		changed = append(changed, "format")
+	}
+	if joinTags(old.Tags) != joinTags(updated.Tags) {
+		changed = append(changed, "tags")
+	}
+	if (old.DeletedAt == nil) != (updated.DeletedAt == nil) {
+		changed = append(changed, "deleted_at")
+	}
+
+	return changed
+}
+
+//importedHistory returns the history a record is stored with: its revisions with the record ID and stored times,
+//or a create revision of its post if it has none.
+func importedHistory(ctx context.Context, rec *Record, at time.Time) []*Revision {
+	if len(rec.Revisions) == 0 {
+		created := importedPost(rec.ID, rec.Post, 1)
+		return []*Revision{newRevision(ctx, ActionCreate, &Post{}, created, at)}
+	}
+
+	revisions := make([]*Revision, len(rec.Revisions))
+	for i, rev := range rec.Revisions {
+		revisions[i] = &Revision{
+			Number:    rev.Number,
+			Action:    rev.Action,
+			Editor:    rev.Editor,
+			CreatedAt: storedTime(rev.CreatedAt),
+			Changed:   append([]string(nil), rev.Changed...),
+			Post:      importedPost(rec.ID, rev.Post, rev.Number),
+		}
+	}
+
+	return revisions
+}
+
+//importedPost returns a copy of an imported post the way it's stored, with an ID, a version and stored times.
+//Deletion times are stored in whole seconds.
+func importedPost(id int64, post *Post, version int64) *Post {
+	imported := copyPost(post)
+	imported.ID, imported.Version = id, version
+	imported.CreatedAt, imported.UpdatedAt = storedTime(post.CreatedAt), storedTime(updateTime(post))
+	if post.DeletedAt != nil {
+		deletedAt := time.Unix(post.DeletedAt.Unix(), 0)
+		imported.DeletedAt = &deletedAt
+	}
+
+	return imported
+}
@@ -0,0 +1,257 @@
+package post
+
+import "github.com/go-redis/redis/v8"
+
+//createScript writes the post hash together with its indexes and history, the post version is the number of revisions.
+//The ID is reserved beforehand. It returns the ID, 0 if the ID is already taken or -1 if it belonged to a purged post.
+//An ID higher than next_post_id replaces it, so IDs of imported posts are never reserved again.
+//
+//KEYS: next_post_id, post, revisions, names, authors, author_counts, tag_counts, timeline, timeline:names, timeline:authors,
+//ci:names, ci:authors, lex:names, lex:authors, terms..., tags...
+//ARGV: id, name, author, created_at, updated_at, body, format, joined tags, encoded revisions separated by line breaks,
+//time index score, name key, author key, number of terms, term counts in the order of terms keys..., tags in the order of tags keys...
+var createScript = redis.NewScript(`
+local id = tonumber(ARGV[1])
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return 0
+end
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return -1
+end
+if tonumber(redis.call('GET', KEYS[1]) or '0') < id then
+	redis.call('SET', KEYS[1], id)
+end
+local score = ARGV[10]
+local terms = tonumber(ARGV[13])
+
+local version = 0
+for rev in string.gmatch(ARGV[9], '[^\n]+') do
+	redis.call('RPUSH', KEYS[3], rev)
+	version = version + 1
+end
+
+redis.call('HSET', KEYS[2], 'name', ARGV[2], 'author', ARGV[3], 'created_at', ARGV[4], 'updated_at', ARGV[5],
+	'body', ARGV[6], 'format', ARGV[7], 'tags', ARGV[8], 'version', version)
+redis.call('SADD', KEYS[4], id)
+redis.call('SADD', KEYS[5], id)
+redis.call('ZINCRBY', KEYS[6], 1, ARGV[3])
+redis.call('ZADD', KEYS[8], score, id)
+redis.call('ZADD', KEYS[9], score, id)
+redis.call('ZADD', KEYS[10], score, id)
+redis.call('SADD', KEYS[11], id)
+redis.call('SADD', KEYS[12], id)
+redis.call('ZADD', KEYS[13], 0, ARGV[11])
+redis.call('ZADD', KEYS[14], 0, ARGV[12])
+for i = 15, 14 + terms do
+	redis.call('ZADD', KEYS[i], ARGV[i - 1], id)
+end
+for i = 15 + terms, #KEYS do
+	redis.call('SADD', KEYS[i], id)
+	redis.call('ZINCRBY', KEYS[7], 1, ARGV[i - 1])
+end
+
+return id
+`)
+
+//importRemovedScript writes an imported trashed post, its hash is only indexed in the trash, or only the history
+//of a purged post. It returns the ID, 0 if the ID is already taken or -1 if it belonged to a purged post.
+//The ID replaces next_post_id if it's higher, like in createScript.
+//
+//KEYS: next_post_id, trash, post, revisions
+//ARGV: id, deleted_at, number of hash fields, hash fields and values..., encoded revisions...
+var importRemovedScript = redis.NewScript(`
+local id = tonumber(ARGV[1])
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return 0
+end
+if redis.call('EXISTS', KEYS[4]) == 1 then
+	return -1
+end
+if tonumber(redis.call('GET', KEYS[1]) or '0') < id then
+	redis.call('SET', KEYS[1], id)
+end
+
+local fields = tonumber(ARGV[3]) * 2
+if fields > 0 then
+	redis.call('HSET', KEYS[3], unpack(ARGV, 4, 3 + fields))
+	redis.call('ZADD', KEYS[2], ARGV[2], id)
+end
+for i = 4 + fields, #ARGV do
+	redis.call('RPUSH', KEYS[4], ARGV[i])
+end
+
+return id
+`)
+
+//removeScript moves a post to the trash if it still has the version the indexes were read at. The post hash is kept
+//with a deletion time, the post is removed from all other indexes and added to the trash.
+//It returns 1 on success, 0 if the post doesn't exist or is already trashed and -1 if its version has changed.
+//
+//Name and author keys are dropped from lex sets when no other post has them.
+//
+//KEYS: post, names, authors, author_counts, tag_counts, trash, revisions, ci:names, ci:authors, lex:names, lex:authors,
+//timeline, timeline:names, timeline:authors, terms..., tags...
+//ARGV: id, version, author, deleted_at, encoded revision, name key, author key, number of terms, tags in the order of tags keys...
+var removeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 or redis.call('HEXISTS', KEYS[1], 'deleted_at') == 1 then
+	return 0
+end
+
+if (redis.call('HGET', KEYS[1], 'version') or '0') ~= ARGV[2] then
+	return -1
+end
+
+redis.call('HSET', KEYS[1], 'deleted_at', ARGV[4])
+redis.call('HINCRBY', KEYS[1], 'version', 1)
+redis.call('RPUSH', KEYS[7], ARGV[5])
+redis.call('ZADD', KEYS[6], ARGV[4], ARGV[1])
+redis.call('SREM', KEYS[2], ARGV[1])
+redis.call('SREM', KEYS[3], ARGV[1])
+if tonumber(redis.call('ZINCRBY', KEYS[4], -1, ARGV[3])) <= 0 then
+	redis.call('ZREM', KEYS[4], ARGV[3])
+end
+
+for i = 8, 9 do
+	redis.call('SREM', KEYS[i], ARGV[1])
+	if redis.call('SCARD', KEYS[i]) == 0 then
+		redis.call('ZREM', KEYS[i + 2], ARGV[i - 2])
+	end
+end
+
+local terms = tonumber(ARGV[8])
+for i = 12, 14 + terms do
+	redis.call('ZREM', KEYS[i], ARGV[1])
+end
+for i = 15 + terms, #KEYS do
+	local tag = ARGV[i - 6 - terms]
+	redis.call('SREM', KEYS[i], ARGV[1])
+	if tonumber(redis.call('ZINCRBY', KEYS[5], -1, tag)) <= 0 then
+		redis.call('ZREM', KEYS[5], tag)
+	end
+end
+
+return 1
+`)
+
+//restoreScript moves a trashed post out of the trash and adds it back to its indexes if it still has the version
+//the indexes were read at. It returns 1 on success, 0 if the post doesn't exist, -1 if its version has changed
+//and -2 if it isn't in the trash.
+//
+//KEYS: post, names, authors, author_counts, tag_counts, trash, revisions, ci:names, ci:authors, lex:names, lex:authors,
+//timeline, timeline:names, timeline:authors, terms..., tags...
+//ARGV: id, version, author, created_at, encoded revision, name key, author key, number of terms,
+//term counts in the order of terms keys..., tags in the order of tags keys...
+var restoreScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+
+if (redis.call('HGET', KEYS[1], 'version') or '0') ~= ARGV[2] then
+	return -1
+end
+
+if redis.call('HEXISTS', KEYS[1], 'deleted_at') == 0 then
+	return -2
+end
+
+redis.call('HDEL', KEYS[1], 'deleted_at')
+redis.call('HINCRBY', KEYS[1], 'version', 1)
+redis.call('RPUSH', KEYS[7], ARGV[5])
+redis.call('ZREM', KEYS[6], ARGV[1])
+redis.call('SADD', KEYS[2], ARGV[1])
+redis.call('SADD', KEYS[3], ARGV[1])
+redis.call('ZINCRBY', KEYS[4], 1, ARGV[3])
+redis.call('SADD', KEYS[8], ARGV[1])
+redis.call('SADD', KEYS[9], ARGV[1])
+redis.call('ZADD', KEYS[10], 0, ARGV[6])
+redis.call('ZADD', KEYS[11], 0, ARGV[7])
+redis.call('ZADD', KEYS[12], ARGV[4], ARGV[1])
+redis.call('ZADD', KEYS[13], ARGV[4], ARGV[1])
+redis.call('ZADD', KEYS[14], ARGV[4], ARGV[1])
+
+local terms = tonumber(ARGV[8])
+for i = 15, 14 + terms do
+	redis.call('ZADD', KEYS[i], ARGV[i - 6], ARGV[1])
+end
+for i = 15 + terms, #KEYS do
+	redis.call('SADD', KEYS[i], ARGV[1])
+	redis.call('ZINCRBY', KEYS[5], 1, ARGV[i - 6])
+end
+
+return 1
+`)
+
+//pruneKeyScript drops a name or author key from a lex set if no post has it anymore.
+//
+//KEYS: ci set of the key, lex set
+//ARGV: key
+var pruneKeyScript = redis.NewScript(`
+if redis.call('SCARD', KEYS[1]) == 0 then
+	return redis.call('ZREM', KEYS[2], ARGV[1])
+end
+
+return 0
+`)
+
+//purgeScript deletes a trashed post hash and its trash entry and appends the purge revision to its history if the post is still
+//trashed at the same time and version. It returns 1 if the post was deleted, 0 if the trash entry pointed to a missing or restored post
+//and was dropped and -1 if the post was trashed again and the entry was kept.
+//
+//KEYS: post, trash, revisions
+//ARGV: id, deleted_at, version, encoded revision
+var purgeScript = redis.NewScript(`
+local deletedAt = redis.call('HGET', KEYS[1], 'deleted_at')
+if deletedAt == ARGV[2] and (redis.call('HGET', KEYS[1], 'version') or '0') == ARGV[3] then
+	redis.call('DEL', KEYS[1])
+	redis.call('RPUSH', KEYS[3], ARGV[4])
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	return 1
+end
+
+if deletedAt then
+	return -1
+end
+
+redis.call('ZREM', KEYS[2], ARGV[1])
+return 0
+`)
+
+//repairScript fixes an index entry of a post if the post hasn't been written since it was checked, so entries of posts
+//created, updated or removed in the meantime are left alone. It returns 1 if the entry was fixed and 0 if it was skipped.
+//
+//KEYS: index, post
+//ARGV: id, version the post was checked at or -1 if it didn't exist, command (SADD, SREM, ZADD or ZREM), score
+var repairScript = redis.NewScript(`
+local version = '-1'
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	version = redis.call('HGET', KEYS[2], 'version') or '0'
+end
+
+if version ~= ARGV[2] then
+	return 0
+end
+
+if ARGV[3] == 'ZADD' then
+	redis.call('ZADD', KEYS[1], ARGV[4], ARGV[1])
+else
+	redis.call(ARGV[3], KEYS[1], ARGV[1])
+end
+
+return 1
+`)
+
+//repairCountScript sets the post count of an author or a tag to the size of its post set, or drops it if the set is empty.
+//It returns the count.
+//
+//KEYS: author_counts or tag_counts, authors or tags set
+//ARGV: author or tag
+var repairCountScript = redis.NewScript(`
+local count = redis.call('SCARD', KEYS[2])
+if count == 0 then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+else
+	redis.call('ZADD', KEYS[1], count, ARGV[1])
+end
+
+return count
+`)
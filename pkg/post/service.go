@@ -2,16 +2,88 @@ package post
 
 import (
 	"context"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 //Service is a Post service interface which contains all business logic.
 type Service interface {
+	//Create assigns the creation and update time of a post unless the context was returned by WithImport.
 	Create(context.Context, *Post) (int64, error)
+	//CreateMany validates and creates posts like Create, results are in the order of posts. A failed post doesn't stop
+	//the others unless the batch is atomic, then nothing is created if any post fails and the rest get ErrBatchAborted.
+	//A storage error while an atomic batch is written fails every post, see Repository.CreateMany.
+	CreateMany(context.Context, []*Post, bool) ([]BatchResult, error)
+	//Import validates posts of records like Create and stores them with their IDs, creation and update times. Posts without
+	//a creation time are stamped like in Create. Records keep their history and trash state, a record without a history gets
+	//a create revision. Records with IDs that are already taken, also by purged posts, fail with ErrConflict, the rest are imported.
+	Import(context.Context, []*Record) ([]BatchResult, error)
+	//Export calls the function with a record of every post that isn't trashed and stops at the first error it returns.
+	//Records of a full export also cover trashed and purged posts and include histories.
+	Export(context.Context, bool, func(*Record) error) error
 	FindOne(context.Context, int64) (*Post, error)
-	FindMany(context.Context, *SearchFilter) ([]*Post, error)
-	Remove(context.Context, int64) (bool, error)
+	//FindByIDs returns posts in the order of IDs and the IDs of posts that don't exist or are trashed.
+	FindByIDs(context.Context, []int64) ([]*Post, []int64, error)
+	//FindMany returns a page of posts and a cursor of the next page. The cursor is empty if it's the last page.
+	FindMany(context.Context, *SearchFilter) ([]*Post, string, error)
+	//Update and Remove fail with ErrVersionMismatch unless the version argument is AnyVersion or equals the stored version.
+	//Update keeps the creation time of a post and sets its update time.
+	Update(context.Context, int64, *Post, int64) (*Post, error)
+	//Remove moves a post to the trash. Trashed posts aren't found by other methods until they're restored.
+	Remove(context.Context, int64, int64) (bool, error)
+	//RemoveMany moves posts to the trash regardless of their versions, atomic batches work as in CreateMany.
+	RemoveMany(context.Context, []int64, bool) ([]BatchResult, error)
+	//Restore moves a post out of the trash and returns it. It fails with ErrConflict if the post isn't in the trash.
+	Restore(context.Context, int64) (*Post, error)
+	//FindTrashed returns a page of trashed posts and a cursor of the next page.
+	FindTrashed(context.Context, *TrashFilter) ([]*Post, string, error)
+	//Purge permanently removes posts trashed before the given time and returns their number. Their history is kept and ends
+	//with an ActionPurge revision, their IDs are never reused.
+	Purge(context.Context, time.Time) (int64, error)
+	//Revisions returns the history of a post, the oldest revision first. Purged posts keep their history.
+	Revisions(context.Context, int64) ([]*Revision, error)
+	//Revision returns a revision of a post by its number.
+	Revision(context.Context, int64, int64) (*Revision, error)
+	//Revert updates a post to the state of a revision, it's recorded as a new revision. The version argument works as in Update.
+	Revert(context.Context, int64, int64, int64) (*Post, error)
 	Logger() *zap.SugaredLogger
-	Count(context.Context) (map[string]int, error)
+	//Now returns the current time of the service clock, the one set in Options.
+	Now() time.Time
+	//Count returns post counts of authors matching the filter and the total number of their posts.
+	//Only authors with at least one post are counted.
+	Count(context.Context, *CountFilter) ([]AuthorCount, int64, error)
+	//Tags returns post counts of tags. Only tags of at least one post are counted.
+	Tags(context.Context, *TagFilter) ([]TagCount, error)
+}
+
+//Repository persists posts and searches them. Implementations must agree on filter, ordering, pagination and count semantics,
+//so any of them can back a Service.
+type Repository interface {
+	//Create stores timestamps as is, a post without an update time was last updated on creation.
+	Create(context.Context, *Post) (int64, error)
+	//CreateMany stores posts in chunks, a failed chunk doesn't stop the following ones. Atomic batches are written at once.
+	//Redis transactions don't roll back, a write Redis fails inside one fails the batch but writes of other posts stay.
+	CreateMany(context.Context, []*Post, bool) ([]BatchResult, error)
+	//Import stores records with their IDs like CreateMany, later created posts get higher IDs than any imported one.
+	//Post versions are the numbers of their last revisions.
+	Import(context.Context, []*Record) ([]BatchResult, error)
+	//Export doesn't hold locks or transactions that block writes, posts written while it runs may be left out.
+	Export(context.Context, bool, func(*Record) error) error
+	FindOne(context.Context, int64) (*Post, error)
+	FindByIDs(context.Context, []int64) ([]*Post, []int64, error)
+	FindMany(context.Context, *SearchFilter) ([]*Post, string, error)
+	//Update ignores the creation time of the replacement and stamps the update time with the repository clock.
+	Update(context.Context, int64, *Post, int64) (*Post, error)
+	Remove(context.Context, int64, int64) (bool, error)
+	//RemoveMany trashes posts like Remove with AnyVersion. Nothing is trashed in an atomic batch if any post can't be.
+	RemoveMany(context.Context, []int64, bool) ([]BatchResult, error)
+	Restore(context.Context, int64) (*Post, error)
+	FindTrashed(context.Context, *TrashFilter) ([]*Post, string, error)
+	Purge(context.Context, time.Time) (int64, error)
+	//Writes record a Revision of the written post in the same transaction.
+	Revisions(context.Context, int64) ([]*Revision, error)
+	Revision(context.Context, int64, int64) (*Revision, error)
+	Count(context.Context, *CountFilter) ([]AuthorCount, int64, error)
+	Tags(context.Context, *TagFilter) ([]TagCount, error)
 }
@@ -0,0 +1,1363 @@
+package post
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+//exportPageSize is the number of posts Export reads with a query.
+const exportPageSize = 500
+
+//Supported SQL drivers.
+const (
+	SQLite   = "sqlite3"
+	Postgres = "postgres"
+)
+
+//sqlMigrations are applied in order, the index of a migration is its schema version minus one.
+//{primary_key} is replaced with a driver specific auto-incremented primary key definition.
+var sqlMigrations = []string{
+	`CREATE TABLE posts (
+		id {primary_key},
+		name TEXT NOT NULL,
+		author TEXT NOT NULL,
+		created_at BIGINT NOT NULL,
+		version BIGINT NOT NULL
+	);
+	CREATE INDEX posts_created_at ON posts (created_at);
+	CREATE INDEX posts_author ON posts (author, created_at);
+	CREATE INDEX posts_name ON posts (name, created_at);
+	CREATE TABLE post_terms (
+		term TEXT NOT NULL,
+		post_id BIGINT NOT NULL,
+		frequency INTEGER NOT NULL,
+		PRIMARY KEY (term, post_id)
+	);
+	CREATE INDEX post_terms_post_id ON post_terms (post_id);`,
+	`ALTER TABLE posts ADD COLUMN body TEXT NOT NULL DEFAULT '';
+	ALTER TABLE posts ADD COLUMN format TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE posts ADD COLUMN tags TEXT NOT NULL DEFAULT '';
+	CREATE TABLE post_tags (
+		tag TEXT NOT NULL,
+		post_id BIGINT NOT NULL,
+		PRIMARY KEY (tag, post_id)
+	);
+	CREATE INDEX post_tags_post_id ON post_tags (post_id);`,
+	`ALTER TABLE posts ADD COLUMN deleted_at BIGINT;
+	CREATE INDEX posts_deleted_at ON posts (deleted_at);`,
+	`CREATE TABLE post_revisions (
+		id BIGINT NOT NULL,
+		name TEXT NOT NULL,
+		author TEXT NOT NULL,
+		created_at BIGINT NOT NULL,
+		body TEXT NOT NULL,
+		format TEXT NOT NULL,
+		tags TEXT NOT NULL,
+		deleted_at BIGINT,
+		version BIGINT NOT NULL,
+		action TEXT NOT NULL,
+		editor TEXT NOT NULL,
+		revised_at BIGINT NOT NULL,
+		changed TEXT NOT NULL,
+		PRIMARY KEY (id, version)
+	);`,
+	`ALTER TABLE posts ADD COLUMN created_nsec INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE posts ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE posts ADD COLUMN updated_nsec INTEGER NOT NULL DEFAULT 0;
+	UPDATE posts SET updated_at = created_at;
+	ALTER TABLE post_revisions ADD COLUMN created_nsec INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE post_revisions ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE post_revisions ADD COLUMN updated_nsec INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE post_revisions ADD COLUMN revised_nsec INTEGER NOT NULL DEFAULT 0;
+	UPDATE post_revisions SET updated_at = created_at;`,
+	`ALTER TABLE posts ADD COLUMN name_key TEXT NOT NULL DEFAULT '';
+	ALTER TABLE posts ADD COLUMN author_key TEXT NOT NULL DEFAULT '';
+	CREATE INDEX posts_name_key ON posts (name_key);
+	CREATE INDEX posts_author_key ON posts (author_key);`,
+}
+
+//sqlBackfills fill columns added by a migration with values computed in Go. They run in the migration's transaction
+//after its statements and are keyed by schema version.
+var sqlBackfills = map[int]func(ctx context.Context, tx *sql.Tx) error{
+	7: fillMatchKeys,
+}
+
+//MigrateSQL creates or upgrades the posts schema. It's safe to run multiple times.
+func MigrateSQL(ctx context.Context, db *sql.DB, driver string) error {
+	var primaryKey string
+	switch driver {
+	case SQLite:
+		primaryKey = "INTEGER PRIMARY KEY AUTOINCREMENT"
+	case Postgres:
+		primaryKey = "BIGSERIAL PRIMARY KEY"
+	default:
+		return fmt.Errorf("unsupported SQL driver %q", driver)
+	}
+
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)")
+	if err != nil {
+		return storageError(err)
+	}
+
+	var version int
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return storageError(err)
+	}
+
+	for ; version < len(sqlMigrations); version++ {
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			for _, stmt := range strings.Split(strings.ReplaceAll(sqlMigrations[version], "{primary_key}", primaryKey), ";") {
+				if strings.TrimSpace(stmt) == "" {
+					continue
+				}
+
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+
+			if backfill, ok := sqlBackfills[version+1]; ok {
+				if err := backfill(ctx, tx); err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version+1)
+			return err
+		})
+
+		if err != nil {
+			return fmt.Errorf("migration %v: %w", version+1, err)
+		}
+	}
+
+	return nil
+}
+
+//fillMatchKeys sets name and author keys of posts stored before the key columns were added.
+func fillMatchKeys(ctx context.Context, tx *sql.Tx) error {
+	type keys struct {
+		id           int64
+		name, author string
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, name, author FROM posts")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	//Rows are read before updating, some drivers can't run a statement while a result set is open.
+	var posts []keys
+	for rows.Next() {
+		var k keys
+		if err := rows.Scan(&k.id, &k.name, &k.author); err != nil {
+			return err
+		}
+		posts = append(posts, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, k := range posts {
+		_, err := tx.ExecContext(ctx, "UPDATE posts SET name_key = $1, author_key = $2 WHERE id = $3", matchKey(k.name), matchKey(k.author), k.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//sqlRepository stores posts in a relational database. Name terms are kept in a separate table for full-text search
+//and tags are indexed in another one. Trashed posts have deleted_at set and aren't in the term and tag tables.
+//Every written state of a post is copied to post_revisions, its post columns match posts.
+//Times are stored as Unix seconds, indexed and compared, and nanoseconds within the second in *_nsec columns.
+type sqlRepository struct {
+	db     *sql.DB
+	driver string
+	now    func() time.Time
+}
+
+//NewSQLRepository creates a repository backed by a SQL database migrated with MigrateSQL.
+func NewSQLRepository(db *sql.DB, driver string) Repository {
+	return sqlRepository{db, driver, time.Now}
+}
+
+func (sr sqlRepository) withClock(now func() time.Time) Repository {
+	return sqlRepository{sr.db, sr.driver, now}
+}
+
+func (sr sqlRepository) Create(ctx context.Context, post *Post) (int64, error) {
+	var id int64
+	err := inTx(ctx, sr.db, func(tx *sql.Tx) error {
+		var err error
+		id, err = sr.insertPost(ctx, tx, 0, post)
+		return err
+	})
+
+	if err != nil {
+		return 0, storageError(err)
+	}
+
+	return id, nil
+}
+
+//CreateMany writes every chunk of posts in a transaction, a failed chunk is rolled back.
+func (sr sqlRepository) CreateMany(ctx context.Context, posts []*Post, atomic bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(posts))
+	forChunks(len(posts), atomic, func(start, end int) {
+		err := inTx(ctx, sr.db, func(tx *sql.Tx) error {
+			for i := start; i < end; i++ {
+				id, err := sr.insertPost(ctx, tx, 0, posts[i])
+				if err != nil {
+					return err
+				}
+				results[i].ID = id
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			failBatch(results[start:end], storageError(err))
+		}
+	})
+
+	return results, nil
+}
+
+//Import writes every chunk of records with their IDs in a transaction. Taken IDs don't roll back the rest of a chunk,
+//other errors fail the whole chunk. Postgres ID sequence is moved past imported IDs.
+func (sr sqlRepository) Import(ctx context.Context, records []*Record) ([]BatchResult, error) {
+	results := make([]BatchResult, len(records))
+	forChunks(len(records), false, func(start, end int) {
+		err := inTx(ctx, sr.db, func(tx *sql.Tx) error {
+			var lastPurged int64
+			for i := start; i < end; i++ {
+				rec := records[i]
+				var exists, purged bool
+				err := tx.QueryRowContext(ctx,
+					"SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1), EXISTS (SELECT 1 FROM post_revisions WHERE id = $1)", rec.ID,
+				).Scan(&exists, &purged)
+				if err != nil {
+					return err
+				}
+
+				if exists {
+					results[i].Err = Errorf(ErrConflict, "post %v already exists", rec.ID)
+					continue
+				}
+
+				if purged {
+					results[i].Err = purgedID(rec.ID)
+					continue
+				}
+
+				history := importedHistory(ctx, rec, sr.now())
+				if rec.Post != nil {
+					if _, err := sr.insertRow(ctx, tx, importedPost(rec.ID, rec.Post, history[len(history)-1].Number)); err != nil {
+						return err
+					}
+				} else if rec.ID > lastPurged {
+					lastPurged = rec.ID
+				}
+
+				for _, rev := range history {
+					if err := insertRevision(ctx, tx, rev); err != nil {
+						return err
+					}
+				}
+				results[i].ID = rec.ID
+			}
+
+			//SQLite AUTOINCREMENT keeps track of the highest ID of posts by itself, purged posts only have revisions.
+			if sr.driver == Postgres {
+				_, err := tx.ExecContext(ctx,
+					"SELECT setval(pg_get_serial_sequence('posts', 'id'), GREATEST((SELECT MAX(id) FROM posts), (SELECT MAX(id) FROM post_revisions)))",
+				)
+				return err
+			}
+
+			if lastPurged != 0 {
+				return reserveSQLiteID(ctx, tx, lastPurged)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			failBatch(results[start:end], storageError(err))
+		}
+	})
+
+	return results, nil
+}
+
+//reserveSQLiteID moves the SQLite AUTOINCREMENT counter of posts to id unless it's already past it.
+func reserveSQLiteID(ctx context.Context, tx *sql.Tx, id int64) error {
+	res, err := tx.ExecContext(ctx, "UPDATE sqlite_sequence SET seq = MAX(seq, $1) WHERE name = 'posts'", id)
+	if err != nil {
+		return err
+	}
+
+	//The counter row is created by the first insert.
+	if n, err := res.RowsAffected(); err != nil || n != 0 {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO sqlite_sequence (name, seq) VALUES ('posts', $1)", id)
+	return err
+}
+
+//insertPost stores a post with its terms, tags and first revision and returns its ID. The ID is generated unless id isn't zero.
+func (sr sqlRepository) insertPost(ctx context.Context, tx *sql.Tx, id int64, post *Post) (int64, error) {
+	created := *post
+	created.ID, created.CreatedAt, created.UpdatedAt, created.DeletedAt, created.Version = id, storedTime(post.CreatedAt), storedTime(updateTime(post)), nil, 1
+	id, err := sr.insertRow(ctx, tx, &created)
+	if err != nil {
+		return 0, err
+	}
+
+	created.ID = id
+	return id, insertRevision(ctx, tx, newRevision(ctx, ActionCreate, &Post{}, &created, sr.now()))
+}
+
+//insertRow stores a post as is with its terms and tags and returns its ID, it's generated if the post has none.
+//Trashed posts aren't added to term and tag indexes.
+func (sr sqlRepository) insertRow(ctx context.Context, tx *sql.Tx, post *Post) (int64, error) {
+	var deletedAt sql.NullInt64
+	if post.DeletedAt != nil {
+		deletedAt = sql.NullInt64{Int64: post.DeletedAt.Unix(), Valid: true}
+	}
+
+	columns := "name, author, name_key, author_key, created_at, created_nsec, updated_at, updated_nsec, body, format, tags, deleted_at, version"
+	args := []interface{}{
+		post.Name, post.Author, matchKey(post.Name), matchKey(post.Author),
+		post.CreatedAt.Unix(), post.CreatedAt.Nanosecond(), post.UpdatedAt.Unix(), post.UpdatedAt.Nanosecond(),
+		post.Body, post.Format, joinTags(post.Tags), deletedAt, post.Version,
+	}
+
+	//SQLite numbers parameters in the order they appear, so the ID goes last.
+	if post.ID != 0 {
+		columns += ", id"
+		args = append(args, post.ID)
+	}
+
+	placeholders := make([]string, len(args))
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%v", i+1)
+	}
+	insert := "INSERT INTO posts (" + columns + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+
+	//SQLite doesn't support RETURNING.
+	id := post.ID
+	if sr.driver == Postgres {
+		if err := tx.QueryRowContext(ctx, insert+" RETURNING id", args...).Scan(&id); err != nil {
+			return 0, err
+		}
+	} else {
+		res, err := tx.ExecContext(ctx, insert, args...)
+		if err != nil {
+			return 0, err
+		}
+
+		if id, err = res.LastInsertId(); err != nil {
+			return 0, err
+		}
+	}
+
+	if post.DeletedAt != nil {
+		return id, nil
+	}
+
+	if err := insertTerms(ctx, tx, id, post.Name); err != nil {
+		return 0, err
+	}
+
+	return id, insertTags(ctx, tx, id, post.Tags)
+}
+
+func (sr sqlRepository) FindOne(ctx context.Context, id int64) (*Post, error) {
+	post, err := scanPost(sr.db.QueryRowContext(ctx, "SELECT "+postColumns(false)+" FROM posts p WHERE p.id = $1 AND p.deleted_at IS NULL", id))
+	if err == sql.ErrNoRows {
+		return nil, notFound(id)
+	}
+
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	return post, nil
+}
+
+//Export reads pages of posts ordered by ID and calls fn between queries, so a slow reader doesn't hold a connection.
+func (sr sqlRepository) Export(ctx context.Context, full bool, fn func(*Record) error) error {
+	var after int64
+	for {
+		records, err := sr.exportPage(ctx, full, after)
+		if err != nil {
+			return storageError(err)
+		}
+
+		for _, rec := range records {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+
+		if len(records) < exportPageSize {
+			return nil
+		}
+		after = records[len(records)-1].ID
+	}
+}
+
+//exportPage reads records of at most exportPageSize posts with IDs greater than after.
+func (sr sqlRepository) exportPage(ctx context.Context, full bool, after int64) ([]*Record, error) {
+	records := make([]*Record, 0, exportPageSize)
+	if !full {
+		err := queryRows(ctx, sr.db, func(rows *sql.Rows) error {
+			post, err := scanPost(rows)
+			if err == nil {
+				records = append(records, &Record{ID: post.ID, Post: post})
+			}
+
+			return err
+		}, "SELECT "+postColumns(false)+" FROM posts p WHERE p.id > $1 AND p.deleted_at IS NULL ORDER BY p.id LIMIT $2", after, exportPageSize)
+
+		return records, err
+	}
+
+	//Purged posts only have their history.
+	byID := make(map[int64]*Record, exportPageSize)
+	err := queryRows(ctx, sr.db, func(rows *sql.Rows) error {
+		var rec Record
+		if err := rows.Scan(&rec.ID); err != nil {
+			return err
+		}
+
+		records = append(records, &rec)
+		byID[rec.ID] = &rec
+		return nil
+	}, "SELECT id FROM posts WHERE id > $1 UNION SELECT id FROM post_revisions WHERE id > $1 ORDER BY id LIMIT $2", after, exportPageSize)
+	if err != nil || len(records) == 0 {
+		return records, err
+	}
+
+	placeholders := make([]string, len(records))
+	args := make([]interface{}, len(records))
+	for i, rec := range records {
+		placeholders[i] = fmt.Sprintf("$%v", i+1)
+		args[i] = rec.ID
+	}
+	in := "p.id IN (" + strings.Join(placeholders, ", ") + ")"
+
+	err = queryRows(ctx, sr.db, func(rows *sql.Rows) error {
+		post, err := scanPost(rows)
+		if err == nil {
+			byID[post.ID].Post = post
+		}
+
+		return err
+	}, "SELECT "+postColumns(false)+" FROM posts p WHERE "+in, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, sr.db, func(rows *sql.Rows) error {
+		rev, err := scanRevision(rows)
+		if err == nil {
+			rec := byID[rev.Post.ID]
+			rec.Revisions = append(rec.Revisions, rev)
+		}
+
+		return err
+	}, "SELECT "+revisionColumns+" FROM post_revisions p WHERE "+in+" ORDER BY p.id, p.version", args...)
+
+	return records, err
+}
+
+//FindByIDs reads posts in chunks, so queries stay within the SQLite limit of parameters.
+func (sr sqlRepository) FindByIDs(ctx context.Context, ids []int64) ([]*Post, []int64, error) {
+	var err error
+	found := make(map[int64]*Post, len(ids))
+	forChunks(len(ids), false, func(start, end int) {
+		if err == nil {
+			err = sr.findChunk(ctx, ids[start:end], found)
+		}
+	})
+
+	if err != nil {
+		return nil, nil, storageError(err)
+	}
+
+	posts, missing := splitFound(ids, found)
+	return posts, missing, nil
+}
+
+//findChunk reads posts that aren't trashed to found.
+func (sr sqlRepository) findChunk(ctx context.Context, ids []int64, found map[int64]*Post) error {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%v", i+1)
+		args[i] = id
+	}
+
+	rows, err := sr.db.QueryContext(ctx, "SELECT "+postColumns(false)+" FROM posts p WHERE p.id IN ("+strings.Join(placeholders, ", ")+") AND p.deleted_at IS NULL", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return err
+		}
+		found[post.ID] = post
+	}
+
+	return rows.Err()
+}
+
+func (sr sqlRepository) FindMany(ctx context.Context, filter *SearchFilter) ([]*Post, string, error) {
+	after, err := pageStart(filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		entries []cursor
+		posts   map[int64]*Post
+	)
+	if filter.Query != "" {
+		entries, posts, err = sr.searchTerms(ctx, filter, after)
+	} else {
+		entries, posts, err = sr.scanTimeline(ctx, filter, after)
+	}
+
+	if err != nil {
+		return nil, "", storageError(err)
+	}
+
+	entries, next := page(entries, filter.Limit)
+	found := make([]*Post, 0, len(entries))
+	for _, entry := range entries {
+		found = append(found, posts[entry.id])
+	}
+
+	return found, next, nil
+}
+
+//sqlWhere builds WHERE conditions of name, author, time and tag filters. Non-exact name and author matches use the *_key columns. Placeholders are numbered after args.
+//Trashed posts never match.
+func sqlWhere(filter *SearchFilter, args []interface{}) ([]string, []interface{}) {
+	conds := []string{"p.deleted_at IS NULL"}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	for _, f := range []struct{ column, value string }{{"name", filter.Name}, {"author", filter.Author}} {
+		if f.value == "" {
+			continue
+		}
+
+		switch filter.Match {
+		case MatchCaseInsensitive:
+			add("p."+f.column+"_key = $%v", matchKey(f.value))
+		case MatchPrefix:
+			add("p."+f.column+`_key LIKE $%v ESCAPE '\'`, escapeLike(matchKey(f.value))+"%")
+		default:
+			add("p."+f.column+" = $%v", f.value)
+		}
+	}
+
+	//Creation time is compared by seconds first, so the indexed column narrows the range.
+	if !filter.CreatedAfter.IsZero() {
+		args = append(args, filter.CreatedAfter.Unix(), filter.CreatedAfter.Nanosecond())
+		conds = append(conds, fmt.Sprintf("p.created_at >= $%[1]v AND (p.created_at > $%[1]v OR p.created_nsec >= $%[2]v)", len(args)-1, len(args)))
+	}
+
+	if !filter.CreatedBefore.IsZero() {
+		args = append(args, filter.CreatedBefore.Unix(), filter.CreatedBefore.Nanosecond())
+		conds = append(conds, fmt.Sprintf("p.created_at <= $%[1]v AND (p.created_at < $%[1]v OR p.created_nsec < $%[2]v)", len(args)-1, len(args)))
+	}
+
+	if len(filter.Tags) != 0 {
+		placeholders := make([]string, len(filter.Tags))
+		for i, tag := range filter.Tags {
+			args = append(args, tag)
+			placeholders[i] = fmt.Sprintf("$%v", len(args))
+		}
+
+		cond := "p.id IN (SELECT post_id FROM post_tags WHERE tag IN (" + strings.Join(placeholders, ", ") + ")"
+		if !filter.AnyTag {
+			cond += fmt.Sprintf(" GROUP BY post_id HAVING COUNT(*) = %d", len(filter.Tags))
+		}
+		conds = append(conds, cond+")")
+	}
+
+	return conds, args
+}
+
+//scanTimeline returns up to filter's limit plus one entries matching filter after a cursor and their posts.
+//Ties are broken by IDs compared as decimal strings, so the order is the same as the one of Redis time indexes.
+func (sr sqlRepository) scanTimeline(ctx context.Context, filter *SearchFilter, after *cursor) ([]cursor, map[int64]*Post, error) {
+	conds, args := sqlWhere(filter, nil)
+
+	cmp, dir := ">", "ASC"
+	if filter.Order == Descending {
+		cmp, dir = "<", "DESC"
+	}
+
+	if after != nil {
+		args = append(args, after.createdAt.Unix(), after.createdAt.Nanosecond(), fmt.Sprint(after.id))
+		conds = append(conds, fmt.Sprintf(
+			"(p.created_at %[1]v $%[2]v OR (p.created_at = $%[2]v AND (p.created_nsec %[1]v $%[3]v OR (p.created_nsec = $%[3]v AND CAST(p.id AS TEXT) %[1]v $%[4]v))))",
+			cmp, len(args)-2, len(args)-1, len(args),
+		))
+	}
+
+	query := "SELECT " + postColumns(filter.OmitBody) + " FROM posts p WHERE " + strings.Join(conds, " AND ")
+	query += fmt.Sprintf(" ORDER BY p.created_at %[1]v, p.created_nsec %[1]v, CAST(p.id AS TEXT) %[1]v", dir)
+
+	//One extra row tells whether there's a next page.
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit+1)
+	}
+
+	rows, err := sr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var (
+		entries []cursor
+		posts   = make(map[int64]*Post)
+	)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		posts[post.ID] = post
+		entries = append(entries, cursor{order: filter.Order, createdAt: post.CreatedAt, id: post.ID})
+	}
+
+	return entries, posts, rows.Err()
+}
+
+//searchTerms ranks posts whose names contain any of filter's query terms with the same TF-IDF relevance
+//as the Redis term index and returns every match after a cursor in filter's order and their posts.
+func (sr sqlRepository) searchTerms(ctx context.Context, filter *SearchFilter, after *cursor) ([]cursor, map[int64]*Post, error) {
+	terms := tokenize(filter.Query)
+	if len(terms) == 0 {
+		return nil, nil, nil
+	}
+
+	var total int64
+	if err := sr.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM posts WHERE deleted_at IS NULL").Scan(&total); err != nil {
+		return nil, nil, err
+	}
+
+	placeholders := make([]string, len(terms))
+	args := make([]interface{}, len(terms))
+	weights := make(map[string]float64, len(terms))
+	for i, t := range terms {
+		placeholders[i] = fmt.Sprintf("$%v", i+1)
+		args[i] = t.value
+		weights[t.value] = float64(t.count)
+	}
+	in := strings.Join(placeholders, ", ")
+
+	//Document frequency ignores the rest of the filter, just like the size of a Redis term index.
+	rows, err := sr.db.QueryContext(ctx, "SELECT term, COUNT(*) FROM post_terms WHERE term IN ("+in+") GROUP BY term", args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	idf := make(map[string]float64, len(terms))
+	for rows.Next() {
+		var (
+			term string
+			df   int64
+		)
+		if err := rows.Scan(&term, &df); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		idf[term] = math.Log(1 + float64(total)/float64(df))
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	conds, args := sqlWhere(filter, args)
+	query := "SELECT t.term, t.frequency, " + postColumns(filter.OmitBody) + " FROM post_terms t JOIN posts p ON p.id = t.post_id WHERE t.term IN (" + in + ") AND " +
+		strings.Join(conds, " AND ")
+
+	rows, err = sr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var (
+		entries   []cursor
+		posts     = make(map[int64]*Post)
+		relevance = make(map[int64]float64)
+	)
+	for rows.Next() {
+		var (
+			term  string
+			count int64
+		)
+		post, err := scanPost(rows, &term, &count)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, ok := posts[post.ID]; !ok {
+			posts[post.ID] = post
+			entries = append(entries, cursor{order: filter.Order, createdAt: post.CreatedAt, id: post.ID})
+		}
+		relevance[post.ID] += float64(count) * idf[term] * weights[term]
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return orderEntries(entries, relevance, after), posts, nil
+}
+
+func (sr sqlRepository) Update(ctx context.Context, id int64, post *Post, version int64) (*Post, error) {
+	var updated *Post
+	err := inTx(ctx, sr.db, func(tx *sql.Tx) error {
+		old, err := scanPost(tx.QueryRowContext(ctx, "SELECT "+postColumns(false)+" FROM posts p WHERE p.id = $1 AND p.deleted_at IS NULL", id))
+		if err == sql.ErrNoRows {
+			return notFound(id)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if version != AnyVersion && version != old.Version {
+			return ErrVersionMismatch
+		}
+
+		//The version condition fails if the post was changed after it was read.
+		now := storedTime(sr.now())
+		res, err := tx.ExecContext(ctx,
+			`UPDATE posts SET name = $1, author = $2, name_key = $3, author_key = $4, updated_at = $5, updated_nsec = $6,
+			body = $7, format = $8, tags = $9, version = version + 1
+			WHERE id = $10 AND version = $11`,
+			post.Name, post.Author, matchKey(post.Name), matchKey(post.Author), now.Unix(), now.Nanosecond(),
+			post.Body, post.Format, joinTags(post.Tags), id, old.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return Errorf(ErrConflict, "post was changed concurrently, try again")
+		}
+
+		if old.Name != post.Name {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM post_terms WHERE post_id = $1", id); err != nil {
+				return err
+			}
+
+			if err := insertTerms(ctx, tx, id, post.Name); err != nil {
+				return err
+			}
+		}
+
+		if joinTags(old.Tags) != joinTags(post.Tags) {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM post_tags WHERE post_id = $1", id); err != nil {
+				return err
+			}
+
+			if err := insertTags(ctx, tx, id, post.Tags); err != nil {
+				return err
+			}
+		}
+
+		updated = &Post{
+			ID:        id,
+			Name:      post.Name,
+			Author:    post.Author,
+			CreatedAt: old.CreatedAt,
+			UpdatedAt: storedTime(now),
+			Body:      post.Body,
+			Format:    post.Format,
+			Tags:      post.Tags,
+			Version:   old.Version + 1,
+		}
+		return insertRevision(ctx, tx, newRevision(ctx, ActionUpdate, old, updated, now))
+	})
+
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	return updated, nil
+}
+
+func (sr sqlRepository) Remove(ctx context.Context, id int64, version int64) (bool, error) {
+	err := inTx(ctx, sr.db, func(tx *sql.Tx) error {
+		return sr.trash(ctx, tx, id, version)
+	})
+
+	if err != nil {
+		return false, storageError(err)
+	}
+
+	return true, nil
+}
+
+//RemoveMany trashes every chunk of posts in a transaction. Posts that can't be removed don't roll back the rest of a chunk
+//unless the batch is atomic, other errors fail the whole chunk.
+func (sr sqlRepository) RemoveMany(ctx context.Context, ids []int64, atomic bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ids))
+	forChunks(len(ids), atomic, func(start, end int) {
+		err := inTx(ctx, sr.db, func(tx *sql.Tx) error {
+			for i := start; i < end; i++ {
+				err := sr.trash(ctx, tx, ids[i], AnyVersion)
+				switch {
+				case err == nil:
+					results[i].ID = ids[i]
+				case errors.Is(err, ErrNotFound), errors.Is(err, ErrConflict):
+					results[i].Err = err
+				default:
+					return err
+				}
+			}
+
+			if atomic && failed(results[start:end]) {
+				return ErrBatchAborted
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			failBatch(results[start:end], storageError(err))
+		}
+	})
+
+	return results, nil
+}
+
+//trash moves a post to the trash if it has the version.
+func (sr sqlRepository) trash(ctx context.Context, tx *sql.Tx, id int64, version int64) error {
+	post, err := scanPost(tx.QueryRowContext(ctx, "SELECT "+postColumns(false)+" FROM posts p WHERE p.id = $1 AND p.deleted_at IS NULL", id))
+	if err == sql.ErrNoRows {
+		return notFound(id)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if version != AnyVersion && version != post.Version {
+		return ErrVersionMismatch
+	}
+
+	now := sr.now()
+	res, err := tx.ExecContext(ctx,
+		"UPDATE posts SET deleted_at = $1, version = version + 1 WHERE id = $2 AND version = $3",
+		now.Unix(), id, post.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return Errorf(ErrConflict, "post was changed concurrently, try again")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM post_terms WHERE post_id = $1", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM post_tags WHERE post_id = $1", id); err != nil {
+		return err
+	}
+
+	deletedAt := time.Unix(now.Unix(), 0)
+	trashed := copyPost(post)
+	trashed.DeletedAt = &deletedAt
+	trashed.Version++
+	return insertRevision(ctx, tx, newRevision(ctx, ActionRemove, post, trashed, now))
+}
+
+func (sr sqlRepository) Restore(ctx context.Context, id int64) (*Post, error) {
+	var restored *Post
+	err := inTx(ctx, sr.db, func(tx *sql.Tx) error {
+		post, err := scanPost(tx.QueryRowContext(ctx, "SELECT "+postColumns(false)+" FROM posts p WHERE p.id = $1", id))
+		if err == sql.ErrNoRows {
+			return notFound(id)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if post.DeletedAt == nil {
+			return notTrashed(id)
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE posts SET deleted_at = NULL, version = version + 1 WHERE id = $1 AND version = $2", id, post.Version)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return Errorf(ErrConflict, "post was changed concurrently, try again")
+		}
+
+		if err := insertTerms(ctx, tx, id, post.Name); err != nil {
+			return err
+		}
+
+		if err := insertTags(ctx, tx, id, post.Tags); err != nil {
+			return err
+		}
+
+		restored = copyPost(post)
+		restored.DeletedAt = nil
+		restored.Version++
+		return insertRevision(ctx, tx, newRevision(ctx, ActionRestore, post, restored, sr.now()))
+	})
+
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	return restored, nil
+}
+
+func (sr sqlRepository) FindTrashed(ctx context.Context, filter *TrashFilter) ([]*Post, string, error) {
+	after, err := trashStart(filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var args []interface{}
+	query := "SELECT " + postColumns(false) + " FROM posts p WHERE p.deleted_at IS NOT NULL"
+	if after != nil {
+		//Deletion time is stored in whole seconds.
+		args = append(args, after.createdAt.Unix(), fmt.Sprint(after.id))
+		query += " AND (p.deleted_at < $1 OR (p.deleted_at = $1 AND CAST(p.id AS TEXT) < $2))"
+	}
+	query += " ORDER BY p.deleted_at DESC, CAST(p.id AS TEXT) DESC"
+
+	//One extra row tells whether there's a next page.
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit+1)
+	}
+
+	rows, err := sr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", storageError(err)
+	}
+	defer rows.Close()
+
+	var (
+		entries []cursor
+		posts   = make(map[int64]*Post)
+	)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, "", err
+		}
+
+		posts[post.ID] = post
+		entries = append(entries, cursor{order: Descending, createdAt: *post.DeletedAt, id: post.ID})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", storageError(err)
+	}
+
+	entries, next := page(entries, filter.Limit)
+	found := make([]*Post, 0, len(entries))
+	for _, entry := range entries {
+		found = append(found, posts[entry.id])
+	}
+
+	return found, next, nil
+}
+
+func (sr sqlRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	//Deletion time is stored in whole seconds, so a fractional bound is rounded up.
+	bound := before.Unix()
+	if before.Nanosecond() != 0 {
+		bound++
+	}
+
+	var purged int64
+	err := inTx(ctx, sr.db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, "SELECT "+postColumns(false)+" FROM posts p WHERE p.deleted_at IS NOT NULL AND p.deleted_at < $1", bound)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		//Rows are read before writing, some drivers can't run a statement while a result set is open.
+		var posts []*Post
+		for rows.Next() {
+			post, err := scanPost(rows)
+			if err != nil {
+				return err
+			}
+			posts = append(posts, post)
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, post := range posts {
+			final := copyPost(post)
+			final.Version++
+			if err := insertRevision(ctx, tx, newRevision(ctx, ActionPurge, post, final, sr.now())); err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, "DELETE FROM posts WHERE id = $1", post.ID); err != nil {
+				return err
+			}
+		}
+
+		purged = int64(len(posts))
+		return nil
+	})
+
+	if err != nil {
+		return 0, storageError(err)
+	}
+
+	return purged, nil
+}
+
+func (sr sqlRepository) Revisions(ctx context.Context, id int64) ([]*Revision, error) {
+	//Purged posts only have their history.
+	var exists bool
+	if err := sr.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1) OR EXISTS (SELECT 1 FROM post_revisions WHERE id = $1)", id).Scan(&exists); err != nil {
+		return nil, storageError(err)
+	}
+
+	if !exists {
+		return nil, notFound(id)
+	}
+
+	rows, err := sr.db.QueryContext(ctx, "SELECT "+revisionColumns+" FROM post_revisions p WHERE p.id = $1 ORDER BY p.version", id)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	defer rows.Close()
+
+	revisions := make([]*Revision, 0)
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, rev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, storageError(err)
+	}
+
+	return revisions, nil
+}
+
+func (sr sqlRepository) Revision(ctx context.Context, id int64, n int64) (*Revision, error) {
+	rev, err := scanRevision(sr.db.QueryRowContext(ctx, "SELECT "+revisionColumns+" FROM post_revisions p WHERE p.id = $1 AND p.version = $2", id, n))
+	if err == nil {
+		return rev, nil
+	}
+
+	if err != sql.ErrNoRows {
+		return nil, storageError(err)
+	}
+
+	var exists bool
+	if err := sr.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1) OR EXISTS (SELECT 1 FROM post_revisions WHERE id = $1)", id).Scan(&exists); err != nil {
+		return nil, storageError(err)
+	}
+
+	if !exists {
+		return nil, notFound(id)
+	}
+
+	return nil, revisionNotFound(id, n)
+}
+
+func (sr sqlRepository) Count(ctx context.Context, filter *CountFilter) ([]AuthorCount, int64, error) {
+	if err := checkCountFilter(filter); err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		where = " WHERE deleted_at IS NULL"
+		args  []interface{}
+	)
+	switch {
+	case filter.Author != "" && filter.Match == MatchCaseInsensitive:
+		where += " AND author_key = $1"
+		args = append(args, matchKey(filter.Author))
+	case filter.Author != "":
+		where += " AND author = $1"
+		args = append(args, filter.Author)
+	}
+
+	var total int64
+	if err := sr.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM posts"+where, args...).Scan(&total); err != nil {
+		return nil, 0, storageError(err)
+	}
+
+	//Every spelling of the author is counted as the filter.
+	if filter.Author != "" && filter.Match == MatchCaseInsensitive {
+		if total == 0 {
+			return []AuthorCount{}, 0, nil
+		}
+
+		return []AuthorCount{{Author: filter.Author, Count: total}}, total, nil
+	}
+
+	dir := "DESC"
+	if filter.Order == Ascending {
+		dir = "ASC"
+	}
+
+	query := fmt.Sprintf("SELECT author, COUNT(*) FROM posts%[1]v GROUP BY author ORDER BY COUNT(*) %[2]v, author %[2]v", where, dir)
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := sr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, storageError(err)
+	}
+	defer rows.Close()
+
+	authors := make([]AuthorCount, 0)
+	for rows.Next() {
+		var count AuthorCount
+		if err := rows.Scan(&count.Author, &count.Count); err != nil {
+			return nil, 0, err
+		}
+
+		authors = append(authors, count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, storageError(err)
+	}
+
+	return authors, total, nil
+}
+
+func (sr sqlRepository) Tags(ctx context.Context, filter *TagFilter) ([]TagCount, error) {
+	if err := checkTagFilter(filter); err != nil {
+		return nil, err
+	}
+
+	dir := "DESC"
+	if filter.Order == Ascending {
+		dir = "ASC"
+	}
+
+	query := fmt.Sprintf("SELECT tag, COUNT(*) FROM post_tags GROUP BY tag ORDER BY COUNT(*) %[1]v, tag %[1]v", dir)
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := sr.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	defer rows.Close()
+
+	tags := make([]TagCount, 0)
+	for rows.Next() {
+		var count TagCount
+		if err := rows.Scan(&count.Tag, &count.Count); err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, storageError(err)
+	}
+
+	return tags, nil
+}
+
+//insertTags adds tags of a post to the tag index.
+func insertTags(ctx context.Context, tx *sql.Tx, id int64, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO post_tags (tag, post_id) VALUES ($1, $2)", tag, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//insertRevision adds a revision to the post history.
+func insertRevision(ctx context.Context, tx *sql.Tx, rev *Revision) error {
+	post := rev.Post
+
+	var deletedAt sql.NullInt64
+	if post.DeletedAt != nil {
+		deletedAt = sql.NullInt64{Int64: post.DeletedAt.Unix(), Valid: true}
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO post_revisions (id, name, author, created_at, created_nsec, updated_at, updated_nsec, body, format, tags, deleted_at, version,
+			action, editor, revised_at, revised_nsec, changed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		post.ID, post.Name, post.Author, post.CreatedAt.Unix(), post.CreatedAt.Nanosecond(), post.UpdatedAt.Unix(), post.UpdatedAt.Nanosecond(),
+		post.Body, post.Format, joinTags(post.Tags), deletedAt, post.Version,
+		rev.Action, rev.Editor, rev.CreatedAt.Unix(), rev.CreatedAt.Nanosecond(), strings.Join(rev.Changed, ","),
+	)
+	return err
+}
+
+//insertTerms adds name terms of a post to the full-text index.
+func insertTerms(ctx context.Context, tx *sql.Tx, id int64, name string) error {
+	for _, t := range tokenize(name) {
+		_, err := tx.ExecContext(ctx, "INSERT INTO post_terms (term, post_id, frequency) VALUES ($1, $2, $3)", t.value, id, t.count)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//queryRows runs a query and calls fn with every row until it fails.
+func queryRows(ctx context.Context, db *sql.DB, fn func(*sql.Rows) error, query string, args ...interface{}) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+//escapeLike escapes LIKE wildcards in s with backslashes.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+//inTx runs txf in a transaction. The transaction is committed if txf succeeds and rolled back otherwise.
+func inTx(ctx context.Context, db *sql.DB, txf func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := txf(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//postColumns returns columns of posts table aliased as p in the order read by scanPost.
+func postColumns(omitBody bool) string {
+	if omitBody {
+		return "p.id, p.name, p.author, p.created_at, p.created_nsec, p.updated_at, p.updated_nsec, '' AS body, p.format, p.tags, p.deleted_at, p.version"
+	}
+
+	return "p.id, p.name, p.author, p.created_at, p.created_nsec, p.updated_at, p.updated_nsec, p.body, p.format, p.tags, p.deleted_at, p.version"
+}
+
+//scanPost reads a row of postColumns preceded by columns read to dest.
+func scanPost(row interface{ Scan(...interface{}) error }, dest ...interface{}) (*Post, error) {
+	var (
+		post                     Post
+		created, updated         int64
+		createdNsec, updatedNsec int64
+		tags                     string
+		deletedAt                sql.NullInt64
+	)
+	dest = append(dest, &post.ID, &post.Name, &post.Author, &created, &createdNsec, &updated, &updatedNsec, &post.Body, &post.Format, &tags, &deletedAt, &post.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	post.CreatedAt = time.Unix(created, createdNsec)
+	post.UpdatedAt = time.Unix(updated, updatedNsec)
+	post.Tags = splitTags(tags)
+	if deletedAt.Valid {
+		t := time.Unix(deletedAt.Int64, 0)
+		post.DeletedAt = &t
+	}
+
+	return &post, nil
+}
+
+//revisionColumns are columns of post_revisions table aliased as p in the order read by scanRevision.
+var revisionColumns = "p.action, p.editor, p.revised_at, p.revised_nsec, p.changed, " + postColumns(false)
+
+//scanRevision reads a row of revisionColumns.
+func scanRevision(row interface{ Scan(...interface{}) error }) (*Revision, error) {
+	var (
+		rev                    Revision
+		revisedAt, revisedNsec int64
+		changed                string
+	)
+	post, err := scanPost(row, &rev.Action, &rev.Editor, &revisedAt, &revisedNsec, &changed)
+	if err != nil {
+		return nil, err
+	}
+
+	rev.Number = post.Version
+	rev.CreatedAt = time.Unix(revisedAt, revisedNsec)
+	if changed != "" {
+		rev.Changed = strings.Split(changed, ",")
+	}
+	rev.Post = post
+
+	return &rev, nil
+}
@@ -0,0 +1,125 @@
+package post
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+//newTestSQLRepository creates a repository backed by an SQLite file in a temporary directory.
+func newTestSQLRepository(t *testing.T) Repository {
+	db, err := sql.Open(SQLite, filepath.Join(t.TempDir(), "posts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := MigrateSQL(context.Background(), db, SQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSQLRepository(db, SQLite)
+}
+
+func TestMigrateSQL(t *testing.T) {
+	db, err := sql.Open(SQLite, filepath.Join(t.TempDir(), "posts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, MigrateSQL(ctx, db, SQLite))
+	}
+
+	var version int
+	if assert.NoError(t, db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)) {
+		assert.Equal(t, len(sqlMigrations), version)
+	}
+
+	var indexes int
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name IN ('posts_author', 'posts_name', 'posts_created_at')").Scan(&indexes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, indexes)
+	}
+
+	assert.Error(t, MigrateSQL(ctx, db, "mysql"))
+}
+
+func TestFillMatchKeys(t *testing.T) {
+	repo := newTestSQLRepository(t)
+	db := repo.(sqlRepository).db
+	ctx := context.Background()
+
+	//Posts stored before migration 7 have empty keys.
+	_, err := db.ExecContext(ctx, "INSERT INTO posts (name, author, created_at, version) VALUES ('My  Post', ' VT', 1, 1)")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, inTx(ctx, db, func(tx *sql.Tx) error { return fillMatchKeys(ctx, tx) }))
+
+	var name, author string
+	if assert.NoError(t, db.QueryRowContext(ctx, "SELECT name_key, author_key FROM posts").Scan(&name, &author)) {
+		assert.Equal(t, "my post", name)
+		assert.Equal(t, "vt", author)
+	}
+}
+
+func TestSQLRemoveTerms(t *testing.T) {
+	repo := newTestSQLRepository(t)
+	ctx := context.Background()
+
+	id, err := repo.Create(ctx, &Post{Name: "robots", Author: "vt"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = repo.Remove(ctx, id, AnyVersion)
+	assert.NoError(t, err)
+
+	var terms int
+	db := repo.(sqlRepository).db
+	if assert.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM post_terms").Scan(&terms)) {
+		assert.Equal(t, 0, terms)
+	}
+}
+
+//TestSQLExportPages checks that Export releases the only connection of the test database between pages,
+//so the export callback can query the repository.
+func TestSQLExportPages(t *testing.T) {
+	repo := newTestSQLRepository(t)
+	ctx := context.Background()
+
+	posts := make([]*Post, exportPageSize)
+	for i := range posts {
+		posts[i] = &Post{Name: "test", Author: "vt"}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := repo.CreateMany(ctx, posts, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.Remove(ctx, 1, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	//Full exports include the trashed post.
+	for full, expected := range map[bool]int{false: 2*exportPageSize - 1, true: 2 * exportPageSize} {
+		var exported int
+		err := repo.Export(ctx, full, func(rec *Record) error {
+			exported++
+			_, err := repo.Revisions(ctx, rec.ID)
+			return err
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, exported)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package post
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//Tag limits
+const (
+	maxTags      = 20
+	maxTagLength = 50
+)
+
+//tagSeparator joins tags stored in a single field, so it can't be a part of a tag.
+const tagSeparator = ","
+
+//normalizeTags trims and lower-cases tags, drops duplicates and sorts the rest.
+//Empty tags, tags with commas and too many or too long tags are invalid.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(norm.NFC.String(tag)))
+		switch {
+		case tag == "":
+			return nil, Errorf(ErrInvalid, "tags can't be empty")
+		case strings.Contains(tag, tagSeparator):
+			return nil, Errorf(ErrInvalid, "tag %q can't contain %q", tag, tagSeparator)
+		case len([]rune(tag)) > maxTagLength:
+			return nil, Errorf(ErrInvalid, "tags can't be longer than %v characters", maxTagLength)
+		case seen[tag]:
+			continue
+		}
+
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > maxTags {
+		return nil, Errorf(ErrInvalid, "a post can't have more than %v tags", maxTags)
+	}
+
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+//joinTags returns tags as a single field value.
+func joinTags(tags []string) string {
+	return strings.Join(tags, tagSeparator)
+}
+
+//splitTags parses a field value written by joinTags. Empty value means no tags.
+func splitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, tagSeparator)
+}
+
+//hasTags reports whether a post with tags matches tag filter options.
+func hasTags(tags []string, filter *SearchFilter) bool {
+	if len(filter.Tags) == 0 {
+		return true
+	}
+
+	found := 0
+	for _, want := range filter.Tags {
+		for _, tag := range tags {
+			if tag == want {
+				found++
+				break
+			}
+		}
+	}
+
+	if filter.AnyTag {
+		return found != 0
+	}
+
+	return found == len(filter.Tags)
+}
+
+//diffTags returns tags removed from old and added to updated.
+func diffTags(old, updated []string) (removed, added []string) {
+	had := make(map[string]bool, len(old))
+	for _, tag := range old {
+		had[tag] = true
+	}
+
+	for _, tag := range updated {
+		if had[tag] {
+			delete(had, tag)
+			continue
+		}
+		added = append(added, tag)
+	}
+
+	for _, tag := range old {
+		if had[tag] {
+			removed = append(removed, tag)
+		}
+	}
+
+	return removed, added
+}
@@ -0,0 +1,80 @@
+package post
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//stopwords are too common to be useful as search terms.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true,
+	"that": true, "the": true, "this": true, "to": true, "with": true,
+}
+
+//term is a search term and the number of its occurrences in a text.
+type term struct {
+	value string
+	count int
+}
+
+//tokenize splits text into search terms in order of their first occurrence.
+//Terms are lower-cased, stripped of diacritics and stemmed, stopwords are dropped.
+func tokenize(text string) []term {
+	//Decompose characters to drop combining marks, e.g. "é" becomes "e".
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(text) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	words := strings.FieldsFunc(b.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	terms := make([]term, 0, len(words))
+	index := make(map[string]int, len(words))
+	for _, word := range words {
+		if stopwords[word] {
+			continue
+		}
+
+		word = stem(word)
+		if i, ok := index[word]; ok {
+			terms[i].count++
+			continue
+		}
+
+		index[word] = len(terms)
+		terms = append(terms, term{word, 1})
+	}
+
+	return terms
+}
+
+//stem strips common English inflectional suffixes, so "posts", "posting" and "posted" become "post".
+func stem(word string) string {
+	runes := []rune(word)
+	length := len(runes)
+
+	switch {
+	case length > 4 && strings.HasSuffix(word, "ies"):
+		return string(runes[:length-3]) + "y"
+	case length > 4 && strings.HasSuffix(word, "sses"):
+		return string(runes[:length-2])
+	case length > 5 && strings.HasSuffix(word, "ing"):
+		return string(runes[:length-3])
+	case length > 4 && strings.HasSuffix(word, "ed"):
+		return string(runes[:length-2])
+	case length > 4 && strings.HasSuffix(word, "ly"):
+		return string(runes[:length-2])
+	case length > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		return string(runes[:length-1])
+	}
+
+	return word
+}
@@ -0,0 +1,45 @@
+package post
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []term
+	}{
+		{
+			name:     "Lower-case and stopwords.",
+			text:     "Intro to Golang",
+			expected: []term{{"intro", 1}, {"golang", 1}},
+		},
+		{
+			name:     "Diacritics and punctuation.",
+			text:     "Café, crème-brûlée!",
+			expected: []term{{"cafe", 1}, {"creme", 1}, {"brulee", 1}},
+		},
+		{
+			name:     "Stemming and term frequency.",
+			text:     "Posts posted about posting",
+			expected: []term{{"post", 3}, {"about", 1}},
+		},
+		{
+			name:     "Short words and suffix exceptions.",
+			text:     "bus class stories is",
+			expected: []term{{"bus", 1}, {"class", 1}, {"story", 1}},
+		},
+		{
+			name:     "Only stopwords.",
+			text:     "the of and",
+			expected: []term{},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, tokenize(test.text), test.name)
+	}
+}
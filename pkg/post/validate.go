@@ -0,0 +1,128 @@
+package post
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//Field limits in characters.
+const (
+	maxNameLength   = 200
+	maxAuthorLength = 100
+)
+
+//FieldError describes why a post field is invalid.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+//ValidationError lists every invalid field of a post. errors.Is reports true for it and ErrInvalid.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+//Error describes the first invalid field.
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 0 {
+		return "post is invalid"
+	}
+
+	return fmt.Sprintf("%v field %v.", e.Fields[0].Field, e.Fields[0].Reason)
+}
+
+//Is reports whether target is ErrInvalid.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+//ValidatePost checks a post written by a client or an import against opts and returns its normalized copy.
+//Text fields are NFC normalized, names and authors are trimmed. The error is a *ValidationError with all invalid fields.
+func ValidatePost(post *Post, opts Options) (*Post, error) {
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+
+	var fields []FieldError
+	invalid := func(field, format string, args ...interface{}) {
+		fields = append(fields, FieldError{field, fmt.Sprintf(format, args...)})
+	}
+
+	copied := *post
+	copied.Author = strings.TrimSpace(norm.NFC.String(post.Author))
+	copied.Name = strings.TrimSpace(norm.NFC.String(post.Name))
+	copied.Body = norm.NFC.String(post.Body)
+
+	switch {
+	case copied.Author == "":
+		invalid("author", "cannot be empty")
+	case utf8.RuneCountInString(copied.Author) > maxAuthorLength:
+		invalid("author", "cannot be longer than %v characters", maxAuthorLength)
+	case hasControl(copied.Author, ""):
+		invalid("author", "cannot contain control characters")
+	case !matchesAny(copied.Author, opts.AuthorPatterns):
+		invalid("author", "doesn't match any allowed author pattern")
+	}
+
+	switch {
+	case copied.Name == "":
+		invalid("name", "cannot be empty")
+	case utf8.RuneCountInString(copied.Name) > maxNameLength:
+		invalid("name", "cannot be longer than %v characters", maxNameLength)
+	case hasControl(copied.Name, ""):
+		invalid("name", "cannot contain control characters")
+	}
+
+	//Bodies are multiline text, line breaks and tabs are allowed.
+	switch {
+	case len(copied.Body) > opts.MaxBodySize:
+		invalid("body", "cannot be larger than %v bytes", opts.MaxBodySize)
+	case hasControl(copied.Body, "\n\r\t"):
+		invalid("body", "cannot contain control characters other than line breaks and tabs")
+	}
+
+	switch copied.Format {
+	case "", FormatPlain, FormatMarkdown:
+	default:
+		invalid("format", "must be either %v or %v", FormatPlain, FormatMarkdown)
+	}
+
+	tags, err := normalizeTags(post.Tags)
+	if err != nil {
+		invalid("tags", "is invalid: %v", err)
+	}
+	copied.Tags = tags
+
+	if len(fields) != 0 {
+		return nil, &ValidationError{fields}
+	}
+
+	return &copied, nil
+}
+
+//hasControl reports whether s contains control characters except allowed ones.
+func hasControl(s string, allowed string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsControl(r) && !strings.ContainsRune(allowed, r)
+	}) != -1
+}
+
+//matchesAny reports whether s matches any of patterns. Any string matches if there are no patterns.
+func matchesAny(s string, patterns []*regexp.Regexp) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
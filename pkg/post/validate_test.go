@@ -0,0 +1,74 @@
+package post
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePost(t *testing.T) {
+	opts := Options{MaxBodySize: 8, AuthorPatterns: []*regexp.Regexp{regexp.MustCompile(`^[a-z]+$`), regexp.MustCompile(`^bot-\d+$`)}}
+
+	tests := []struct {
+		name     string
+		post     *Post
+		expected *Post
+		fields   []FieldError
+	}{
+		{
+			name:     "Trimmed and normalized.",
+			post:     &Post{Name: " cafe\u0301 ", Author: "vt\t", Body: "a\r\n\tb", Tags: []string{"Go"}},
+			expected: &Post{Name: "caf\u00e9", Author: "vt", Body: "a\r\n\tb", Tags: []string{"go"}},
+		},
+		{
+			name:     "Second author pattern.",
+			post:     &Post{Name: "test", Author: "bot-7"},
+			expected: &Post{Name: "test", Author: "bot-7"},
+		},
+		{
+			name:   "Whitespace only.",
+			post:   &Post{Name: " \n", Author: " "},
+			fields: []FieldError{{"author", "cannot be empty"}, {"name", "cannot be empty"}},
+		},
+		{
+			name: "Every field is invalid.",
+			post: &Post{Name: "a\x00b", Author: "Robot", Body: "123456789", Format: "html", Tags: []string{""}},
+			fields: []FieldError{
+				{"author", "doesn't match any allowed author pattern"},
+				{"name", "cannot contain control characters"},
+				{"body", "cannot be larger than 8 bytes"},
+				{"format", "must be either plain or markdown"},
+				{"tags", "is invalid: tags can't be empty"},
+			},
+		},
+		{
+			name:   "Too long.",
+			post:   &Post{Name: strings.Repeat("é", maxNameLength+1), Author: strings.Repeat("a", maxAuthorLength+1)},
+			fields: []FieldError{{"author", "cannot be longer than 100 characters"}, {"name", "cannot be longer than 200 characters"}},
+		},
+		{
+			name:   "Control characters.",
+			post:   &Post{Name: "test", Author: "v\u0085t", Body: "\x1b[0m"},
+			fields: []FieldError{{"author", "cannot contain control characters"}, {"body", "cannot contain control characters other than line breaks and tabs"}},
+		},
+	}
+
+	for _, test := range tests {
+		validated, err := ValidatePost(test.post, opts)
+		if test.fields == nil {
+			if assert.NoError(t, err, test.name) {
+				assert.Equal(t, test.expected, validated, test.name)
+			}
+			continue
+		}
+
+		var valErr *ValidationError
+		if assert.ErrorAs(t, err, &valErr, test.name) {
+			assert.ErrorIs(t, err, ErrInvalid, test.name)
+			assert.Equal(t, test.fields, valErr.Fields, test.name)
+			assert.Equal(t, test.fields[0].Field+" field "+test.fields[0].Reason+".", err.Error(), test.name)
+		}
+	}
+}